@@ -1,9 +1,7 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -40,22 +38,16 @@ type jwtClaims struct {
 }
 
 func (svc *serviceContext) authenticate(c *gin.Context) {
-	log.Printf("Checking authentication headers...")
-	// log.Printf("Dump all request headers ==================================")
-	// for name, values := range c.Request.Header {
-	// 	for _, value := range values {
-	// 		log.Printf("%s=%s\n", name, value)
-	// 	}
-	// }
-	// log.Printf("END header dump ===========================================")
+	logger := getLogger(c.Request.Context())
+	logger.Info("check authentication headers")
 
 	computingID := c.GetHeader("remote_user")
 	if svc.DevAuthUser != "" {
 		computingID = svc.DevAuthUser
-		log.Printf("Using dev auth user ID: %s", computingID)
+		logger.Info("use dev auth user", "computeID", computingID)
 	}
 	if computingID == "" {
-		log.Printf("ERROR: Expected auth header not present in request. Not authorized.")
+		logger.Error("expected auth header not present in request; not authorized")
 		c.Redirect(http.StatusFound, "/forbidden")
 		return
 	}
@@ -65,28 +57,38 @@ func (svc *serviceContext) authenticate(c *gin.Context) {
 		// Membership format: cn=group_name1;cn=group_name2;...
 		membershipStr := c.GetHeader("member")
 		if !strings.Contains(membershipStr, "lb-digiserv") {
-			log.Printf("ERROR: %s is not part of digiserv", computingID)
+			logger.Error("user is not part of digiserv", "computeID", computingID)
 			c.Redirect(http.StatusFound, "/forbidden")
 			return
 		}
 	}
 
-	log.Printf("INFO: lookup staff member %s", computingID)
-	respBytes, reqErr := svc.getRequest(fmt.Sprintf("%s/staff/lookup?cid=%s", svc.TrackSys.API, computingID))
-	if reqErr != nil {
-		log.Printf("ERROR: could not find staff member %s: %s", computingID, reqErr.Message)
+	logger = logger.With("computeID", computingID)
+	logger.Info("lookup staff member")
+	sm, err := svc.TrackSysAPI.staffByComputingID(c.Request.Context(), computingID)
+	if err != nil {
+		logger.Error("could not find staff member", "error", err.Error())
 		c.Redirect(http.StatusFound, "/forbidden")
 		return
 	}
 
-	var sm staffMember
-	if err := json.Unmarshal(respBytes, &sm); err != nil {
-		log.Printf("ERROR: could not parse staff member %s reaponse: %s", computingID, err.Error())
+	logger.Info("generate jwt")
+	signedStr, jwtErr := svc.generateJWT(computingID, sm)
+	if jwtErr != nil {
+		logger.Error("unable to generate jwt", "error", jwtErr.Error())
 		c.Redirect(http.StatusFound, "/forbidden")
 		return
 	}
 
-	log.Printf("Generate JWT for %s", computingID)
+	// Set auth info in a cookie the client can read and pass along in future requests
+	c.SetCookie("dpg_jwt", signedStr, 10, "/", "", false, false)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.Redirect(http.StatusFound, "/granted")
+}
+
+// generateJWT creates a signed token for a staff member. It is used for browser sign in and by
+// background jobs that need to call dpg-jobs on behalf of the user that started them
+func (svc *serviceContext) generateJWT(computingID string, sm *staffMember) (string, error) {
 	expirationTime := time.Now().Add(8 * time.Hour)
 	claims := jwtClaims{
 		UserID:    sm.ID,
@@ -101,48 +103,39 @@ func (svc *serviceContext) authenticate(c *gin.Context) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedStr, jwtErr := token.SignedString([]byte(svc.JWTKey))
-	if jwtErr != nil {
-		log.Printf("ERROR: unable to generate JWT for %s: %s", computingID, jwtErr.Error())
-		c.Redirect(http.StatusFound, "/forbidden")
-		return
-	}
-
-	// Set auth info in a cookie the client can read and pass along in future requests
-	c.SetCookie("dpg_jwt", signedStr, 10, "/", "", false, false)
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.Redirect(http.StatusFound, "/granted")
+	return token.SignedString([]byte(svc.JWTKey))
 }
 
 // AuthMiddleware is middleware that checks for a user auth token in the
 // Authorization header. For now, it does nothing but ensure token presence.
 func (svc *serviceContext) authMiddleware(c *gin.Context) {
-	log.Printf("Authorize access to %s", c.Request.URL)
+	logger := getLogger(c.Request.Context())
+	logger.Debug("authorize access", "url", c.Request.URL.String())
 	tokenStr, err := getBearerToken(c.Request.Header.Get("Authorization"))
 	if err != nil {
-		log.Printf("Authentication failed: [%s]", err.Error())
+		logger.Warn("authentication failed", "error", err.Error())
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
 	if tokenStr == "undefined" {
-		log.Printf("Authentication failed; bearer token is undefined")
+		logger.Warn("authentication failed; bearer token is undefined")
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	log.Printf("Validating JWT auth token...")
 	jwtClaims := jwtClaims{}
 	_, jwtErr := jwt.ParseWithClaims(tokenStr, &jwtClaims, func(token *jwt.Token) (any, error) {
 		return []byte(svc.JWTKey), nil
 	})
 	if jwtErr != nil {
-		log.Printf("Authentication failed; token validation failed: %+v", jwtErr)
+		logger.Warn("authentication failed; token validation failed", "error", jwtErr.Error())
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	log.Printf("got valid bearer token: [%s] for %s", tokenStr, jwtClaims.ComputeID)
+	logger.Debug("valid bearer token", "computeID", jwtClaims.ComputeID)
+	addLogAttrs(c, "computeID", jwtClaims.ComputeID)
 	c.Set("jwt", tokenStr)
 	c.Set("claims", jwtClaims)
 	c.Next()
@@ -165,7 +158,6 @@ func getJWTClaims(c *gin.Context) *jwtClaims {
 	if !ok {
 		return nil
 	}
-	log.Printf("INFO: pulled claims from context [%+v]", jwtClaims)
 	return &jwtClaims
 }
 
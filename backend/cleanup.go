@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -37,7 +38,7 @@ func (svc *serviceContext) cleanupOldProjects(c *gin.Context) {
 		failCnt := 0
 		for _, proj := range projs {
 			log.Printf("INFO: remove project %d finished at %s", proj.ID, proj.FinishedAt.Format("2006-01-02"))
-			if err := svc.doProjectDelete(proj.ID); err != nil {
+			if err := svc.doProjectDelete(context.Background(), proj.ID); err != nil {
 				log.Printf("ERROR: unable to delete project %d: %s", proj.ID, err.Error())
 				failCnt++
 			} else {
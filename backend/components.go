@@ -1,8 +1,6 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 
@@ -12,15 +10,11 @@ import (
 func (svc *serviceContext) getComponent(c *gin.Context) {
 	cid := c.Param("id")
 	log.Printf("INFO: lookup component %s", cid)
-	cBytes, reqErr := svc.getRequest(fmt.Sprintf("%s/components/%s", svc.TrackSys.API, cid))
-	if reqErr != nil {
-		c.String(reqErr.StatusCode, reqErr.Message)
-	}
-	var cmp any
-	if err := json.Unmarshal(cBytes, &cmp); err != nil {
-		log.Printf("ERROR: unable to parse component response: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+	cmp, err := svc.TrackSysAPI.component(c.Request.Context(), cid)
+	if err != nil {
+		log.Printf("ERROR: unable to get component %s: %s", cid, err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, cmp)
+	c.Data(http.StatusOK, "application/json", cmp)
 }
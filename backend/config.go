@@ -2,7 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/goccy/go-yaml"
 )
 
 type dbConfig struct {
@@ -20,96 +26,276 @@ type tracksysURLs struct {
 }
 
 type configData struct {
-	port        int
-	db          dbConfig
-	imagesDir   string
-	scanDir     string
-	finalizeDir string
-	iiifURL     string
-	serviceURL  string
-	tracksys    tracksysURLs
-	jwtKey      string
-	devAuthUser string
+	port             int
+	db               dbConfig
+	migrate          bool
+	migrationsTable  string
+	imagesDir        string
+	scanDir          string
+	finalizeDir      string
+	iiifURL          string
+	serviceURL       string
+	tracksys         tracksysURLs
+	tracksysCacheTTL int
+	mockTrackSys     bool
+	mockPort         int
+	jwtKey           string
+	devAuthUser      string
+	jobWorkers       int
+	exifWorkers      int
+	exifTimeout      int
+	metaCacheSize    int
+	metaCacheFile    string
+	watchDirs        bool
+	drainTimeout     int
+	trashDays        int
+	checksum         string
 }
 
-func getConfiguration() *configData {
+// addDBFlags adds the DB connection params to the flag set. These are shared with the migrate command
+func addDBFlags(fs *flag.FlagSet, db *dbConfig) {
+	fs.StringVar(&db.Host, "dbhost", "", "Database host")
+	fs.IntVar(&db.Port, "dbport", 3306, "Database port")
+	fs.StringVar(&db.Name, "dbname", "", "Database name")
+	fs.StringVar(&db.User, "dbuser", "", "Database user")
+	fs.StringVar(&db.Pass, "dbpass", "", "Database password")
+}
+
+// loadConfiguration builds the configuration from the args, environment and config file. Each setting comes
+// from the first of these that has it: command line flag, DPG_ environment variable, config file, default.
+// All problems are returned together rather than stopping at the first.
+func loadConfiguration(args []string) (*configData, []string) {
 	var config configData
-	flag.IntVar(&config.port, "port", 8080, "Port to offer service on (default 8085)")
-	flag.StringVar(&config.imagesDir, "images", "/digiserv-production/dpg_imaging", "Images directory")
-	flag.StringVar(&config.scanDir, "scan", "/digiserv-production/scan", "Scanning directory")
-	flag.StringVar(&config.finalizeDir, "finalize", " /digiserv-production/finalization", "Finalization directory")
-	flag.StringVar(&config.iiifURL, "iiif", "", "IIIF server URL")
-	flag.StringVar(&config.serviceURL, "url", "", "Base URL for DPG Imaging service")
-	flag.StringVar(&config.jwtKey, "jwtkey", "", "JWT signature key")
+	var configFile string
+	fs := flag.NewFlagSet("imagingsvc", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", os.Getenv("DPG_CONFIG"), "YAML config file with settings keyed by flag name")
+	fs.IntVar(&config.port, "port", 8080, "Port to offer service on (default 8085)")
+	fs.StringVar(&config.imagesDir, "images", "/digiserv-production/dpg_imaging", "Images directory")
+	fs.StringVar(&config.scanDir, "scan", "/digiserv-production/scan", "Scanning directory")
+	fs.StringVar(&config.finalizeDir, "finalize", "/digiserv-production/finalization", "Finalization directory")
+	fs.StringVar(&config.iiifURL, "iiif", "", "IIIF server URL")
+	fs.StringVar(&config.serviceURL, "url", "", "Base URL for DPG Imaging service")
+	fs.StringVar(&config.jwtKey, "jwtkey", "", "JWT signature key")
+	fs.IntVar(&config.jobWorkers, "jobworkers", 2, "Number of background job workers")
+	fs.IntVar(&config.exifWorkers, "exifworkers", 4, "Number of exiftool processes; the limit on concurrent exiftool calls")
+	fs.IntVar(&config.exifTimeout, "exiftimeout", 120, "Timeout in seconds for a single exiftool call")
+	fs.IntVar(&config.metaCacheSize, "metacachesize", 50000, "Max number of master files in the metadata cache")
+	fs.StringVar(&config.metaCacheFile, "metacache", "", "Optional file used to persist the metadata cache across restarts")
+	fs.BoolVar(&config.watchDirs, "watch", true, "Watch the scan and images directories for file activity")
+	fs.IntVar(&config.drainTimeout, "draintimeout", 120, "Seconds to wait for in-flight unit operations and jobs at shutdown")
+	fs.StringVar(&config.checksum, "checksum", "sha256", "Checksum algorithm for verified copies and unit manifests: sha256 or md5")
+	fs.IntVar(&config.trashDays, "trashdays", 30, "Days to keep deleted master files in the trash before the trash cleanup purges them")
 
 	// tracksys config
-	flag.StringVar(&config.tracksys.API, "tsapiurl", "https://tracksys-api-ws.internal.lib.virginia.edu/api", "URL for TrackSysAPI service")
-	flag.StringVar(&config.tracksys.Client, "tsurl", "https://tracksys.lib.virginia.edu", "URL for TrackSys")
-	flag.StringVar(&config.tracksys.Jobs, "jobsurl", "", "URL for dpg-jobs processing")
+	fs.StringVar(&config.tracksys.API, "tsapiurl", "https://tracksys-api-ws.internal.lib.virginia.edu/api", "URL for TrackSysAPI service")
+	fs.StringVar(&config.tracksys.Client, "tsurl", "https://tracksys.lib.virginia.edu", "URL for TrackSys")
+	fs.StringVar(&config.tracksys.Jobs, "jobsurl", "", "URL for dpg-jobs processing")
+	fs.IntVar(&config.tracksysCacheTTL, "tscachettl", 300, "Seconds to cache TrackSys reference data (staff, customers, agencies, container types, OCR)")
 
 	// DB connection params
-	flag.StringVar(&config.db.Host, "dbhost", "", "Database host")
-	flag.IntVar(&config.db.Port, "dbport", 3306, "Database port")
-	flag.StringVar(&config.db.Name, "dbname", "", "Database name")
-	flag.StringVar(&config.db.User, "dbuser", "", "Database user")
-	flag.StringVar(&config.db.Pass, "dbpass", "", "Database password")
+	addDBFlags(fs, &config.db)
+	fs.BoolVar(&config.migrate, "migrate", true, "Apply pending schema migrations at startup")
+	fs.StringVar(&config.migrationsTable, "migrationstable", "schema_migrations", "Table used to track the schema version")
 
 	// dev setup
-	flag.StringVar(&config.devAuthUser, "devuser", "", "Authorized computing id for dev")
+	fs.StringVar(&config.devAuthUser, "devuser", "", "Authorized computing id for dev")
+	fs.BoolVar(&config.mockTrackSys, "mock-tracksys", false, "Use built in mock TrackSys API and dpg-jobs services for dev")
+	fs.IntVar(&config.mockPort, "mockport", 8095, "Port for the mock TrackSys API and dpg-jobs services")
 
-	flag.Parse()
+	fs.Parse(args)
+	errs := applyConfigLayers(fs, configFile)
+
+	if config.mockTrackSys {
+		config.tracksys.API, config.tracksys.Jobs = mockTrackSysURLs(config.mockPort)
+	}
 
 	if config.jwtKey == "" {
-		log.Fatal("Parameter jwtkey is required")
+		errs = append(errs, "Parameter jwtkey is required")
 	}
 	if config.imagesDir == "" {
-		log.Fatal("images param is required")
+		errs = append(errs, "images param is required")
 	}
 	if config.finalizeDir == "" {
-		log.Fatal("finalize param is required")
+		errs = append(errs, "finalize param is required")
 	}
 	if config.iiifURL == "" {
-		log.Fatal("iiif param is required")
+		errs = append(errs, "iiif param is required")
 	}
 	if config.serviceURL == "" {
-		log.Fatal("url param is required")
+		errs = append(errs, "url param is required")
+	}
+	if config.jobWorkers < 1 {
+		errs = append(errs, "jobworkers param must be at least 1")
+	}
+	if config.exifWorkers < 1 {
+		errs = append(errs, "exifworkers param must be at least 1")
+	}
+	if config.exifTimeout < 1 {
+		errs = append(errs, "exiftimeout param must be at least 1")
+	}
+	if config.drainTimeout < 0 {
+		errs = append(errs, "draintimeout param cannot be negative")
+	}
+	if _, found := checksumAlgorithms[config.checksum]; !found {
+		errs = append(errs, "checksum param must be sha256 or md5")
+	}
+	if config.trashDays < 1 {
+		errs = append(errs, "trashdays param must be at least 1")
+	}
+	if config.metaCacheSize < 1 {
+		errs = append(errs, "metacachesize param must be at least 1")
 	}
 	if config.db.Host == "" {
-		log.Fatal("Parameter dbhost is required")
+		errs = append(errs, "Parameter dbhost is required")
 	}
 	if config.db.Name == "" {
-		log.Fatal("Parameter dbname is required")
+		errs = append(errs, "Parameter dbname is required")
 	}
 	if config.db.User == "" {
-		log.Fatal("Parameter dbuser is required")
+		errs = append(errs, "Parameter dbuser is required")
 	}
 	if config.db.Pass == "" {
-		log.Fatal("Parameter dbpass is required")
+		errs = append(errs, "Parameter dbpass is required")
 	}
 
 	if config.tracksys.API == "" {
-		log.Fatal("Parameter tsapiurl is required")
+		errs = append(errs, "Parameter tsapiurl is required")
 	}
 	if config.tracksys.Client == "" {
-		log.Fatal("Parameter tsurl is required")
+		errs = append(errs, "Parameter tsurl is required")
 	}
 	if config.tracksys.Jobs == "" {
-		log.Fatal("Parameter jobsurl is required")
-	}
-
-	log.Printf("[CONFIG] port          = [%d]", config.port)
-	log.Printf("[CONFIG] imagesDir     = [%s]", config.imagesDir)
-	log.Printf("[CONFIG] scanDir       = [%s]", config.scanDir)
-	log.Printf("[CONFIG] finalizeDir   = [%s]", config.finalizeDir)
-	log.Printf("[CONFIG] iiifURL       = [%s]", config.iiifURL)
-	log.Printf("[CONFIG] serviceURL    = [%s]", config.serviceURL)
-	log.Printf("[CONFIG] tracksysAPI   = [%s]", config.tracksys.API)
-	log.Printf("[CONFIG] tracksysURL   = [%s]", config.tracksys.Client)
-	log.Printf("[CONFIG] jobsURL       = [%s]", config.tracksys.Jobs)
-	log.Printf("[CONFIG] dbhost        = [%s]", config.db.Host)
-	log.Printf("[CONFIG] dbport        = [%d]", config.db.Port)
-	log.Printf("[CONFIG] dbname        = [%s]", config.db.Name)
-	log.Printf("[CONFIG] dbuser        = [%s]", config.db.User)
-
-	return &config
+		errs = append(errs, "Parameter jobsurl is required")
+	}
+	if config.tracksysCacheTTL < 0 {
+		errs = append(errs, "tscachettl param cannot be negative")
+	}
+
+	return &config, errs
+}
+
+// getConfiguration loads the configuration for the service. Any problems are reported together and stop the service
+func getConfiguration() *configData {
+	cfg, errs := loadConfiguration(os.Args[1:])
+	if len(errs) > 0 {
+		for _, msg := range errs {
+			log.Printf("ERROR: %s", msg)
+		}
+		log.Fatalf("configuration has %d problems", len(errs))
+	}
+	logConfiguration(cfg)
+	return cfg
+}
+
+// checkConfiguration handles `imagingsvc config check [flags]`. It reports the configuration and any problems
+// without starting the service
+func checkConfiguration(args []string) {
+	cfg, errs := loadConfiguration(args)
+	logConfiguration(cfg)
+	if len(errs) > 0 {
+		for _, msg := range errs {
+			log.Printf("ERROR: %s", msg)
+		}
+		log.Fatalf("configuration has %d problems", len(errs))
+	}
+	log.Printf("INFO: configuration is valid")
+}
+
+// applyConfigLayers sets each flag that was not given on the command line from the DPG_ environment
+// variable for the flag or from the config file
+func applyConfigLayers(fs *flag.FlagSet, configFile string) []string {
+	errs := make([]string, 0)
+	fileSettings := make(map[string]any)
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("unable to read config file %s: %s", configFile, err.Error()))
+		} else if err := yaml.Unmarshal(data, &fileSettings); err != nil {
+			errs = append(errs, fmt.Sprintf("unable to parse config file %s: %s", configFile, err.Error()))
+		}
+		for name := range fileSettings {
+			if fs.Lookup(name) == nil || name == "config" {
+				errs = append(errs, fmt.Sprintf("config file %s has unknown setting %s", configFile, name))
+			}
+		}
+	}
+
+	cmdLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		cmdLine[f.Name] = true
+	})
+	fs.VisitAll(func(f *flag.Flag) {
+		if cmdLine[f.Name] || f.Name == "config" {
+			return
+		}
+		envName := configEnvName(f.Name)
+		if val, found := os.LookupEnv(envName); found {
+			if err := f.Value.Set(val); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value [%s] for %s: %s", val, envName, err.Error()))
+			}
+			return
+		}
+		if val, found := fileSettings[f.Name]; found {
+			// a setting with no value is left unset. Every setting is a single value, so lists and maps are rejected
+			if val == nil {
+				return
+			}
+			if kind := reflect.TypeOf(val).Kind(); kind == reflect.Slice || kind == reflect.Map {
+				errs = append(errs, fmt.Sprintf("invalid value for %s in %s: a single value is required", f.Name, configFile))
+				return
+			}
+			if err := f.Value.Set(fmt.Sprintf("%v", val)); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value [%v] for %s in %s: %s", val, f.Name, configFile, err.Error()))
+			}
+		}
+	})
+	return errs
+}
+
+// configEnvName returns the environment variable for a flag; dbpass is DPG_DBPASS and mock-tracksys is DPG_MOCK_TRACKSYS
+func configEnvName(flagName string) string {
+	return "DPG_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// redact hides a secret in the log while still showing if it has been set
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+func logConfiguration(cfg *configData) {
+	log.Printf("[CONFIG] port          = [%d]", cfg.port)
+	log.Printf("[CONFIG] imagesDir     = [%s]", cfg.imagesDir)
+	log.Printf("[CONFIG] scanDir       = [%s]", cfg.scanDir)
+	log.Printf("[CONFIG] finalizeDir   = [%s]", cfg.finalizeDir)
+	log.Printf("[CONFIG] iiifURL       = [%s]", cfg.iiifURL)
+	log.Printf("[CONFIG] serviceURL    = [%s]", cfg.serviceURL)
+	log.Printf("[CONFIG] jwtKey        = [%s]", redact(cfg.jwtKey))
+	log.Printf("[CONFIG] jobWorkers    = [%d]", cfg.jobWorkers)
+	log.Printf("[CONFIG] exifWorkers   = [%d]", cfg.exifWorkers)
+	log.Printf("[CONFIG] exifTimeout   = [%d]", cfg.exifTimeout)
+	log.Printf("[CONFIG] metaCacheSize = [%d]", cfg.metaCacheSize)
+	log.Printf("[CONFIG] metaCacheFile = [%s]", cfg.metaCacheFile)
+	log.Printf("[CONFIG] watchDirs     = [%t]", cfg.watchDirs)
+	log.Printf("[CONFIG] drainTimeout  = [%d]", cfg.drainTimeout)
+	log.Printf("[CONFIG] trashDays     = [%d]", cfg.trashDays)
+	log.Printf("[CONFIG] checksum      = [%s]", cfg.checksum)
+	log.Printf("[CONFIG] tracksysAPI   = [%s]", cfg.tracksys.API)
+	log.Printf("[CONFIG] tracksysURL   = [%s]", cfg.tracksys.Client)
+	log.Printf("[CONFIG] jobsURL       = [%s]", cfg.tracksys.Jobs)
+	log.Printf("[CONFIG] tsCacheTTL    = [%d]", cfg.tracksysCacheTTL)
+	log.Printf("[CONFIG] dbhost        = [%s]", cfg.db.Host)
+	log.Printf("[CONFIG] dbport        = [%d]", cfg.db.Port)
+	log.Printf("[CONFIG] dbname        = [%s]", cfg.db.Name)
+	log.Printf("[CONFIG] dbuser        = [%s]", cfg.db.User)
+	log.Printf("[CONFIG] dbpass        = [%s]", redact(cfg.db.Pass))
+	log.Printf("[CONFIG] migrate       = [%t]", cfg.migrate)
+	log.Printf("[CONFIG] migrationsTbl = [%s]", cfg.migrationsTable)
+	if cfg.mockTrackSys {
+		log.Printf("[CONFIG] mockTrackSys  = [%t]", cfg.mockTrackSys)
+		log.Printf("[CONFIG] mockPort      = [%d]", cfg.mockPort)
+	}
+
 }
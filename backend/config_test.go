@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigLayers(t *testing.T) {
+	apply := func(t *testing.T, settings string) (*flag.FlagSet, []string) {
+		configFile := path.Join(t.TempDir(), "config.yml")
+		if err := os.WriteFile(configFile, []byte(settings), 0666); err != nil {
+			t.Fatalf("unable to write config file: %s", err.Error())
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("images", "/default", "images directory")
+		fs.Int("port", 8080, "port")
+		return fs, applyConfigLayers(fs, configFile)
+	}
+
+	t.Run("settings are applied", func(t *testing.T) {
+		fs, errs := apply(t, "images: /mnt/images\nport: 9090\n")
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors %v", errs)
+		}
+		if fs.Lookup("images").Value.String() != "/mnt/images" || fs.Lookup("port").Value.String() != "9090" {
+			t.Errorf("expected settings from the config file, got images %s port %s", fs.Lookup("images").Value, fs.Lookup("port").Value)
+		}
+	})
+
+	t.Run("empty setting is left unset", func(t *testing.T) {
+		fs, errs := apply(t, "images:\nport: 9090\n")
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors %v", errs)
+		}
+		if val := fs.Lookup("images").Value.String(); val != "/default" {
+			t.Errorf("expected the default images directory, got %s", val)
+		}
+	})
+
+	t.Run("lists and maps are rejected", func(t *testing.T) {
+		fs, errs := apply(t, "images:\n  - /mnt/a\n  - /mnt/b\nport:\n  value: 9090\n")
+		if len(errs) != 2 || !strings.Contains(errs[0], "a single value is required") {
+			t.Fatalf("expected 2 single value errors, got %v", errs)
+		}
+		if val := fs.Lookup("images").Value.String(); val != "/default" {
+			t.Errorf("expected the default images directory, got %s", val)
+		}
+	})
+}
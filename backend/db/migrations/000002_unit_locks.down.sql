@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `unit_locks`;
@@ -0,0 +1,12 @@
+CREATE TABLE `unit_locks` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `unit_id` int NOT NULL,
+  `owner` varchar(255) NOT NULL,
+  `operation` varchar(50) NOT NULL,
+  `started_at` datetime NOT NULL,
+  `heartbeat_at` datetime NOT NULL,
+  `expires_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `index_unit_locks_on_unit_id` (`unit_id`),
+  KEY `index_unit_locks_on_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
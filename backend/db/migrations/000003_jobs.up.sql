@@ -0,0 +1,22 @@
+CREATE TABLE `jobs` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `job_type` varchar(50) NOT NULL,
+  `unit_id` int DEFAULT NULL,
+  `project_id` int DEFAULT NULL,
+  `user_id` int DEFAULT NULL,
+  `owner` varchar(255) DEFAULT NULL,
+  `status` varchar(20) NOT NULL DEFAULT 'pending',
+  `total` int DEFAULT '0',
+  `processed` int DEFAULT '0',
+  `problems` text,
+  `payload` mediumtext,
+  `error` text,
+  `created_at` datetime NOT NULL,
+  `updated_at` datetime NOT NULL,
+  `started_at` datetime DEFAULT NULL,
+  `finished_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_jobs_on_status` (`status`),
+  KEY `index_jobs_on_unit_id` (`unit_id`),
+  KEY `index_jobs_on_project_id` (`project_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
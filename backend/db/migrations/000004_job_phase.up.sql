@@ -0,0 +1 @@
+ALTER TABLE `jobs` ADD COLUMN `phase` varchar(50) NOT NULL DEFAULT '' AFTER `status`;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `unexpected_files`;
+ALTER TABLE `projects` DROP COLUMN `last_file_activity`;
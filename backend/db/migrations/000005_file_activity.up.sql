@@ -0,0 +1,13 @@
+ALTER TABLE `projects` ADD COLUMN `last_file_activity` datetime DEFAULT NULL;
+
+CREATE TABLE `unexpected_files` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `project_id` int NOT NULL,
+  `unit_id` int NOT NULL,
+  `path` varchar(1024) NOT NULL,
+  `reason` varchar(255) NOT NULL,
+  `detected_at` datetime NOT NULL,
+  `resolved_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_unexpected_files_on_project_id` (`project_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1 @@
+ALTER TABLE `jobs` ADD COLUMN `request_id` varchar(64) NOT NULL DEFAULT '' AFTER `error`;
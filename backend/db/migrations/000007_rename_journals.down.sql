@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `rename_journals`;
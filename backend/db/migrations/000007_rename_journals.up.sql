@@ -0,0 +1,16 @@
+CREATE TABLE `rename_journals` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `unit_id` int NOT NULL,
+  `job_id` bigint DEFAULT NULL,
+  `owner` varchar(255) DEFAULT NULL,
+  `status` varchar(20) NOT NULL,
+  `phase` varchar(20) NOT NULL,
+  `work_dir` varchar(1024) NOT NULL,
+  `entries` mediumtext,
+  `error` text,
+  `created_at` datetime NOT NULL,
+  `updated_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_rename_journals_on_unit_id` (`unit_id`),
+  KEY `index_rename_journals_on_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
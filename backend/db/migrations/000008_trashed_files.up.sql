@@ -0,0 +1,16 @@
+CREATE TABLE `trashed_files` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `unit_id` int NOT NULL,
+  `file_name` varchar(255) NOT NULL,
+  `original_path` varchar(1024) NOT NULL,
+  `trash_path` varchar(1024) NOT NULL DEFAULT '',
+  `file_size` bigint NOT NULL DEFAULT 0,
+  `metadata` text,
+  `deleted_by` varchar(255) NOT NULL,
+  `deleted_at` datetime NOT NULL,
+  `restored_by` varchar(255) DEFAULT NULL,
+  `restored_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_trashed_files_on_unit_id` (`unit_id`),
+  KEY `index_trashed_files_on_deleted_at` (`deleted_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,15 @@
+CREATE TABLE `file_events` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `unit_id` int NOT NULL,
+  `job_id` bigint NOT NULL DEFAULT 0,
+  `compute_id` varchar(255) NOT NULL,
+  `action` varchar(20) NOT NULL,
+  `file` varchar(255) NOT NULL,
+  `field` varchar(50) NOT NULL DEFAULT '',
+  `old_value` text,
+  `new_value` text,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_file_events_on_unit_id_and_file` (`unit_id`, `file`),
+  KEY `index_file_events_on_unit_id_and_compute_id` (`unit_id`, `compute_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
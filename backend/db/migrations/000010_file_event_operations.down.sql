@@ -0,0 +1,3 @@
+ALTER TABLE `file_events` DROP KEY `index_file_events_on_unit_id_and_operation_id`;
+ALTER TABLE `file_events` DROP COLUMN `undone`;
+ALTER TABLE `file_events` DROP COLUMN `operation_id`;
@@ -0,0 +1,3 @@
+ALTER TABLE `file_events` ADD COLUMN `operation_id` varchar(64) NOT NULL DEFAULT '' AFTER `job_id`;
+ALTER TABLE `file_events` ADD COLUMN `undone` tinyint(1) NOT NULL DEFAULT 0 AFTER `new_value`;
+ALTER TABLE `file_events` ADD KEY `index_file_events_on_unit_id_and_operation_id` (`unit_id`, `operation_id`);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `directory_move_files`;
+DROP TABLE IF EXISTS `directory_moves`;
@@ -0,0 +1,29 @@
+CREATE TABLE `directory_moves` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `project_id` int NOT NULL,
+  `unit_id` int NOT NULL,
+  `src_dir` varchar(1024) NOT NULL,
+  `dest_dir` varchar(1024) NOT NULL,
+  `checksum` varchar(20) NOT NULL,
+  `status` varchar(20) NOT NULL,
+  `total_files` int NOT NULL DEFAULT 0,
+  `total_bytes` bigint NOT NULL DEFAULT 0,
+  `copied_files` int NOT NULL DEFAULT 0,
+  `copied_bytes` bigint NOT NULL DEFAULT 0,
+  `error` text,
+  `created_at` datetime NOT NULL,
+  `updated_at` datetime NOT NULL,
+  `finished_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_directory_moves_on_project_id_and_status` (`project_id`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `directory_move_files` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `move_id` bigint NOT NULL,
+  `path` varchar(1024) NOT NULL,
+  `size` bigint NOT NULL DEFAULT 0,
+  `checksum` varchar(128) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_directory_move_files_on_move_id` (`move_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
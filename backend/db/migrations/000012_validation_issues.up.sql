@@ -0,0 +1,16 @@
+CREATE TABLE `validation_issues` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `project_id` int NOT NULL,
+  `assignment_id` int NOT NULL,
+  `note_id` int NOT NULL,
+  `step_id` int NOT NULL,
+  `file` varchar(255) NOT NULL DEFAULT '',
+  `rule` varchar(50) NOT NULL,
+  `severity` varchar(20) NOT NULL DEFAULT 'error',
+  `message` text,
+  `created_at` datetime NOT NULL,
+  `resolved_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_validation_issues_on_project_id_and_resolved_at` (`project_id`, `resolved_at`),
+  KEY `index_validation_issues_on_note_id` (`note_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
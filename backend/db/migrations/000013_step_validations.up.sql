@@ -0,0 +1,38 @@
+CREATE TABLE `step_validations` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `step_id` int NOT NULL,
+  `directory` varchar(20) NOT NULL DEFAULT 'images',
+  `directory_exists` tinyint(1) NOT NULL DEFAULT 1,
+  `only_tif` tinyint(1) NOT NULL DEFAULT 0,
+  `allow_notes` tinyint(1) NOT NULL DEFAULT 0,
+  `sequence_contiguous` tinyint(1) NOT NULL DEFAULT 0,
+  `title_required` tinyint(1) NOT NULL DEFAULT 0,
+  `location_required` tinyint(1) NOT NULL DEFAULT 0,
+  `component_required` tinyint(1) NOT NULL DEFAULT 0,
+  `container_type_required` tinyint(1) NOT NULL DEFAULT 0,
+  `finalize_prep` tinyint(1) NOT NULL DEFAULT 0,
+  `move_to` varchar(20) NOT NULL DEFAULT '',
+  `update_image_count` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `index_step_validations_on_step_id` (`step_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- the validations for the existing steps match the checks that were made by step and workflow name
+INSERT INTO `step_validations` (`step_id`, `directory`, `directory_exists`, `only_tif`, `allow_notes`, `sequence_contiguous`,
+  `title_required`, `location_required`, `component_required`, `container_type_required`, `finalize_prep`, `move_to`, `update_image_count`)
+SELECT s.`id`,
+  IF(s.`name` IN ('Scan', 'Process'), 'scan', 'images'),
+  1,
+  s.`name` NOT IN ('Scan', 'Process') AND s.`step_type` <> 2,
+  w.`name` = 'Manuscript',
+  s.`name` NOT IN ('Scan', 'Process') AND s.`step_type` <> 2,
+  s.`name` IN ('Create Metadata', 'Finalize') AND s.`step_type` <> 2,
+  s.`name` IN ('Create Metadata', 'Finalize') AND s.`step_type` <> 2 AND w.`name` = 'Manuscript',
+  0,
+  w.`name` = 'Manuscript',
+  COALESCE(n.`name` = 'Finalize', 0),
+  CASE s.`name` WHEN 'Process' THEN 'images' WHEN 'Finalize' THEN 'finalize' ELSE '' END,
+  s.`name` NOT IN ('Scan', 'Process', 'Create Metadata')
+FROM `steps` s
+  INNER JOIN `workflows` w ON w.`id` = s.`workflow_id`
+  LEFT JOIN `steps` n ON n.`id` = s.`next_step_id`;
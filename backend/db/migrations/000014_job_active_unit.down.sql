@@ -0,0 +1 @@
+ALTER TABLE `jobs` DROP INDEX `index_jobs_on_active_unit_id`, DROP COLUMN `active_unit_id`;
@@ -0,0 +1,2 @@
+ALTER TABLE `jobs` ADD COLUMN `active_unit_id` int DEFAULT NULL AFTER `unit_id`,
+  ADD UNIQUE KEY `index_jobs_on_active_unit_id` (`active_unit_id`);
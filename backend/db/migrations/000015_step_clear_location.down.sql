@@ -0,0 +1 @@
+ALTER TABLE `step_validations` DROP COLUMN `clear_location`;
@@ -0,0 +1,8 @@
+ALTER TABLE `step_validations` ADD COLUMN `clear_location` tinyint(1) NOT NULL DEFAULT 0 AFTER `finalize_prep`;
+
+-- manuscript finalization cleared the temporary box and folder by workflow name
+UPDATE `step_validations` sv
+  INNER JOIN `steps` s ON s.`id` = sv.`step_id`
+  INNER JOIN `workflows` w ON w.`id` = s.`workflow_id`
+SET sv.`clear_location` = 1
+WHERE sv.`finalize_prep` = 1 AND w.`name` = 'Manuscript';
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+)
+
+// The interfaces below cover the external tools and services used by the handlers. The service
+// context holds one of each so they can be replaced with fakes when exercising the handlers.
+
+// metadataTool reads and writes image metadata. It is implemented by the exiftool process pool
+type metadataTool interface {
+	run(args ...string) ([]byte, error)
+	shutdown()
+}
+
+// imageTool changes image content. It is implemented with ImageMagick
+type imageTool interface {
+	rotate(fullPath string, degrees string) ([]byte, error)
+}
+
+// fileMover moves, copies and removes files and directories. copyFile verifies the checksum of the
+// copy and returns it
+type fileMover interface {
+	rename(srcPath string, destPath string) error
+	remove(tgtPath string) error
+	removeAll(tgtPath string) error
+	mkdir(tgtDir string) error
+	copyFile(srcPath string, destPath string, algorithm string) (string, error)
+}
+
+// trackSysAPI gets reference data and unit details from TrackSys. It is implemented by tracksysClient
+type trackSysAPI interface {
+	staff(ctx context.Context) ([]staffMember, error)
+	staffByID(ctx context.Context, staffID uint) (*staffMember, error)
+	staffByComputingID(ctx context.Context, computingID string) (*staffMember, error)
+	customers(ctx context.Context) ([]customer, error)
+	agencies(ctx context.Context) ([]agency, error)
+	containerTypes(ctx context.Context) ([]containerType, error)
+	ocr(ctx context.Context) (*ocrInfo, error)
+	unit(ctx context.Context, unitID int64) (*unitInfo, error)
+	component(ctx context.Context, componentID string) (json.RawMessage, error)
+	status() tracksysStatus
+}
+
+// jobsAPI starts processing in dpg-jobs on behalf of the user identified by the JWT. It is implemented by jobsClient
+type jobsAPI interface {
+	finalizeUnit(ctx context.Context, unitID uint, jwt string) error
+	updateOCRSettings(ctx context.Context, unitID uint, settings any, jwt string) error
+}
+
+type magickTool struct{}
+
+func (mt magickTool) rotate(fullPath string, degrees string) ([]byte, error) {
+	return exec.Command("magick", fullPath, "-rotate", degrees, fullPath).CombinedOutput()
+}
+
+type localFileMover struct{}
+
+func (fm localFileMover) rename(srcPath string, destPath string) error {
+	return os.Rename(srcPath, destPath)
+}
+
+func (fm localFileMover) remove(tgtPath string) error {
+	return os.Remove(tgtPath)
+}
+
+func (fm localFileMover) removeAll(tgtPath string) error {
+	return os.RemoveAll(tgtPath)
+}
+
+func (fm localFileMover) mkdir(tgtDir string) error {
+	return os.Mkdir(tgtDir, 0777)
+}
+
+func (fm localFileMover) copyFile(srcPath string, destPath string, algorithm string) (string, error) {
+	return copyFileVerified(srcPath, destPath, algorithm)
+}
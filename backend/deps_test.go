@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The fakes below stand in for the external tools and services in deps.go. Tests run the handlers
+// against them, a temporary directory for the unit files and a SQLite database.
+
+// fakeTrackSys serves staff and units from maps. Units that have not been added are returned with
+// just their ID
+type fakeTrackSys struct {
+	staffMembers map[uint]*staffMember
+	units        map[int64]*unitInfo
+}
+
+func newFakeTrackSys() *fakeTrackSys {
+	return &fakeTrackSys{staffMembers: make(map[uint]*staffMember), units: make(map[int64]*unitInfo)}
+}
+
+func (ts *fakeTrackSys) addStaff(staffID uint, computingID string, role uint) {
+	ts.staffMembers[staffID] = &staffMember{ID: staffID, ComputingID: computingID, Role: role, Active: true}
+}
+
+func (ts *fakeTrackSys) staff(ctx context.Context) ([]staffMember, error) {
+	out := make([]staffMember, 0, len(ts.staffMembers))
+	for _, sm := range ts.staffMembers {
+		out = append(out, *sm)
+	}
+	return out, nil
+}
+
+func (ts *fakeTrackSys) staffByID(ctx context.Context, staffID uint) (*staffMember, error) {
+	if sm, found := ts.staffMembers[staffID]; found {
+		return sm, nil
+	}
+	return nil, fmt.Errorf("staff %d not found", staffID)
+}
+
+func (ts *fakeTrackSys) staffByComputingID(ctx context.Context, computingID string) (*staffMember, error) {
+	for _, sm := range ts.staffMembers {
+		if sm.ComputingID == computingID {
+			return sm, nil
+		}
+	}
+	return nil, fmt.Errorf("staff %s not found", computingID)
+}
+
+func (ts *fakeTrackSys) customers(ctx context.Context) ([]customer, error) {
+	return make([]customer, 0), nil
+}
+
+func (ts *fakeTrackSys) agencies(ctx context.Context) ([]agency, error) {
+	return make([]agency, 0), nil
+}
+
+func (ts *fakeTrackSys) containerTypes(ctx context.Context) ([]containerType, error) {
+	return make([]containerType, 0), nil
+}
+
+func (ts *fakeTrackSys) ocr(ctx context.Context) (*ocrInfo, error) {
+	return &ocrInfo{}, nil
+}
+
+func (ts *fakeTrackSys) unit(ctx context.Context, unitID int64) (*unitInfo, error) {
+	if u, found := ts.units[unitID]; found {
+		return u, nil
+	}
+	return &unitInfo{ID: unitID}, nil
+}
+
+func (ts *fakeTrackSys) component(ctx context.Context, componentID string) (json.RawMessage, error) {
+	return nil, fmt.Errorf("component %s not found", componentID)
+}
+
+func (ts *fakeTrackSys) status() tracksysStatus {
+	return tracksysStatus{State: "closed"}
+}
+
+// fakeJobs records the requests sent to dpg-jobs. finalizeErr is returned by finalizeUnit if set
+type fakeJobs struct {
+	mu          sync.Mutex
+	finalized   []uint
+	finalizeErr error
+}
+
+func (fj *fakeJobs) finalizeUnit(ctx context.Context, unitID uint, jwt string) error {
+	fj.mu.Lock()
+	defer fj.mu.Unlock()
+	if fj.finalizeErr != nil {
+		return fj.finalizeErr
+	}
+	fj.finalized = append(fj.finalized, unitID)
+	return nil
+}
+
+func (fj *fakeJobs) updateOCRSettings(ctx context.Context, unitID uint, settings any, jwt string) error {
+	return nil
+}
+
+// fakeExifTool returns the metadata held for each file in the command as exiftool JSON. Files with no
+// metadata get just their SourceFile and unreadable files are left out of the output of a read. err is
+// returned for every command if set
+type fakeExifTool struct {
+	mu         sync.Mutex
+	metadata   map[string]exifData
+	unreadable map[string]bool
+	err        error
+	commands   [][]string
+}
+
+func newFakeExifTool() *fakeExifTool {
+	return &fakeExifTool{metadata: make(map[string]exifData), unreadable: make(map[string]bool)}
+}
+
+func (et *fakeExifTool) run(args ...string) ([]byte, error) {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	et.commands = append(et.commands, args)
+	if et.err != nil {
+		return nil, et.err
+	}
+	out := make([]exifData, 0)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || (args[0] == "-json" && et.unreadable[arg]) {
+			continue
+		}
+		md := et.metadata[arg]
+		md.SourceFile = arg
+		out = append(out, md)
+	}
+	return json.Marshal(out)
+}
+
+func (et *fakeExifTool) shutdown() {
+}
+
+// fakeImageTool records the rotate requests without changing the image
+type fakeImageTool struct {
+	rotated []string
+}
+
+func (it *fakeImageTool) rotate(fullPath string, degrees string) ([]byte, error) {
+	it.rotated = append(it.rotated, fullPath)
+	return nil, nil
+}
+
+// fakeFileMover makes the changes to the local filesystem and records the renames. An operation on a
+// path in failPaths returns the error for the path instead; renames check both paths and copies the source
+type fakeFileMover struct {
+	mu        sync.Mutex
+	local     localFileMover
+	failPaths map[string]error
+	renames   [][2]string
+}
+
+func newFakeFileMover() *fakeFileMover {
+	return &fakeFileMover{failPaths: make(map[string]error)}
+}
+
+func (fm *fakeFileMover) failure(tgtPath string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.failPaths[tgtPath]
+}
+
+func (fm *fakeFileMover) rename(srcPath string, destPath string) error {
+	if err := fm.failure(srcPath); err != nil {
+		return err
+	}
+	if err := fm.failure(destPath); err != nil {
+		return err
+	}
+	fm.mu.Lock()
+	fm.renames = append(fm.renames, [2]string{srcPath, destPath})
+	fm.mu.Unlock()
+	return fm.local.rename(srcPath, destPath)
+}
+
+func (fm *fakeFileMover) remove(tgtPath string) error {
+	if err := fm.failure(tgtPath); err != nil {
+		return err
+	}
+	return fm.local.remove(tgtPath)
+}
+
+func (fm *fakeFileMover) removeAll(tgtPath string) error {
+	if err := fm.failure(tgtPath); err != nil {
+		return err
+	}
+	return fm.local.removeAll(tgtPath)
+}
+
+func (fm *fakeFileMover) mkdir(tgtDir string) error {
+	if err := fm.failure(tgtDir); err != nil {
+		return err
+	}
+	return fm.local.mkdir(tgtDir)
+}
+
+func (fm *fakeFileMover) copyFile(srcPath string, destPath string, algorithm string) (string, error) {
+	if err := fm.failure(srcPath); err != nil {
+		return "", err
+	}
+	return fm.local.copyFile(srcPath, destPath, algorithm)
+}
+
+// testService is a service context backed by the fakes, a SQLite database and a temporary directory
+type testService struct {
+	*serviceContext
+	trackSys *fakeTrackSys
+	jobs     *fakeJobs
+	exifTool *fakeExifTool
+	files    *fakeFileMover
+}
+
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	root := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(path.Join(root, "imaging.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("unable to open test database: %s", err.Error())
+	}
+	err = db.AutoMigrate(&workflow{}, &step{}, &stepValidation{}, &project{}, &assignment{}, &note{}, &problem{},
+		&validationIssue{}, &job{}, &unitLock{}, &renameJournal{}, &directoryMove{}, &directoryMoveFile{}, &fileEvent{}, &unexpectedFile{})
+	if err != nil {
+		t.Fatalf("unable to create test tables: %s", err.Error())
+	}
+	// the locks and job queue rely on unique keys from the migrations that the models do not declare
+	for _, stmt := range []string{"create unique index index_unit_locks_on_unit_id on unit_locks(unit_id)",
+		"create unique index index_jobs_on_active_unit_id on jobs(active_unit_id)"} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("unable to create test index: %s", err.Error())
+		}
+	}
+
+	ts := testService{trackSys: newFakeTrackSys(), jobs: &fakeJobs{}, exifTool: newFakeExifTool(), files: newFakeFileMover()}
+	ts.serviceContext = &serviceContext{
+		ImagesDir:     path.Join(root, "images"),
+		ScanDir:       path.Join(root, "scan"),
+		FinalizeDir:   path.Join(root, "finalize"),
+		JWTKey:        "test",
+		Checksum:      "md5",
+		BatchSize:     2,
+		DB:            db,
+		TrackSysAPI:   ts.trackSys,
+		Jobs:          ts.jobs,
+		ExifTool:      ts.exifTool,
+		ImageTool:     &fakeImageTool{},
+		Files:         ts.files,
+		MetadataCache: newMetadataCache(100, ""),
+		UnitIndex:     newUnitIndex(),
+		jobSignal:     make(chan bool, 1),
+		jobEvents:     newJobEventBroker(),
+		drain:         newDrainTracker(),
+	}
+	// renames work in the tmp directory of the images directory
+	for _, dir := range []string{path.Join(ts.ImagesDir, "tmp"), ts.ScanDir, ts.FinalizeDir} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatalf("unable to create %s: %s", dir, err.Error())
+		}
+	}
+	return &ts
+}
+
+// assignments are made from this time, a minute apart
+var testTime = time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+
+// Steps of the test workflow. Scan and Process work in the scan directory and Process moves the files to
+// the images directory. Finalize is the end step and moves the files to the finalize directory
+const (
+	testStepScan     uint = 1
+	testStepProcess  uint = 2
+	testStepQA       uint = 3
+	testStepFinalize uint = 4
+	testStepError    uint = 5
+)
+
+func (ts *testService) createWorkflow(t *testing.T) {
+	t.Helper()
+	records := []any{
+		&workflow{ID: 1, Name: "Standard", Active: true},
+		&step{ID: testStepScan, WorkflowID: 1, StepType: 0, Name: "Scan", NextStepID: testStepProcess, FailStepID: testStepError},
+		&step{ID: testStepProcess, WorkflowID: 1, StepType: 3, Name: "Process", NextStepID: testStepQA, FailStepID: testStepError, OwnerType: 1},
+		&step{ID: testStepQA, WorkflowID: 1, StepType: 3, Name: "Quality Control", NextStepID: testStepFinalize, FailStepID: testStepError, OwnerType: 2},
+		&step{ID: testStepFinalize, WorkflowID: 1, StepType: 1, Name: "Finalize", FailStepID: testStepError, OwnerType: 4},
+		&step{ID: testStepError, WorkflowID: 1, StepType: 2, Name: "Error", NextStepID: testStepQA, OwnerType: 3},
+		&stepValidation{StepID: testStepScan, Directory: StepDirScan, DirectoryExists: true},
+		&stepValidation{StepID: testStepProcess, Directory: StepDirScan, DirectoryExists: true, MoveTo: StepDirImages},
+		&stepValidation{StepID: testStepQA, Directory: StepDirImages, DirectoryExists: true, OnlyTif: true, SequenceContiguous: true,
+			UpdateImageCount: true},
+		&stepValidation{StepID: testStepFinalize, Directory: StepDirImages, DirectoryExists: true, OnlyTif: true, SequenceContiguous: true,
+			TitleRequired: true, MoveTo: StepDirFinalize, UpdateImageCount: true},
+		&stepValidation{StepID: testStepError, Directory: StepDirImages, DirectoryExists: true, UpdateImageCount: true},
+		&problem{ID: 1, Name: "filesystem", Label: "Filesystem"},
+		&problem{ID: 2, Name: "filename", Label: "Filename"},
+		&problem{ID: 3, Name: "metadata", Label: "Metadata"},
+		&problem{ID: 7, Name: "other", Label: "Other"},
+	}
+	for _, rec := range records {
+		if err := ts.DB.Create(rec).Error; err != nil {
+			t.Fatalf("unable to create %T: %s", rec, err.Error())
+		}
+	}
+}
+
+// createProject adds a project for the unit on the step. Each of the owners is given an assignment, in
+// order, for the step; the last owner has the active assignment with the status
+func (ts *testService) createProject(t *testing.T, unitID uint, stepID uint, status uint, owners ...uint) *project {
+	t.Helper()
+	proj := project{UnitID: unitID, WorkflowID: 1, CategoryID: 1, CurrentStepID: &stepID}
+	if len(owners) > 0 {
+		proj.OwnerID = &owners[len(owners)-1]
+	}
+	if err := ts.DB.Create(&proj).Error; err != nil {
+		t.Fatalf("unable to create project: %s", err.Error())
+	}
+	for idx, ownerID := range owners {
+		assignedAt := testTime.Add(time.Duration(idx) * time.Minute)
+		assign := assignment{ProjectID: proj.ID, StepID: stepID, StaffMemberID: ownerID, AssignedAt: &assignedAt, Status: Finished}
+		if idx == len(owners)-1 {
+			assign.Status = assignStatusEnum(status)
+		}
+		if err := ts.DB.Create(&assign).Error; err != nil {
+			t.Fatalf("unable to create assignment: %s", err.Error())
+		}
+	}
+	return &proj
+}
+
+// writeUnitFiles creates the files, which may be in subdirectories, in the unit directory within baseDir
+func writeUnitFiles(t *testing.T, baseDir string, unitID uint, files ...string) string {
+	t.Helper()
+	unitDir := path.Join(baseDir, padLeft(fmt.Sprintf("%d", unitID), 9))
+	if err := os.MkdirAll(unitDir, 0777); err != nil {
+		t.Fatalf("unable to create %s: %s", unitDir, err.Error())
+	}
+	for _, fn := range files {
+		tgtFile := path.Join(unitDir, fn)
+		if err := os.MkdirAll(path.Dir(tgtFile), 0777); err != nil {
+			t.Fatalf("unable to create %s: %s", path.Dir(tgtFile), err.Error())
+		}
+		if err := os.WriteFile(tgtFile, []byte(fn), 0666); err != nil {
+			t.Fatalf("unable to write %s: %s", tgtFile, err.Error())
+		}
+	}
+	return unitDir
+}
+
+// newTestRequest returns a gin context for a request by the user with a JSON body and the route params
+func newTestRequest(method string, target string, body any, claims jwtClaims, params ...string) (*gin.Context, *httptest.ResponseRecorder) {
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	var reqBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reqBody).Encode(body)
+	}
+	c.Request = httptest.NewRequest(method, target, &reqBody)
+	c.Request.Header.Set("Content-Type", "application/json")
+	for idx := 0; idx+1 < len(params); idx += 2 {
+		c.Params = append(c.Params, gin.Param{Key: params[idx], Value: params[idx+1]})
+	}
+	c.Set("claims", claims)
+	return c, resp
+}
+
+func expectStatus(t *testing.T, resp *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if resp.Code != status {
+		t.Fatalf("expected status %d %s, got %d: %s", status, http.StatusText(status), resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job event types sent to SSE listeners
+const (
+	JobEventStatus   = "status"
+	JobEventPhase    = "phase"
+	JobEventProgress = "progress"
+	JobEventProblem  = "problem"
+	JobEventDone     = "done"
+)
+
+const (
+	jobEventBufferSize   = 256
+	jobEventPollInterval = 2 * time.Second
+	jobProgressSaveDelay = 1 * time.Second
+)
+
+// jobEvent is a single update for a running job. Only the fields that apply to the event type are set
+type jobEvent struct {
+	Type      string         `json:"-"`
+	JobID     int64          `json:"jobID"`
+	Phase     string         `json:"phase,omitempty"`
+	File      string         `json:"file,omitempty"`
+	Problem   *updateProblem `json:"problem,omitempty"`
+	Processed int            `json:"processed"`
+	Total     int            `json:"total"`
+	Status    string         `json:"status,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// jobEventBroker fans out events from jobs running in this instance of the service to any
+// clients that are streaming events for that job
+type jobEventBroker struct {
+	mutex     sync.Mutex
+	listeners map[int64]map[chan jobEvent]bool
+}
+
+func newJobEventBroker() *jobEventBroker {
+	return &jobEventBroker{listeners: make(map[int64]map[chan jobEvent]bool)}
+}
+
+func (b *jobEventBroker) subscribe(jobID int64) chan jobEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	listener := make(chan jobEvent, jobEventBufferSize)
+	if b.listeners[jobID] == nil {
+		b.listeners[jobID] = make(map[chan jobEvent]bool)
+	}
+	b.listeners[jobID][listener] = true
+	return listener
+}
+
+func (b *jobEventBroker) unsubscribe(jobID int64, listener chan jobEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.listeners[jobID], listener)
+	if len(b.listeners[jobID]) == 0 {
+		delete(b.listeners, jobID)
+	}
+}
+
+// publish never blocks the job. A listener that is too far behind misses the event; progress
+// events carry running totals and the final done event reports the overall result
+func (b *jobEventBroker) publish(evt jobEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for listener := range b.listeners[evt.JobID] {
+		select {
+		case listener <- evt:
+		default:
+			log.Printf("WARNING: listener for job %d is not keeping up; dropped %s event", evt.JobID, evt.Type)
+		}
+	}
+}
+
+// jobPhase starts a new phase of work for a job. The processed and total counts restart for the phase.
+// All job progress helpers accept a nil job so shared code can be used outside of a job.
+func (svc *serviceContext) jobPhase(tgtJob *job, phase string) {
+	if tgtJob == nil {
+		return
+	}
+	svc.jobMutex.Lock()
+	tgtJob.Phase = phase
+	tgtJob.Processed = 0
+	tgtJob.Total = 0
+	svc.jobMutex.Unlock()
+	svc.saveJobProgress(tgtJob)
+	svc.jobEvents.publish(jobEvent{Type: JobEventPhase, JobID: tgtJob.ID, Phase: phase})
+}
+
+// jobAddWork adds to the number of files that the job will process in the current phase
+func (svc *serviceContext) jobAddWork(tgtJob *job, cnt int) {
+	if tgtJob == nil {
+		return
+	}
+	svc.jobMutex.Lock()
+	tgtJob.Total += cnt
+	svc.jobMutex.Unlock()
+}
+
+// jobFileProcessed records that a file has been processed and notifies listeners. Progress is written
+// to the DB at most once per second so listeners connected to other instances see it move too.
+func (svc *serviceContext) jobFileProcessed(tgtJob *job, file string) {
+	if tgtJob == nil {
+		return
+	}
+	svc.jobMutex.Lock()
+	tgtJob.Processed++
+	evt := jobEvent{Type: JobEventProgress, JobID: tgtJob.ID, Phase: tgtJob.Phase, File: file,
+		Processed: tgtJob.Processed, Total: tgtJob.Total}
+	saveNeeded := time.Since(tgtJob.progressSavedAt) > jobProgressSaveDelay || tgtJob.Processed == tgtJob.Total
+	svc.jobMutex.Unlock()
+
+	svc.jobEvents.publish(evt)
+	if saveNeeded {
+		svc.saveJobProgress(tgtJob)
+	}
+}
+
+// jobProblem adds a per-file problem to the job and notifies listeners
+func (svc *serviceContext) jobProblem(tgtJob *job, problem updateProblem) {
+	if tgtJob == nil {
+		return
+	}
+	svc.jobMutex.Lock()
+	tgtJob.Problems = append(tgtJob.Problems, problem)
+	evt := jobEvent{Type: JobEventProblem, JobID: tgtJob.ID, Phase: tgtJob.Phase, File: problem.File,
+		Problem: &problem, Processed: tgtJob.Processed, Total: tgtJob.Total}
+	svc.jobMutex.Unlock()
+	svc.jobEvents.publish(evt)
+}
+
+func (svc *serviceContext) saveJobProgress(tgtJob *job) {
+	svc.jobMutex.Lock()
+	tgtJob.progressSavedAt = time.Now()
+	updates := map[string]any{"phase": tgtJob.Phase, "processed": tgtJob.Processed, "total": tgtJob.Total,
+		"updated_at": tgtJob.progressSavedAt}
+	svc.jobMutex.Unlock()
+	if err := svc.DB.Model(&job{}).Where("id=?", tgtJob.ID).Updates(updates).Error; err != nil {
+		log.Printf("ERROR: unable to update job %d progress: %s", tgtJob.ID, err.Error())
+	}
+}
+
+// streamJobEvents sends job events to the client as server-sent events until the job is done. Events
+// are only published by the instance running the job, so the job is also checked in the DB
+// periodically; clients connected to another instance still see progress and the final result.
+func (svc *serviceContext) streamJobEvents(c *gin.Context) {
+	jobID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	log.Printf("INFO: stream events for job %d", jobID)
+
+	// subscribe before the initial lookup so a job that finishes in between is not missed
+	events := svc.jobEvents.subscribe(jobID)
+	defer svc.jobEvents.unsubscribe(jobID, events)
+
+	var tgtJob job
+	if err := svc.DB.Where("id=?", jobID).Limit(1).Find(&tgtJob).Error; err != nil {
+		log.Printf("ERROR: unable to get job %d: %s", jobID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tgtJob.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("job %d not found", jobID))
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	if tgtJob.Status == JobFinished || tgtJob.Status == JobFailed {
+		c.SSEvent(JobEventDone, tgtJob)
+		return
+	}
+	c.SSEvent(JobEventStatus, tgtJob)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(jobEventPollInterval)
+	defer ticker.Stop()
+	lastProcessed := tgtJob.Processed
+	lastPhase := tgtJob.Phase
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-svc.drain.stopping:
+			return false
+		case evt := <-events:
+			if evt.Type == JobEventDone {
+				// send the saved job so the client gets the complete list of problems
+				var curr job
+				svc.DB.Where("id=?", jobID).Limit(1).Find(&curr)
+				c.SSEvent(JobEventDone, curr)
+				return false
+			}
+			lastProcessed = evt.Processed
+			if evt.Phase != "" {
+				lastPhase = evt.Phase
+			}
+			c.SSEvent(evt.Type, evt)
+			return true
+		case <-ticker.C:
+			var curr job
+			if err := svc.DB.Where("id=?", jobID).Limit(1).Find(&curr).Error; err != nil {
+				log.Printf("ERROR: unable to refresh job %d for event stream: %s", jobID, err.Error())
+				return true
+			}
+			if curr.Status == JobFinished || curr.Status == JobFailed {
+				c.SSEvent(JobEventDone, curr)
+				return false
+			}
+			if curr.Processed != lastProcessed || curr.Phase != lastPhase {
+				lastProcessed = curr.Processed
+				lastPhase = curr.Phase
+				c.SSEvent(JobEventStatus, curr)
+			}
+			return true
+		}
+	})
+	log.Printf("INFO: event stream for job %d has ended", jobID)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exifToolPool manages a set of long running `exiftool -stay_open True -@ -` processes. Each call
+// checks out an idle process, sends the arguments over stdin and reads the response up to a ready
+// marker. The number of processes is the cap on concurrent exiftool calls across the service.
+type exifToolPool struct {
+	idle    chan *exifToolProcess
+	timeout time.Duration
+}
+
+type exifToolProcess struct {
+	id     int
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *bufio.Reader
+	seq    int
+}
+
+type exifToolResponse struct {
+	out    []byte
+	stderr []byte
+	err    error
+}
+
+func newExifToolPool(size int, timeout time.Duration) *exifToolPool {
+	log.Printf("INFO: start exiftool pool with %d processes and a %s call timeout", size, timeout)
+	pool := exifToolPool{idle: make(chan *exifToolProcess, size), timeout: timeout}
+	for i := 1; i <= size; i++ {
+		proc := &exifToolProcess{id: i}
+		if err := proc.start(); err != nil {
+			// the process is restarted the next time it is checked out
+			log.Printf("ERROR: unable to start exiftool process %d: %s", i, err.Error())
+		}
+		pool.idle <- proc
+	}
+	return &pool
+}
+
+func (proc *exifToolProcess) start() error {
+	cmd := exec.Command("exiftool", "-stay_open", "True", "-@", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	proc.cmd = cmd
+	proc.stdin = stdin
+	proc.stdout = bufio.NewReader(stdout)
+	proc.stderr = bufio.NewReader(stderr)
+	log.Printf("INFO: exiftool process %d started with pid %d", proc.id, cmd.Process.Pid)
+	return nil
+}
+
+func (proc *exifToolProcess) stop() {
+	if proc.cmd == nil {
+		return
+	}
+	proc.cmd.Process.Kill()
+	proc.cmd.Wait()
+	proc.cmd = nil
+}
+
+// restart replaces the process. If the new process cannot be started, the next call will try again
+func (proc *exifToolProcess) restart() error {
+	log.Printf("WARNING: restarting exiftool process %d", proc.id)
+	proc.stop()
+	return proc.start()
+}
+
+// run executes exiftool with the specified arguments and returns the output. An error is returned
+// if the call times out or exiftool reports an error; the message includes the exiftool error text
+func (pool *exifToolPool) run(args ...string) ([]byte, error) {
+	// wait for a process to be available. The timeout only applies once the call is running since
+	// large batches will queue here for longer than any single call should take.
+	waitStart := time.Now()
+	proc := <-pool.idle
+	defer func() {
+		pool.idle <- proc
+	}()
+	exifToolWait.Observe(time.Since(waitStart).Seconds())
+	callStart := time.Now()
+
+	if proc.cmd == nil {
+		if err := proc.start(); err != nil {
+			return nil, fmt.Errorf("unable to start exiftool: %s", err.Error())
+		}
+	}
+
+	proc.seq++
+	ready := fmt.Sprintf("{ready%d}", proc.seq)
+	var req bytes.Buffer
+	for _, arg := range args {
+		req.WriteString(encodeExifToolArg(arg))
+		req.WriteString("\n")
+	}
+	// -echo4 writes the ready marker to stderr once the command completes so stderr can be read to the same point
+	req.WriteString(fmt.Sprintf("-echo4\n%s\n-execute%d\n", ready, proc.seq))
+
+	// capture the pipes for this process; if the call times out the process is replaced while these are in use
+	stdin, stdoutReader, stderrReader := proc.stdin, proc.stdout, proc.stderr
+	respChan := make(chan exifToolResponse, 1)
+	go func() {
+		if _, err := stdin.Write(req.Bytes()); err != nil {
+			respChan <- exifToolResponse{err: fmt.Errorf("unable to send command: %s", err.Error())}
+			return
+		}
+
+		var wg sync.WaitGroup
+		var resp exifToolResponse
+		var stderrErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			resp.out, resp.err = readExifToolResponse(stdoutReader, ready)
+		}()
+		go func() {
+			defer wg.Done()
+			resp.stderr, stderrErr = readExifToolResponse(stderrReader, ready)
+		}()
+		wg.Wait()
+		if resp.err == nil {
+			resp.err = stderrErr
+		}
+		respChan <- resp
+	}()
+
+	select {
+	case resp := <-respChan:
+		if resp.err != nil {
+			// communication with the process failed; it has most likely crashed
+			log.Printf("ERROR: exiftool process %d failed: %s", proc.id, resp.err.Error())
+			if err := proc.restart(); err != nil {
+				log.Printf("ERROR: unable to restart exiftool process %d: %s", proc.id, err.Error())
+			}
+			exifToolDuration.WithLabelValues("failed").Observe(time.Since(callStart).Seconds())
+			return nil, resp.err
+		}
+		exifErr := checkExifToolErrors(resp.stderr)
+		outcome := "success"
+		if exifErr != nil {
+			outcome = "error"
+		}
+		exifToolDuration.WithLabelValues(outcome).Observe(time.Since(callStart).Seconds())
+		return resp.out, exifErr
+	case <-time.After(pool.timeout):
+		// the state of the process is unknown so replace it. Killing it also ends the blocked readers
+		log.Printf("ERROR: exiftool process %d call %v timed out after %s", proc.id, args, pool.timeout)
+		exifToolDuration.WithLabelValues("timeout").Observe(time.Since(callStart).Seconds())
+		if err := proc.restart(); err != nil {
+			log.Printf("ERROR: unable to restart exiftool process %d: %s", proc.id, err.Error())
+		}
+		return nil, fmt.Errorf("exiftool timed out after %s", pool.timeout)
+	}
+}
+
+// shutdown asks all of the exiftool processes to exit
+func (pool *exifToolPool) shutdown() {
+	for i := 0; i < cap(pool.idle); i++ {
+		proc := <-pool.idle
+		if proc.cmd != nil {
+			proc.stdin.Write([]byte("-stay_open\nFalse\n"))
+			proc.cmd.Wait()
+			proc.cmd = nil
+		}
+		log.Printf("INFO: exiftool process %d stopped", proc.id)
+	}
+}
+
+func readExifToolResponse(reader *bufio.Reader, ready string) ([]byte, error) {
+	var out bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) == ready {
+			return out.Bytes(), nil
+		}
+		out.WriteString(line)
+		if err != nil {
+			return out.Bytes(), fmt.Errorf("exiftool response incomplete: %w", err)
+		}
+	}
+}
+
+// checkExifToolErrors converts any errors in the exiftool stderr output into an error. Warnings are ignored
+func checkExifToolErrors(stderr []byte) error {
+	errs := make([]string, 0)
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if strings.HasPrefix(line, "Error") {
+			errs = append(errs, strings.TrimSpace(line))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// encodeExifToolArg prepares an argument to be sent as one line of the argfile. Args that would be
+// changed by the argfile parsing (newlines, surrounding whitespace or a leading #) are sent as C strings
+func encodeExifToolArg(arg string) string {
+	if !strings.ContainsAny(arg, "\r\n") && strings.TrimSpace(arg) == arg && !strings.HasPrefix(arg, "#") {
+		return arg
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return "#[CSTR]" + replacer.Replace(arg)
+}
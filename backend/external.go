@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -23,15 +22,16 @@ type constants struct {
 }
 
 func (svc *serviceContext) getConstants(c *gin.Context) {
+	logger := getLogger(c.Request.Context())
 	out := constants{}
 
 	if err := svc.DB.Table("categories").Order("name asc").Find(&out.Categories).Error; err != nil {
-		log.Printf("ERROR: unable to get categories: %s", err.Error())
+		logger.Error("unable to get categories", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if err := svc.DB.Table("workflows").Where("active=?", 1).Order("name asc").Find(&out.Workflows).Error; err != nil {
-		log.Printf("ERROR: unable to get workflows: %s", err.Error())
+		logger.Error("unable to get workflows", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -41,8 +41,9 @@ func (svc *serviceContext) getConstants(c *gin.Context) {
 
 func (svc *serviceContext) lookupProjectForUnit(c *gin.Context) {
 	uid := c.Query("unit")
+	logger := getLogger(c.Request.Context())
 	if uid == "" {
-		log.Printf("INFO: project lookup request is missing required unit param")
+		logger.Info("project lookup request is missing required unit param")
 		c.String(http.StatusBadRequest, "missing required unit param")
 		return
 	}
@@ -57,10 +58,10 @@ func (svc *serviceContext) lookupProjectForUnit(c *gin.Context) {
 	var proj project
 	if err := svc.DB.Preload("CurrentStep").Preload("Workflow").Where("unit_id=?", uid).First(&proj).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) == false {
-			log.Printf("ERROR: lookup project for unit %s failed: %s", uid, err.Error())
+			logger.Error("lookup project for unit failed", "unitID", uid, "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 		} else {
-			log.Printf("INFO: no project exists for %s", uid)
+			logger.Info("no project exists for unit", "unitID", uid)
 			c.JSON(http.StatusOK, lookupResp{})
 		}
 		return
@@ -103,36 +104,38 @@ type updateProjectMetadataRequest struct {
 }
 
 func (svc *serviceContext) createProject(c *gin.Context) {
+	logger := getLogger(c.Request.Context())
 	var req createProjectRequest
 	if qpErr := c.ShouldBindJSON(&req); qpErr != nil {
-		log.Printf("ERROR: invalid create project payload: %v", qpErr)
+		logger.Error("invalid create project payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	log.Printf("INFO: received create project request: %v", req)
+	logger = logger.With("unitID", req.UnitID)
+	logger.Info("received create project request", "request", fmt.Sprintf("%+v", req))
 
 	var projCnt int64
 	if err := svc.DB.Table("projects").Where("unit_id=?", req.UnitID).Count(&projCnt).Error; err != nil {
-		log.Printf("ERROR: unable to determine if a project already exists for unit %d: %s", req.UnitID, err.Error())
+		logger.Error("unable to determine if a project already exists", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	if projCnt > 0 {
-		log.Printf("INFO: unable to create project for unit %d as it already has a project", req.UnitID)
+		logger.Info("unable to create project as the unit already has a project")
 		c.String(http.StatusConflict, "a project already exists for this unit")
 		return
 	}
 
-	log.Printf("INFO: lookup first step of new project for unit %d, workflow %d", req.UnitID, req.WorkflowID)
+	logger.Info("lookup first step of new project", "workflowID", req.WorkflowID)
 	var firstStep step
 	if err := svc.DB.Where("workflow_id=? and step_type=0", req.WorkflowID).First(&firstStep).Error; err != nil {
-		log.Printf("ERROR: unable to get first step for workflow %d: %s", req.WorkflowID, err.Error())
+		logger.Error("unable to get first step", "workflowID", req.WorkflowID, "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: create project for unit %d", req.UnitID)
+	logger.Info("create project")
 	now := time.Now()
 	newProj := project{
 		Title:         req.Title,
@@ -157,19 +160,20 @@ func (svc *serviceContext) createProject(c *gin.Context) {
 	}
 	if err := svc.DB.Omit("workstation_id", "finished_at", "started_at", "capture_resolution", "resized_resolution", "resolution_note", "owner_id").
 		Create(&newProj).Error; err != nil {
-		log.Printf("ERROR: unable to create project for unit %d: %s", req.UnitID, err.Error())
+		logger.Error("unable to create project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: new project %d created for unit %d", newProj.ID, req.UnitID)
+	logger.Info("new project created", "projectID", newProj.ID)
 	c.String(http.StatusOK, fmt.Sprintf("%d", newProj.ID))
 }
 
 func (svc *serviceContext) updateProjectMetadata(c *gin.Context) {
 	projID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	claims := getJWTClaims(c)
-	log.Printf("INFO: %s requests update of project %d metadata", claims.ComputeID, projID)
+	logger := getLogger(c.Request.Context())
+	logger.Info("update project metadata requested")
 	if claims.Role != "admin" && claims.Role != "supervisor" {
 		c.String(http.StatusForbidden, "you cannot update this project")
 		return
@@ -178,19 +182,19 @@ func (svc *serviceContext) updateProjectMetadata(c *gin.Context) {
 	// NOTE: only fields that are populated will be updated
 	var req updateProjectMetadataRequest
 	if qpErr := c.ShouldBindJSON(&req); qpErr != nil {
-		log.Printf("ERROR: invalid create update project metadata payload: %v", qpErr)
+		logger.Error("invalid update project metadata payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
 
-	log.Printf("INFO: update project %d metadata with %+v", projID, req)
+	logger.Info("update project metadata", "request", fmt.Sprintf("%+v", req))
 	var tgtProj project
 	if err := svc.DB.First(&tgtProj, projID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) == false {
-			log.Printf("ERROR: unable to load project %d: %s", projID, err.Error())
+			logger.Error("unable to load project", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 		} else {
-			log.Printf("INFO: project %d not found", projID)
+			logger.Info("project not found")
 			c.String(http.StatusNotFound, fmt.Sprintf("project %d not found", projID))
 		}
 	}
@@ -223,12 +227,12 @@ func (svc *serviceContext) updateProjectMetadata(c *gin.Context) {
 
 	if len(fields) > 0 {
 		if err := svc.DB.Model(&tgtProj).Select(fields).Updates(tgtProj).Error; err != nil {
-			log.Printf("ERROR: unable to update project %d metadata: %s", projID, err.Error())
+			logger.Error("unable to update project metadata", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 		}
-		log.Printf("INFO: project %d metadata updated", projID)
+		logger.Info("project metadata updated", "fields", fields)
 	} else {
-		log.Printf("INFO: no change in project %d metadata needed", projID)
+		logger.Info("no change in project metadata needed")
 	}
 
 	c.String(http.StatusOK, "ok")
@@ -237,64 +241,67 @@ func (svc *serviceContext) updateProjectMetadata(c *gin.Context) {
 func (svc *serviceContext) cancelProject(c *gin.Context) {
 	projID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	claims := getJWTClaims(c)
-	log.Printf("INFO: %s requests cancelation of project %d", claims.ComputeID, projID)
+	logger := getLogger(c.Request.Context())
+	logger.Info("cancel project requested")
 	if claims.Role != "admin" && claims.Role != "supervisor" {
 		c.String(http.StatusForbidden, "you cannot cancel this project")
 		return
 	}
 
-	if err := svc.doProjectDelete(projID); err != nil {
-		log.Printf("ERROR: %s", err.Error())
+	if err := svc.doProjectDelete(c.Request.Context(), projID); err != nil {
+		logger.Error("unable to cancel project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: project %d has been canceled and all associated data deleted", projID)
+	logger.Info("project has been canceled and all associated data deleted")
 	c.String(http.StatusOK, "ok")
 }
 
 func (svc *serviceContext) failProject(c *gin.Context) {
 	projID := c.Param("id")
+	logger := getLogger(c.Request.Context())
 	var req struct {
 		Reason         string `json:"reason"`
 		ProcessingMins uint   `json:"processingMins"`
 		JobID          int64  `json:"jobID"` // optional
 	}
 	if qpErr := c.ShouldBindJSON(&req); qpErr != nil {
-		log.Printf("ERROR: invalid fail project payload: %v", qpErr)
+		logger.Error("invalid fail project payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
 
-	log.Printf("INFO: fail project %s for reason [%s]", projID, req.Reason)
+	logger.Info("fail project", "reason", req.Reason, "processingMins", req.ProcessingMins, "tracksysJobID", req.JobID)
 
 	var tgtProj project
 	if err := svc.DB.Preload("CurrentStep").First(&tgtProj, projID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) == false {
-			log.Printf("ERROR: unable to load project %s: %s", projID, err.Error())
+			logger.Error("unable to load project", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 		} else {
-			log.Printf("INFO: project %s not found", projID)
+			logger.Info("project not found")
 			c.String(http.StatusNotFound, fmt.Sprintf("project %s not found", projID))
 		}
 		return
 	}
 
 	// lookup the active assignment
-	log.Printf("INFO: get assignment for project %s, step %s", projID, tgtProj.CurrentStep.Name)
+	logger = logger.With("step", tgtProj.CurrentStep.Name)
+	logger.Info("get active assignment")
 	var activeAssign assignment
 	if err := svc.DB.Where("project_id=?", projID).Order("assigned_at DESC").Limit(1).First(&activeAssign).Error; err != nil {
-		log.Printf("ERROR: unable to get active assignment for failed project %s: %s", projID, err.Error())
+		logger.Error("unable to get active assignment for failed project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// fail the assign and increase time spent
-	log.Printf("INFO: fail assignment %d for project %s, step %s and add duration %d mins", activeAssign.ID, projID, tgtProj.CurrentStep.Name, req.ProcessingMins)
+	logger.Info("fail assignment and add duration", "assignmentID", activeAssign.ID, "processingMins", req.ProcessingMins)
 	activeAssign.DurationMinutes += req.ProcessingMins
 	activeAssign.Status = 4 // error
 	if err := svc.DB.Model(&activeAssign).Select("DurationMinutes", "Status").Updates(activeAssign).Error; err != nil {
-		log.Printf("ERROR: unable to update assignment %d to failed: %s", activeAssign.ID, err.Error())
+		logger.Error("unable to update assignment to failed", "assignmentID", activeAssign.ID, "error", err.Error())
 	}
 
 	// add a note describing the failure
@@ -302,10 +309,10 @@ func (svc *serviceContext) failProject(c *gin.Context) {
 		msg := fmt.Sprintf("<p>%s</p>", req.Reason)
 		msg += "<p>Please manually correct the finalization problems. Once complete, press the Finish button to restart finalization.</p>"
 		msg += fmt.Sprintf("<p>Error details <a href='%s/job_statuses/%d'>here</a></p>", svc.TrackSys.Client, req.JobID)
-		svc.failStep(&tgtProj, "Finalization", msg)
+		svc.failStep(c.Request.Context(), &tgtProj, "Finalization", msg)
 	} else {
 		msg := fmt.Sprintf("<p>%s failed</p><p>%s</p>", tgtProj.CurrentStep.Name, req.Reason)
-		svc.failStep(&tgtProj, "Other", msg)
+		svc.failStep(c.Request.Context(), &tgtProj, "Other", msg)
 	}
 
 	c.String(http.StatusOK, "ok")
@@ -313,41 +320,42 @@ func (svc *serviceContext) failProject(c *gin.Context) {
 
 func (svc *serviceContext) finishProject(c *gin.Context) {
 	projID := c.Param("id")
+	logger := getLogger(c.Request.Context())
 	var req struct {
 		ProcessingMins uint `json:"processingMins"`
 	}
 	if qpErr := c.ShouldBindJSON(&req); qpErr != nil {
-		log.Printf("ERROR: invalid finish project payload: %v", qpErr)
+		logger.Error("invalid finish project payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
 
-	log.Printf("INFO: request to finish project %s with finalization duration %d mins", projID, req.ProcessingMins)
+	logger.Info("finish project", "processingMins", req.ProcessingMins)
 
 	var tgtProj project
 	if err := svc.DB.Preload("CurrentStep").First(&tgtProj, projID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) == false {
-			log.Printf("ERROR: unable to load project %s: %s", projID, err.Error())
+			logger.Error("unable to load project", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 		} else {
-			log.Printf("INFO: project %s not found", projID)
+			logger.Info("project not found")
 			c.String(http.StatusNotFound, fmt.Sprintf("project %s not found", projID))
 		}
 		return
 	}
 
 	// stepType 1 is the end step. Must be on it to finish project
-	log.Printf("INFO: validate current step is a final step for project %s", projID)
+	logger.Info("validate current step is a final step")
 	if tgtProj.CurrentStep != nil && tgtProj.CurrentStep.StepType != 1 {
-		log.Printf("ERROR: project %s is on non-final step %s and cannot be finished", projID, tgtProj.CurrentStep.Name)
+		logger.Error("project is on a non-final step and cannot be finished", "step", tgtProj.CurrentStep.Name)
 		c.String(http.StatusPreconditionFailed, fmt.Sprintf("project is on non-final step %s and cannot be finished", tgtProj.CurrentStep.Name))
 		return
 	}
 
-	log.Printf("INFO: get active assignment for project %s", projID)
+	logger.Info("get active assignment")
 	var activeAssign assignment
 	if err := svc.DB.Where("project_id=?", tgtProj.ID).Order("assigned_at DESC").First(&activeAssign).Error; err != nil {
-		log.Printf("ERROR: unable to get active assignment for project %s: %s", projID, err.Error())
+		logger.Error("unable to get active assignment", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -358,34 +366,34 @@ func (svc *serviceContext) finishProject(c *gin.Context) {
 	tgtProj.CurrentStepID = nil
 
 	// note: do this first so the calculation of total time below accounts for finalization time
-	log.Printf("INFO: update project %s finalization assignment", projID)
+	logger.Info("update finalization assignment", "assignmentID", activeAssign.ID)
 	activeAssign.FinishedAt = &now
 	activeAssign.Status = 2 // finished
 	activeAssign.DurationMinutes = activeAssign.DurationMinutes + req.ProcessingMins
 	if err := svc.DB.Model(&activeAssign).Select("FinishedAt", "Status", "DurationMinutes").Updates(activeAssign).Error; err != nil {
-		log.Printf("ERROR: unable project %s finalization assignmnent with completion info: %s", projID, err.Error())
+		logger.Error("unable to update finalization assignment with completion info", "assignmentID", activeAssign.ID, "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 	}
 
-	log.Printf("INFO: calculate total duration for project %s", projID)
+	logger.Info("calculate total project duration")
 	fields := []string{"FinishedAt", "OwnerID", "CurrentStepID"}
 	sql := "select SUM(duration_minutes) as total from assignments where project_id=?"
 	var total int64
 	if err := svc.DB.Raw(sql, tgtProj.ID).Scan(&total).Error; err != nil {
-		log.Printf("ERROR: unable to calculate project %s duration: %s", projID, err.Error())
+		logger.Error("unable to calculate project duration", "error", err.Error())
 	} else {
 		tgtProj.TotalDurationMins = &total
 		fields = append(fields, "TotalDurationMins")
-		log.Printf("INFO: project %s total duration (minutes): %d", projID, total)
+		logger.Info("project total duration", "totalMins", total)
 	}
 
-	log.Printf("INFO: update project %s to reflect completed finalization", projID)
+	logger.Info("update project to reflect completed finalization")
 	if err := svc.DB.Model(tgtProj).Select(fields).Updates(tgtProj).Error; err != nil {
-		log.Printf("ERROR: unable project %s completion info: %s", projID, err.Error())
+		logger.Error("unable to update project completion info", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: project %s successfully marked as finished", projID)
+	logger.Info("project marked as finished")
 	c.String(http.StatusOK, "ok")
 }
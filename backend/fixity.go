@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const jobFixityCheck = "fixity-check"
+
+// checksum algorithms for file manifests. MD5 matches the checksums kept by TrackSys
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"md5":    md5.New,
+}
+
+// manifests are written in the format used by sha256sum and md5sum so they can also be checked with those tools
+var manifestRegex = regexp.MustCompile(`^manifest-(sha256|md5)\.txt$`)
+
+func manifestFileName(algorithm string) string {
+	return fmt.Sprintf("manifest-%s.txt", algorithm)
+}
+
+func isManifestFile(fileName string) bool {
+	return manifestRegex.MatchString(fileName)
+}
+
+type manifestEntry struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// fileManifest lists the checksum of every file in a unit directory. Paths are relative to the directory
+type fileManifest struct {
+	Algorithm string          `json:"algorithm"`
+	Entries   []manifestEntry `json:"entries"`
+}
+
+// copyFileVerified copies a file to a destination that must not exist. The checksum of the file is computed
+// as it is read from the source and the copy is read back and checked against it. The checksum is returned
+func copyFileVerified(srcPath string, destPath string, algorithm string) (string, error) {
+	newHash, found := checksumAlgorithms[algorithm]
+	if !found {
+		return "", fmt.Errorf("unsupported checksum algorithm %s", algorithm)
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return "", err
+	}
+	srcSum, err := copyFile(srcPath, destPath, info.Mode().Perm(), newHash())
+	if err != nil {
+		return "", err
+	}
+	destSum, err := fileChecksum(destPath, newHash())
+	if err != nil {
+		return "", fmt.Errorf("unable to verify %s: %s", destPath, err.Error())
+	}
+	if destSum != srcSum {
+		return "", fmt.Errorf("%s checksum %s does not match source checksum %s", destPath, destSum, srcSum)
+	}
+	return srcSum, nil
+}
+
+// copyFile copies a file and returns the checksum of the content read from the source. The copy is
+// synced to disk before returning so the verification reads what was actually written
+func copyFile(srcPath string, destPath string, perm fs.FileMode, hasher hash.Hash) (string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dest, io.TeeReader(src, hasher)); err != nil {
+		dest.Close()
+		return "", fmt.Errorf("unable to copy %s to %s: %s", srcPath, destPath, err.Error())
+	}
+	if err := dest.Sync(); err != nil {
+		dest.Close()
+		return "", fmt.Errorf("unable to sync %s: %s", destPath, err.Error())
+	}
+	if err := dest.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func fileChecksum(tgtPath string, hasher hash.Hash) (string, error) {
+	f, err := os.Open(tgtPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// writeManifest saves the manifest in the directory. Any manifest made with another algorithm is
+// removed so only the current one is checked
+func writeManifest(tgtDir string, manifest *fileManifest) error {
+	var sb strings.Builder
+	for _, entry := range manifest.Entries {
+		sb.WriteString(fmt.Sprintf("%s  %s\n", entry.Checksum, entry.Path))
+	}
+	if err := os.WriteFile(path.Join(tgtDir, manifestFileName(manifest.Algorithm)), []byte(sb.String()), 0664); err != nil {
+		return err
+	}
+	for algorithm := range checksumAlgorithms {
+		if algorithm != manifest.Algorithm {
+			os.Remove(path.Join(tgtDir, manifestFileName(algorithm)))
+		}
+	}
+	return nil
+}
+
+// readManifest loads the manifest from a directory along with the time it was written
+func readManifest(tgtDir string) (*fileManifest, time.Time, error) {
+	for algorithm := range checksumAlgorithms {
+		manifestPath := path.Join(tgtDir, manifestFileName(algorithm))
+		info, err := os.Stat(manifestPath)
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(manifestPath)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		defer f.Close()
+
+		manifest := fileManifest{Algorithm: algorithm, Entries: make([]manifestEntry, 0)}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			checksum, relPath, found := strings.Cut(scanner.Text(), "  ")
+			if !found {
+				continue
+			}
+			manifest.Entries = append(manifest.Entries, manifestEntry{Path: relPath, Checksum: checksum})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, time.Time{}, err
+		}
+		return &manifest, info.ModTime(), nil
+	}
+	return nil, time.Time{}, fmt.Errorf("no manifest found in %s", tgtDir)
+}
+
+type fixityRequest struct {
+	Location string `json:"location"`
+}
+
+// checkFixity queues a job to verify the files of a unit against the manifest written when they were last
+// moved. The location is images (the default) or finalize
+func (svc *serviceContext) checkFixity(c *gin.Context) {
+	req := fixityRequest{Location: c.DefaultQuery("location", "images")}
+	if req.Location != "images" && req.Location != "finalize" {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid location", req.Location))
+		return
+	}
+	svc.queueUnitJob(c, jobFixityCheck, 0, req)
+}
+
+// runFixityJob checks every file in the manifest. Each file that is missing or has a different checksum
+// is a problem. Files that were modified after the manifest was written, by a metadata update
+// for example, are reported as modified rather than as fixity failures
+func (svc *serviceContext) runFixityJob(tgtJob *job) error {
+	rawUnitID := fmt.Sprintf("%d", tgtJob.UnitID)
+	var req fixityRequest
+	if err := json.Unmarshal([]byte(tgtJob.Payload), &req); err != nil {
+		return fmt.Errorf("invalid fixity payload: %s", err.Error())
+	}
+	baseDir := svc.ImagesDir
+	if req.Location == "finalize" {
+		baseDir = svc.FinalizeDir
+	}
+	unitDir := path.Join(baseDir, padLeft(rawUnitID, 9))
+
+	lock, lockErr := svc.acquireUnitLock(tgtJob.context(), rawUnitID, tgtJob.Owner, "fixity")
+	if lockErr != nil {
+		return errors.New(lockErr.Message)
+	}
+	defer svc.releaseUnitLock(lock)
+
+	manifest, manifestTime, err := readManifest(unitDir)
+	if err != nil {
+		return err
+	}
+	newHash := checksumAlgorithms[manifest.Algorithm]
+	logger := tgtJob.logger().With("dir", unitDir)
+	logger.Info("check fixity", "fileCount", len(manifest.Entries), "algorithm", manifest.Algorithm,
+		"manifestTime", manifestTime.Format(time.RFC3339))
+	svc.jobPhase(tgtJob, "fixity")
+	svc.jobAddWork(tgtJob, len(manifest.Entries))
+
+	failed := 0
+	modified := 0
+	listed := make(map[string]bool)
+	for _, entry := range manifest.Entries {
+		listed[entry.Path] = true
+		tgtPath := path.Join(unitDir, entry.Path)
+		info, err := os.Stat(tgtPath)
+		if err != nil {
+			svc.jobProblem(tgtJob, updateProblem{File: entry.Path, Problem: "is missing"})
+			failed++
+		} else if checksum, err := fileChecksum(tgtPath, newHash()); err != nil {
+			svc.jobProblem(tgtJob, updateProblem{File: entry.Path, Problem: fmt.Sprintf("unable to read: %s", err.Error())})
+			failed++
+		} else if checksum != entry.Checksum {
+			if info.ModTime().After(manifestTime) {
+				svc.jobProblem(tgtJob, updateProblem{File: entry.Path, Problem: fmt.Sprintf("was modified at %s after the manifest was written", info.ModTime().Format(time.RFC3339))})
+				modified++
+			} else {
+				svc.jobProblem(tgtJob, updateProblem{File: entry.Path, Problem: fmt.Sprintf("checksum %s does not match manifest checksum %s", checksum, entry.Checksum)})
+				failed++
+			}
+		}
+		svc.jobFileProcessed(tgtJob, entry.Path)
+	}
+
+	filepath.WalkDir(unitDir, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		relPath, _ := filepath.Rel(unitDir, fullPath)
+		if !listed[filepath.ToSlash(relPath)] && !isManifestFile(relPath) {
+			svc.jobProblem(tgtJob, updateProblem{File: relPath, Problem: "is not in the manifest"})
+		}
+		return nil
+	})
+
+	logger.Info("fixity check complete", "failed", failed, "modified", modified)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed the fixity check", failed, len(manifest.Entries))
+	}
+	return nil
+}
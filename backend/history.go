@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// File event actions
+const (
+	FileEventUpdate  = "update"
+	FileEventRename  = "rename"
+	FileEventDelete  = "delete"
+	FileEventRestore = "restore"
+	FileEventRotate  = "rotate"
+	FileEventUndo    = "undo"
+	FileEventRedo    = "redo"
+)
+
+// fileEvent records a change made to a master file in a unit. Metadata updates have one event for each
+// field with the old and new values. A rename has the old and new file names. The metadata updates made
+// by one request share an operation ID so they can be undone together; Undone is set once they have been.
+// An update with no operation ID cannot be undone as the old value of the file could not be read.
+type fileEvent struct {
+	ID          int64     `json:"id"`
+	UnitID      uint      `json:"unitID"`
+	JobID       int64     `json:"jobID,omitempty"`
+	OperationID string    `json:"operationID,omitempty"`
+	ComputeID   string    `json:"computeID"`
+	Action      string    `json:"action"`
+	File        string    `json:"file"`
+	Field       string    `json:"field,omitempty"`
+	OldValue    string    `json:"oldValue"`
+	NewValue    string    `json:"newValue"`
+	Undone      bool      `json:"undone"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// fieldValue returns the value of an update field from the metadata
+func (md *masterFileMetadata) fieldValue(field string) string {
+	switch field {
+	case "title":
+		return md.Title
+	case "description":
+		return md.Description
+	case "box":
+		return md.Box
+	case "folder":
+		return md.Folder
+	case "tag":
+		return md.Status
+	case "component":
+		return md.ComponentID
+	}
+	return ""
+}
+
+// currentMetadata gets the metadata for the files before they are changed so the old values
+// can be recorded. Files that cannot be read are left out
+func (svc *serviceContext) currentMetadata(ctx context.Context, files []string) map[string]masterFileMetadata {
+	out := make(map[string]masterFileMetadata)
+	misses := make([]string, 0)
+	for _, tgtFile := range files {
+		if _, found := out[tgtFile]; found {
+			continue
+		}
+		if md, found := svc.MetadataCache.get(tgtFile); found {
+			out[tgtFile] = *md
+		} else {
+			misses = append(misses, tgtFile)
+		}
+	}
+	if len(misses) == 0 {
+		return out
+	}
+
+	parsed, err := svc.readExifMetadata(ctx, misses, baseExifCmd())
+	if err != nil {
+		getLogger(ctx).Warn("unable to get metadata for the file history", "error", err.Error())
+		return out
+	}
+	for _, exifMD := range parsed {
+		out[exifMD.SourceFile] = parseExifData(&exifMD)
+	}
+	return out
+}
+
+// recordFileEvents saves events to the unit history. The change has already been made, so a failure
+// here is logged and does not fail the request
+func (svc *serviceContext) recordFileEvents(ctx context.Context, events ...fileEvent) {
+	if len(events) == 0 {
+		return
+	}
+	now := time.Now()
+	for idx := range events {
+		events[idx].File = path.Base(events[idx].File)
+		events[idx].CreatedAt = now
+	}
+	if err := svc.DB.CreateInBatches(events, 100).Error; err != nil {
+		getLogger(ctx).Error("unable to record file events", "count", len(events), "action", events[0].Action, "error", err.Error())
+	}
+}
+
+// getUnitHistory returns the file events for a unit, most recent first. They can be filtered by file and user.
+// A file filter includes the rename that gave the file its name
+func (svc *serviceContext) getUnitHistory(c *gin.Context) {
+	unitID, err := strconv.ParseUint(c.Param("uid"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid unit")
+		return
+	}
+
+	histQ := svc.DB.Where("unit_id=?", unitID)
+	if file := c.Query("file"); file != "" {
+		histQ = histQ.Where("(file=? or (action=? and new_value=?))", file, FileEventRename, file)
+	}
+	if user := c.Query("user"); user != "" {
+		histQ = histQ.Where("compute_id=?", user)
+	}
+
+	var out []fileEvent
+	if err := histQ.Order("created_at desc, id desc").Find(&out).Error; err != nil {
+		getLogger(c.Request.Context()).Error("unable to get unit history", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -45,6 +47,7 @@ type exifData struct {
 type updateProblem struct {
 	File    string `json:"file"`
 	Problem string `json:"problem"`
+	Rule    string `json:"rule,omitempty"`
 }
 
 type exifFileCommands struct {
@@ -59,21 +62,23 @@ func (svc *serviceContext) updateImageMetadata(c *gin.Context) {
 	fileName := c.Param("file")
 	updateField := c.Query("field")
 	updateValue := c.Query("value")
-	log.Printf("INFO: update %s/%s %s to %s", unitDir, fileName, updateField, updateValue)
-
-	if svc.isBatchInProgress(rawUnitID) {
-		log.Printf("WARNING: request to update metadata for file from unit %s rejected because it is already being processed", rawUnitID)
-		c.String(http.StatusConflict, "this unit is currently being processed by another user")
+	claims := getJWTClaims(c)
+	ctx := c.Request.Context()
+	logger := getLogger(ctx).With("file", fileName)
+	logger.Info("update file metadata", "field", updateField, "value", updateValue)
+
+	lock, lockErr := svc.acquireUnitLock(ctx, rawUnitID, claims.ComputeID, "update")
+	if lockErr != nil {
+		logger.Warn("update rejected; unit is locked", "error", lockErr.Message)
+		c.String(lockErr.StatusCode, lockErr.Message)
 		return
 	}
-
-	svc.addBatchProcess(rawUnitID)
-	defer svc.removeBatchProcess(rawUnitID)
+	defer svc.releaseUnitLock(lock)
 
 	tgtFile := path.Join(unitDir, fileName)
 	exifTag := getExifTag(updateField)
 	if exifTag == "" {
-		log.Printf("ERROR: invalid update field %s", updateField)
+		logger.Error("invalid update field", "field", updateField)
 		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid update field", updateField))
 		return
 	}
@@ -83,9 +88,9 @@ func (svc *serviceContext) updateImageMetadata(c *gin.Context) {
 	// folder are temporary. The actual location will be held in iptc:sub-location
 	cmd := []string{fmt.Sprintf("-%s=%s", exifTag, updateValue)}
 	if updateField == "box" || updateField == "folder" {
-		loc, err := svc.getUpdatedLocation(rawUnitID, tgtFile, updateField, updateValue)
+		loc, err := svc.getUpdatedLocation(ctx, rawUnitID, tgtFile, updateField, updateValue)
 		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
+			logger.Error("unable to generate location", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -93,22 +98,34 @@ func (svc *serviceContext) updateImageMetadata(c *gin.Context) {
 	}
 	cmd = append(cmd, tgtFile)
 
-	log.Printf("INFO: update command %v", cmd)
-	_, err := exec.Command("exiftool", cmd...).Output()
+	origMD, origRead := svc.currentMetadata(ctx, []string{tgtFile})[tgtFile]
+	logger.Info("run update command", "command", cmd)
+	_, err := svc.ExifTool.run(cmd...)
 	if err != nil {
-		log.Printf("ERROR: exiftool %v failed: %s", cmd, err.Error())
+		logger.Error("exiftool update failed", "command", cmd, "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	cleanupExifToolDups(tgtFile)
-	exifMD, _ := getExifData(tgtFile)
+	svc.MetadataCache.invalidate(tgtFile)
+	unitID, _ := strconv.ParseUint(rawUnitID, 10, 64)
+	updateEvt := fileEvent{UnitID: uint(unitID), ComputeID: claims.ComputeID, Action: FileEventUpdate,
+		File: tgtFile, Field: updateField, OldValue: origMD.fieldValue(updateField), NewValue: updateValue}
+	if origRead {
+		updateEvt.OperationID = newRequestID()
+	}
+	svc.recordFileEvents(ctx, updateEvt)
+	readInfo, _ := os.Stat(tgtFile)
+	exifMD, _ := svc.getExifData(ctx, tgtFile)
 	mdRec := parseExifData(exifMD)
+	svc.MetadataCache.put(tgtFile, readInfo, mdRec)
 	c.JSON(http.StatusOK, mdRec)
 }
 
-func (svc *serviceContext) getUpdatedLocation(unitID, tgtFile, updateField, updateValue string) (string, error) {
-	log.Printf("INFO: update %s to [%s]: generate new location data for %s", updateField, updateValue, tgtFile)
-	exifMD, err := getExifData(tgtFile)
+func (svc *serviceContext) getUpdatedLocation(ctx context.Context, unitID, tgtFile, updateField, updateValue string) (string, error) {
+	logger := getLogger(ctx).With("file", path.Base(tgtFile))
+	logger.Info("generate new location data", "field", updateField, "value", updateValue)
+	exifMD, err := svc.getExifData(ctx, tgtFile)
 	if err != nil {
 		return "", fmt.Errorf("unable to get existing metadata for %s update: %s", tgtFile, err.Error())
 	}
@@ -159,12 +176,12 @@ func (svc *serviceContext) getUpdatedLocation(unitID, tgtFile, updateField, upda
 		}
 	}
 	if missingCnt == len(locParts) {
-		log.Printf("INFO: %s has no location data set; remove iptc:sub-location", tgtFile)
+		logger.Info("file has no location data set; remove iptc:sub-location")
 		return "", nil
 	}
 
 	newLoc := strings.Join(locParts, ", ")
-	log.Printf("INFO: %s has new location [%s]", tgtFile, newLoc)
+	logger.Info("file has new location", "location", newLoc)
 	return newLoc, nil
 }
 
@@ -182,7 +199,6 @@ func getExifTag(fieldName string) string {
 			break
 		}
 	}
-	// log.Printf("INFO: field %s matches exif tag  %s", fieldName, exifTag)
 	return exifTag
 }
 
@@ -193,185 +209,199 @@ func cleanupExifToolDups(fullPath string) {
 	os.Remove(dupPath)
 }
 
-func (svc *serviceContext) updateMetadataBatch(c *gin.Context) {
+type metadataChange struct {
+	File  string `json:"file"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type renameRequest struct {
+	Original string `json:"original"`
+	NewName  string `json:"new"`
+}
+
+// queueUnitJob adds a job for changes to the files in a unit. It responds with the new job or
+// a conflict if the unit is already locked or has another pending or running job.
+func (svc *serviceContext) queueUnitJob(c *gin.Context, jobType string, total int, payload any) {
 	rawUnitID := c.Param("uid")
-	uid := padLeft(rawUnitID, 9)
-	unitDir := fmt.Sprintf("%s/%s", svc.ImagesDir, uid)
-	if svc.isBatchInProgress(rawUnitID) {
-		log.Printf("WARNING: request to batch update unit %s rejected because it is already being processed", rawUnitID)
+	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context()).With("jobType", jobType)
+	unitID, err := strconv.ParseUint(rawUnitID, 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid unit")
+		return
+	}
+
+	if svc.unitIsLocked(uint(unitID)) {
+		logger.Warn("request rejected; unit is locked")
 		c.String(http.StatusConflict, "this unit is currently being processed by another user")
 		return
 	}
+	if active := svc.unitJobInProgress(uint(unitID)); active != nil {
+		logger.Warn("request rejected; unit has an active job", "activeJobType", active.JobType, "activeJobID", active.ID, "activeStatus", active.Status)
+		c.String(http.StatusConflict, fmt.Sprintf("this unit is currently being processed by %s", active.Owner))
+		return
+	}
 
-	var mdPost []struct {
-		File  string `json:"file"`
-		Field string `json:"field"`
-		Value string `json:"value"`
+	// the active unit key only allows one pending or running job for the unit, so a request that
+	// passed the check above at the same time as this one cannot queue a second job
+	activeUnitID := uint(unitID)
+	newJob := job{JobType: jobType, UnitID: uint(unitID), ActiveUnitID: &activeUnitID, UserID: claims.UserID, Owner: claims.ComputeID, Total: total}
+	if err := svc.enqueueJob(c.Request.Context(), &newJob, payload); err != nil {
+		if active := svc.unitJobInProgress(uint(unitID)); active != nil {
+			logger.Warn("request rejected; another job was queued first", "activeJobType", active.JobType, "activeJobID", active.ID)
+			c.String(http.StatusConflict, fmt.Sprintf("this unit is currently being processed by %s", active.Owner))
+			return
+		}
+		logger.Error("unable to queue job", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
+	c.JSON(http.StatusAccepted, newJob)
+}
 
+func (svc *serviceContext) updateMetadataBatch(c *gin.Context) {
+	var mdPost []metadataChange
 	qpErr := c.ShouldBindJSON(&mdPost)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid updateMetadata payload: %v", qpErr)
+		getLogger(c.Request.Context()).Error("invalid update metadata payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
+	svc.queueUnitJob(c, jobUpdateMetadata, len(mdPost), mdPost)
+}
+
+func (svc *serviceContext) runUpdateMetadataJob(tgtJob *job) error {
+	rawUnitID := fmt.Sprintf("%d", tgtJob.UnitID)
+	uid := padLeft(rawUnitID, 9)
+	unitDir := fmt.Sprintf("%s/%s", svc.ImagesDir, uid)
+	logger := tgtJob.logger()
+	var mdPost []metadataChange
+	if err := json.Unmarshal([]byte(tgtJob.Payload), &mdPost); err != nil {
+		return fmt.Errorf("invalid update metadata payload: %s", err.Error())
+	}
+
+	lock, lockErr := svc.acquireUnitLock(tgtJob.context(), rawUnitID, tgtJob.Owner, "update")
+	if lockErr != nil {
+		return errors.New(lockErr.Message)
+	}
+	defer svc.releaseUnitLock(lock)
 
 	start := time.Now()
-	commandsBatch := make([]exifFileCommands, 0)
-	errChannel := make(chan updateProblem)
-	var updateWG sync.WaitGroup
-	svc.addBatchProcess(rawUnitID)
-	defer svc.removeBatchProcess(rawUnitID)
+	svc.jobPhase(tgtJob, "update")
+	svc.jobAddWork(tgtJob, len(mdPost))
 
-	log.Printf("INFO: batch master file metadata in %s with batch size %d", unitDir, svc.BatchSize)
+	changedFiles := make([]string, 0, len(mdPost))
 	for _, change := range mdPost {
-		cmd := exifFileCommands{File: change.File, Commands: make([]string, 0)}
-		exifTag := getExifTag(change.Field)
-		cmd.Commands = append(cmd.Commands, fmt.Sprintf("-%s=%s", exifTag, change.Value))
-		if change.Field == "box" || change.Field == "folder" {
-			loc, err := svc.getUpdatedLocation(rawUnitID, change.File, change.Field, change.Value)
-			if err != nil {
-				log.Printf("ERROR: %s", err.Error())
-				c.String(http.StatusInternalServerError, err.Error())
-				return
-			}
-			cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:Sub-location=%s", loc))
-		}
+		changedFiles = append(changedFiles, change.File)
+	}
+	origMD := svc.currentMetadata(tgtJob.context(), changedFiles)
 
-		cmd.Commands = append(cmd.Commands, change.File)
-		commandsBatch = append(commandsBatch, cmd)
-		if len(commandsBatch) == svc.BatchSize {
-			updateWG.Add(1)
-			cmdCopy := make([]exifFileCommands, len(commandsBatch))
-			copy(cmdCopy, commandsBatch)
-			go func() {
-				defer updateWG.Done()
-				batchUpdateExifData(cmdCopy, errChannel)
-			}()
-			commandsBatch = make([]exifFileCommands, 0)
+	logger.Info("batch master file metadata", "dir", unitDir, "batchSize", svc.BatchSize)
+	commands := make([]exifFileCommands, 0, len(mdPost))
+	for _, change := range mdPost {
+		cmd, err := svc.metadataUpdateCommand(tgtJob.context(), rawUnitID, change)
+		if err != nil {
+			return err
 		}
+		commands = append(commands, cmd)
 	}
+	failed := svc.runMetadataCommands(tgtJob, lock, commands)
 
-	if len(commandsBatch) > 0 {
-		updateWG.Add(1)
-		go func() {
-			defer updateWG.Done()
-			batchUpdateExifData(commandsBatch, errChannel)
-		}()
+	// all of the changes in the batch are one operation so they can be undone together
+	operationID := fmt.Sprintf("job-%d", tgtJob.ID)
+	events := make([]fileEvent, 0, len(mdPost))
+	for _, change := range mdPost {
+		if failed[path.Base(change.File)] {
+			continue
+		}
+		evt := fileEvent{UnitID: tgtJob.UnitID, JobID: tgtJob.ID, ComputeID: tgtJob.Owner, Action: FileEventUpdate,
+			File: change.File, Field: change.Field, NewValue: change.Value}
+		if md, found := origMD[change.File]; found {
+			evt.OperationID = operationID
+			evt.OldValue = md.fieldValue(change.Field)
+		}
+		events = append(events, evt)
 	}
-
-	var resp struct {
-		Success  bool            `json:"success"`
-		Problems []updateProblem `json:"problems"`
+	svc.recordFileEvents(tgtJob.context(), events...)
+	if err := lock.leaseLost(); err != nil {
+		return fmt.Errorf("%s; %d of %d files were updated", err.Error(), len(events), len(mdPost))
 	}
-	resp.Success = true
-	resp.Problems = make([]updateProblem, 0)
-
-	go func() {
-		log.Printf("INFO: await all metadata updates and collect any problems in the response")
-		updateWG.Wait()
-		close(errChannel)
-		log.Printf("INFO: all batches complete")
-	}()
 
-	for problem := range errChannel {
-		resp.Success = false
-		resp.Problems = append(resp.Problems, problem)
-	}
-
-	elapsed := time.Since(start)
-	elapsedMS := int64(elapsed / time.Millisecond)
-	log.Printf("INFO: updated  %d masterfiles in %dms", len(mdPost), elapsedMS)
-
-	c.JSON(http.StatusOK, resp)
+	logger.Info("master file metadata updated", "files", len(mdPost), "elapsedMS", time.Since(start).Milliseconds())
+	return nil
 }
 
 func (svc *serviceContext) renameFiles(c *gin.Context) {
-	rawUnitID := c.Param("uid")
-	unit := padLeft(rawUnitID, 9)
-	if svc.isBatchInProgress(rawUnitID) {
-		log.Printf("WARNING: request to rename files for unit %s rejected because it is already being processed", rawUnitID)
-		c.String(http.StatusConflict, "this unit is currently being processed by another user")
-		return
-	}
-
-	var rnPost []struct {
-		Original string `json:"original"`
-		NewName  string `json:"new"`
-	}
-	log.Printf("INFO: rename files for unit %s", unit)
+	var rnPost []renameRequest
+	logger := getLogger(c.Request.Context())
+	logger.Info("rename files")
 
 	qpErr := c.ShouldBindJSON(&rnPost)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid rename payload: %v", qpErr)
+		logger.Error("invalid rename payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-
-	// create a working dir in the root of the unit directory to hold the original files to be renamed
-	svc.addBatchProcess(rawUnitID)
-	defer svc.removeBatchProcess(rawUnitID)
-	backUpDir := path.Join(svc.ImagesDir, "tmp", unit)
-	_, existErr := os.Stat(backUpDir)
-	if existErr == nil {
-		log.Printf("INFO: working directory %s already exists, removing it", backUpDir)
-		err := os.RemoveAll(backUpDir)
+	if c.Query("dryrun") == "true" {
+		unitID, err := strconv.ParseUint(c.Param("uid"), 10, 64)
 		if err != nil {
-			log.Printf("ERROR: unable to remove %s: %s", backUpDir, err.Error())
-			c.String(http.StatusInternalServerError, "unable to cleanup old working directory")
+			c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid unit", c.Param("uid")))
 			return
 		}
-	}
-	err := os.Mkdir(backUpDir, 0777)
-	if err != nil {
-		log.Printf("ERROR: unable to make backup dir %s: %s", backUpDir, err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		svc.dryRunRename(c, uint(unitID), rnPost)
 		return
 	}
+	svc.queueUnitJob(c, jobRenameFiles, len(rnPost), rnPost)
+}
 
-	// do this is two passes to avoid conflicts... move the files to tmp with new name first
-	for _, rn := range rnPost {
-		tmpFile := path.Join(backUpDir, rn.NewName)
-		log.Printf("INFO: rename %s to %s", rn.Original, tmpFile)
-		err := os.Rename(rn.Original, tmpFile)
-		if err != nil {
-			log.Printf("ERROR: unable to rename %s: %s", rn.Original, err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
-			return
-		}
+// runRenameJob renames the files in a unit. The renames are checked for conflicts and journaled before any
+// file is moved. If the rename fails part way, all files are returned to their original names.
+func (svc *serviceContext) runRenameJob(tgtJob *job) error {
+	rawUnitID := fmt.Sprintf("%d", tgtJob.UnitID)
+	unit := padLeft(rawUnitID, 9)
+	logger := tgtJob.logger()
+	var rnPost []renameRequest
+	if err := json.Unmarshal([]byte(tgtJob.Payload), &rnPost); err != nil {
+		return fmt.Errorf("invalid rename payload: %s", err.Error())
 	}
 
-	// second pass moves the renamed files back where they belong
-	for _, rn := range rnPost {
-		tmpFile := path.Join(backUpDir, rn.NewName)
-		origDir, _ := path.Split(rn.Original)
-		renamed := path.Join(origDir, rn.NewName)
+	lock, lockErr := svc.acquireUnitLock(tgtJob.context(), rawUnitID, tgtJob.Owner, "rename")
+	if lockErr != nil {
+		return errors.New(lockErr.Message)
+	}
+	defer svc.releaseUnitLock(lock)
 
-		/// if renamed already exists, something is wrong! leave as-is and abort before files are lost or overwritten
-		_, existErr := os.Stat(renamed)
-		if existErr == nil {
-			log.Printf("ERROR: renamed file %s already exists. Abort to avoid data loss", renamed)
-			c.String(http.StatusInternalServerError, fmt.Sprintf("Renamed file %s already exists! Rename aborted. Manual corrections are required.", renamed))
-			return
+	journal, problems := svc.planRename(tgtJob.UnitID, rnPost)
+	if len(problems) > 0 {
+		for _, p := range problems {
+			logger.Error("unable to rename file", "file", p.File, "problem", p.Problem)
+			svc.jobProblem(tgtJob, p)
 		}
+		return fmt.Errorf("rename has %d conflicts; no files were changed", len(problems))
+	}
 
-		log.Printf("INFO: move tmp %s to %s", tmpFile, renamed)
-		err := os.Rename(tmpFile, renamed)
-		if err != nil {
-			log.Printf("ERROR: unable to restore %s from %s: %s", renamed, tmpFile, err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
-			return
+	// create a working dir to hold the files while they are renamed. It can only be left over here if it is empty
+	if pathExists(journal.WorkDir) {
+		logger.Info("working directory already exists; remove it", "dir", journal.WorkDir)
+		if err := svc.Files.removeAll(journal.WorkDir); err != nil {
+			logger.Error("unable to remove working directory", "dir", journal.WorkDir, "error", err.Error())
+			return errors.New("unable to cleanup old working directory")
 		}
 	}
+	if err := svc.Files.mkdir(journal.WorkDir); err != nil {
+		return fmt.Errorf("unable to make backup dir %s: %s", journal.WorkDir, err.Error())
+	}
 
-	// last, cleanup tmp
-	log.Printf("INFO: cleaning up working dorectory %s", backUpDir)
-	err = os.RemoveAll(backUpDir)
-	if err != nil {
-		log.Printf("ERROR: unable to clean up %s: %s", backUpDir, err.Error())
+	journal.JobID = tgtJob.ID
+	journal.Owner = tgtJob.Owner
+	if err := svc.DB.Create(journal).Error; err != nil {
+		return fmt.Errorf("unable to create rename journal: %s", err.Error())
 	}
+	logger.Info("rename journal created", "journalID", journal.ID, "files", len(journal.Entries))
 
-	c.String(http.StatusOK, "ok")
+	defer svc.UnitIndex.invalidate(path.Join(svc.ImagesDir, unit))
+	return svc.applyRename(journal, tgtJob, lock)
 }
 
 func (svc *serviceContext) rotateFile(c *gin.Context) {
@@ -387,20 +417,22 @@ func (svc *serviceContext) rotateFile(c *gin.Context) {
 		rotateDir = "-90"
 	}
 
-	if svc.isBatchInProgress(rawUnitID) {
-		log.Printf("WARNING: request to rotate file from unit %s rejected because it is already being processed", rawUnitID)
-		c.String(http.StatusConflict, "this unit is currently being processed by another user")
+	claims := getJWTClaims(c)
+	ctx := c.Request.Context()
+	logger := getLogger(ctx).With("file", file)
+	lock, lockErr := svc.acquireUnitLock(ctx, rawUnitID, claims.ComputeID, "rotate")
+	if lockErr != nil {
+		logger.Warn("rotate rejected; unit is locked", "error", lockErr.Message)
+		c.String(lockErr.StatusCode, lockErr.Message)
 		return
 	}
-
-	svc.addBatchProcess(rawUnitID)
-	defer svc.removeBatchProcess(rawUnitID)
+	defer svc.releaseUnitLock(lock)
 
 	basePath := path.Join(svc.ImagesDir, unit)
-	log.Printf("INFO: looking for image %s in unit dir %s", file, basePath)
-	fullPath := findFile(basePath, file)
+	logger.Info("look for image in unit dir", "dir", basePath)
+	fullPath := svc.UnitIndex.find(basePath, file)
 	if fullPath == "" {
-		log.Printf("ERROR: unable to find %s found in unit dir %s", file, basePath)
+		logger.Error("image not found in unit dir", "dir", basePath)
 		c.String(http.StatusBadRequest, fmt.Sprintf("%s not found", file))
 		return
 	}
@@ -408,31 +440,31 @@ func (svc *serviceContext) rotateFile(c *gin.Context) {
 	// grab the currrent data in the exif headers ad the rotate command wipes it all
 	cmdArray := []string{"-json", "-iptc:OwnerID", "-iptc:headline", "-iptc:caption-abstract",
 		"-iptc:ClassifyState", "-iptc:ContentLocationName", "-iptc:Keywords", "-iptc:Sub-location", fullPath}
-	stdout, err := exec.Command("exiftool", cmdArray...).Output()
+	stdout, err := svc.ExifTool.run(cmdArray...)
 	if err != nil {
-		log.Printf("ERROR: unable to get %s metadata before rotation: %s", file, err.Error())
+		logger.Error("unable to get metadata before rotation", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	var origMD []exifData
 	err = json.Unmarshal(stdout, &origMD)
 	if err != nil {
-		log.Printf("ERROR: unable to parse %s metadata before rotation: %s", file, err.Error())
+		logger.Error("unable to parse metadata before rotation", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: rotate %s", fullPath)
-	cmd := []string{fullPath, "-rotate", rotateDir, fullPath}
-	rotateOut, err := exec.Command("magick", cmd...).CombinedOutput()
+	logger.Info("rotate image", "path", fullPath, "direction", rotateDirString)
+	rotateOut, err := svc.ImageTool.rotate(fullPath, rotateDir)
+	svc.MetadataCache.invalidate(fullPath)
 	if err != nil {
-		log.Printf("ERROR: unable to rotate %s: %s", fullPath, rotateOut)
+		logger.Error("unable to rotate image", "path", fullPath, "error", string(rotateOut))
 		c.String(http.StatusInternalServerError, fmt.Sprintf("unable to rotate file: %s", rotateOut))
 		return
 	}
 
-	log.Printf("INFO: restoring metadata after rotaion of %s", file)
-	cmd = make([]string, 0)
+	logger.Info("restore metadata after rotation")
+	cmd := make([]string, 0)
 	if origMD[0].Component != nil {
 		cmd = append(cmd, fmt.Sprintf("-iptc:OwnerID=%v", origMD[0].Component))
 	}
@@ -453,111 +485,208 @@ func (svc *serviceContext) rotateFile(c *gin.Context) {
 	}
 	cmd = append(cmd, fmt.Sprintf("-iptc:ClassifyState=%v", origMD[0].ClassifyState))
 	cmd = append(cmd, fullPath)
-	_, err = exec.Command("exiftool", cmd...).Output()
+	_, err = svc.ExifTool.run(cmd...)
 	if err != nil {
-		log.Printf("WARNING: unable to restore metadata to %s after rotation: %s", file, err.Error())
+		logger.Warn("unable to restore metadata after rotation", "error", err.Error())
 	} else {
 		cleanupExifToolDups(fullPath)
 	}
 
+	unitID, _ := strconv.ParseUint(rawUnitID, 10, 64)
+	svc.recordFileEvents(ctx, fileEvent{UnitID: uint(unitID), ComputeID: claims.ComputeID, Action: FileEventRotate, File: fullPath,
+		Field: "orientation", NewValue: rotateDirString})
 	c.String(http.StatusOK, "rotated")
 }
 
-func batchUpdateExifData(fileCommands []exifFileCommands, channel chan updateProblem) {
-	log.Printf("INFO: start batch of %d update commands", len(fileCommands))
+// metadataUpdateCommand builds the exiftool command that sets a field of a master file. Box and folder
+// changes also update the location that is generated from them
+func (svc *serviceContext) metadataUpdateCommand(ctx context.Context, rawUnitID string, change metadataChange) (exifFileCommands, error) {
+	cmd := exifFileCommands{File: change.File, Commands: make([]string, 0)}
+	exifTag := getExifTag(change.Field)
+	cmd.Commands = append(cmd.Commands, fmt.Sprintf("-%s=%s", exifTag, change.Value))
+	if change.Field == "box" || change.Field == "folder" {
+		loc, err := svc.getUpdatedLocation(ctx, rawUnitID, change.File, change.Field, change.Value)
+		if err != nil {
+			return cmd, err
+		}
+		cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:Sub-location=%s", loc))
+	}
+	cmd.Commands = append(cmd.Commands, change.File)
+	return cmd, nil
+}
+
+// runMetadataCommands runs the update commands in parallel batches and adds any problems to the job.
+// The names of the files that could not be updated are returned
+func (svc *serviceContext) runMetadataCommands(tgtJob *job, lock *unitLock, commands []exifFileCommands) map[string]bool {
+	logger := tgtJob.logger()
+	errChannel := make(chan updateProblem)
+	var updateWG sync.WaitGroup
+	fileDone := func(file string) {
+		svc.jobFileProcessed(tgtJob, path.Base(file))
+	}
+
+	for start := 0; start < len(commands); start += svc.BatchSize {
+		end := min(start+svc.BatchSize, len(commands))
+		updateWG.Add(1)
+		go func(batch []exifFileCommands) {
+			defer updateWG.Done()
+			svc.batchUpdateExifData(tgtJob.context(), batch, lock, errChannel, fileDone)
+		}(commands[start:end])
+	}
+
+	go func() {
+		logger.Info("await all metadata updates and collect any problems in the job")
+		updateWG.Wait()
+		close(errChannel)
+		logger.Info("all update batches complete")
+	}()
+
+	failed := make(map[string]bool)
+	for problem := range errChannel {
+		failed[problem.File] = true
+		svc.jobProblem(tgtJob, problem)
+	}
+	return failed
+}
+
+// batchUpdateExifData runs the update commands and sends any problems to the channel. If provided,
+// fileDone is called after each file has been processed. Once the unit lock is lost, the remaining
+// files are not updated and are sent as problems
+func (svc *serviceContext) batchUpdateExifData(ctx context.Context, fileCommands []exifFileCommands, lock *unitLock, channel chan updateProblem, fileDone func(file string)) {
+	logger := getLogger(ctx)
+	logger.Info("start batch of update commands", "count", len(fileCommands))
 	startTime := time.Now()
 	for _, fc := range fileCommands {
-		cmd := exec.Command("exiftool", fc.Commands...)
-		out, err := cmd.CombinedOutput()
+		if err := lock.leaseLost(); err != nil {
+			channel <- updateProblem{File: path.Base(fc.File), Problem: err.Error()}
+			continue
+		}
+		_, err := svc.ExifTool.run(fc.Commands...)
+		svc.MetadataCache.invalidate(fc.File)
 		if err != nil {
-			log.Printf("ERROR: unable to update %s metadata with %v: %s", fc.File, cmd, out)
+			logger.Error("unable to update file metadata", "file", path.Base(fc.File), "command", fc.Commands, "error", err.Error())
 			channel <- updateProblem{File: path.Base(fc.File), Problem: err.Error()}
 		} else {
 			cleanupExifToolDups(fc.File)
 		}
+		if fileDone != nil {
+			fileDone(fc.File)
+		}
 	}
-	elapsed := time.Since(startTime)
-	log.Printf("INFO: batch of %d update commands has finished in %d ms", len(fileCommands), elapsed.Milliseconds())
+	logger.Info("batch of update commands has finished", "count", len(fileCommands), "elapsedMS", time.Since(startTime).Milliseconds())
 }
 
-func checkExifHeaders(files []string, checkLocation bool, channel chan updateProblem) {
-	log.Printf("INFO: start batch of %d validate commands", len(files))
+// checkExifHeaders validates the title, location and component metadata required by the step for a batch of
+// files and sends any problems to the channel. If provided, fileDone is called after each file has been checked
+func (svc *serviceContext) checkExifHeaders(ctx context.Context, files []string, rules *stepValidation, channel chan updateProblem, fileDone func(file string)) {
+	logger := getLogger(ctx)
+	logger.Info("start batch of validate commands", "count", len(files))
 	startTime := time.Now()
-	cmdArray := []string{"-json", "-iptc:headline", "-iptc:Sub-location"}
-	cmdArray = append(cmdArray, files...)
-	cmd := exec.Command("exiftool", cmdArray...)
-	stdout, err := cmd.Output()
+	parsed, err := svc.readExifMetadata(ctx, files, []string{"-json", "-iptc:headline", "-iptc:Sub-location", "-iptc:OwnerID"})
 	if err != nil {
-		log.Printf("ERROR: unable to get qa metadata: %s", err.Error())
-		channel <- updateProblem{File: "all", Problem: err.Error()}
+		logger.Error("unable to get qa metadata", "error", err.Error())
+		channel <- updateProblem{File: "all", Problem: err.Error(), Rule: RuleMetadataUnreadable}
 	} else {
-
-		var parsed []exifData
-		json.Unmarshal(stdout, &parsed)
 		for _, exifMD := range parsed {
-			if exifMD.Title == nil {
-				log.Printf("ERROR: %s is missing a title", exifMD.SourceFile)
-				channel <- updateProblem{File: exifMD.SourceFile, Problem: "Missing title metadata"}
+			if rules.TitleRequired && exifMD.Title == nil {
+				logger.Error("file is missing a title", "file", exifMD.SourceFile)
+				channel <- updateProblem{File: exifMD.SourceFile, Problem: "Missing title metadata", Rule: RuleMissingTitle}
 			}
 
-			if checkLocation {
-				log.Printf("INFO: files require location check; location is [%s]", exifMD.Location)
+			if rules.LocationRequired {
 				if exifMD.Location == nil {
-					log.Printf("ERROR: %s is missing a location", exifMD.SourceFile)
-					channel <- updateProblem{File: exifMD.SourceFile, Problem: "Missing location metadata"}
+					logger.Error("file is missing a location", "file", exifMD.SourceFile)
+					channel <- updateProblem{File: exifMD.SourceFile, Problem: "Missing location metadata", Rule: RuleMissingLocation}
 				} else {
 					location := fmt.Sprintf("%v", exifMD.Location)
 					if strings.Contains(location, "UNK") {
-						channel <- updateProblem{File: exifMD.SourceFile, Problem: "Incomplete location metadata"}
+						logger.Error("file has an incomplete location", "file", exifMD.SourceFile, "location", location)
+						channel <- updateProblem{File: exifMD.SourceFile, Problem: "Incomplete location metadata", Rule: RuleIncompleteLocation}
 					}
 				}
 			}
+
+			if rules.ComponentRequired && exifMD.Component == nil {
+				logger.Error("file is missing a component", "file", exifMD.SourceFile)
+				channel <- updateProblem{File: exifMD.SourceFile, Problem: "Missing component", Rule: RuleMissingComponent}
+			}
+			if fileDone != nil {
+				fileDone(exifMD.SourceFile)
+			}
 		}
 	}
-	elapsed := time.Since(startTime)
-	log.Printf("INFO: batch of %d validate commands has finished in %d ms", len(files), elapsed.Milliseconds())
+	logger.Info("batch of validate commands has finished", "count", len(files), "elapsedMS", time.Since(startTime).Milliseconds())
 }
 
-func getExifMetadataBatch(tgtFiles []string, channel chan masterFileMetadata) {
-	log.Printf("INFO: start get metadata batch of %d files", len(tgtFiles))
+func (svc *serviceContext) getExifMetadataBatch(ctx context.Context, tgtFiles []string, channel chan masterFileMetadata) {
+	logger := getLogger(ctx)
+	logger.Info("start get metadata batch", "count", len(tgtFiles))
 	startTime := time.Now()
-	cmdArray := baseExifCmd()
-	cmdArray = append(cmdArray, tgtFiles...)
-	cmd := exec.Command("exiftool", cmdArray...)
-	cmdOut, err := cmd.Output()
+	readInfo := make(map[string]os.FileInfo)
+	for _, tgtFile := range tgtFiles {
+		if info, err := os.Stat(tgtFile); err == nil {
+			readInfo[tgtFile] = info
+		}
+	}
+	parsed, err := svc.readExifMetadata(ctx, tgtFiles, baseExifCmd())
 	if err != nil {
-		log.Printf("WARNINIG: unable to get image metadata: %s", err.Error())
+		logger.Warn("unable to get image metadata", "error", err.Error())
 		return
 	}
 
-	var parsed []exifData
-	json.Unmarshal(cmdOut, &parsed)
 	for _, exifMD := range parsed {
 		mdRec := parseExifData(&exifMD)
+		svc.MetadataCache.put(exifMD.SourceFile, readInfo[exifMD.SourceFile], mdRec)
 		channel <- mdRec
 	}
 
-	elapsed := time.Since(startTime)
-	log.Printf("INFO: get metadata batch of %d files has finished in %d ms", len(tgtFiles), elapsed.Milliseconds())
+	logger.Info("get metadata batch has finished", "count", len(tgtFiles), "elapsedMS", time.Since(startTime).Milliseconds())
 }
 
-func getExifData(tgtFile string) (*exifData, error) {
-	log.Printf("INFO: get exif metadata for %s", tgtFile)
-	cmdArray := baseExifCmd()
-	cmdArray = append(cmdArray, tgtFile)
-	cmd := exec.Command("exiftool", cmdArray...)
-
-	cmdOut, err := cmd.Output()
+func (svc *serviceContext) getExifData(ctx context.Context, tgtFile string) (*exifData, error) {
+	logger := getLogger(ctx).With("file", path.Base(tgtFile))
+	logger.Info("get exif metadata")
+	parsed, err := svc.readExifMetadata(ctx, []string{tgtFile}, baseExifCmd())
 	if err != nil {
-		log.Printf("WARNINIG: unable to get image metadata: %s", err.Error())
-		return nil, fmt.Errorf("%s", cmdOut)
+		logger.Warn("unable to get image metadata", "error", err.Error())
+		return nil, err
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no metadata found for %s", tgtFile)
 	}
+	return &parsed[0], nil
+}
 
+// readExifMetadata reads metadata for the files with the native TIFF reader. Any files that it cannot
+// read are passed to exiftool with the specified command, so the results are in the same form either way
+func (svc *serviceContext) readExifMetadata(ctx context.Context, files []string, exifCmd []string) ([]exifData, error) {
+	out := make([]exifData, 0, len(files))
+	fallback := make([]string, 0)
+	for _, tgtFile := range files {
+		md, err := readTIFFMetadata(tgtFile)
+		if err != nil {
+			if !errors.Is(err, errNotNativeTIFF) {
+				getLogger(ctx).Warn("unable to read metadata natively", "file", tgtFile, "error", err.Error())
+			}
+			fallback = append(fallback, tgtFile)
+			continue
+		}
+		out = append(out, *md)
+	}
+	if len(fallback) == 0 {
+		return out, nil
+	}
+
+	cmdArray := append(exifCmd, fallback...)
+	cmdOut, err := svc.ExifTool.run(cmdArray...)
+	if err != nil {
+		return nil, err
+	}
 	var parsed []exifData
 	if err := json.Unmarshal(cmdOut, &parsed); err != nil {
 		return nil, fmt.Errorf("unable to parse exif headers: %s", err.Error())
 	}
-	return &parsed[0], nil
+	return append(out, parsed...), nil
 }
 
 func parseExifData(exifMD *exifData) masterFileMetadata {
@@ -603,7 +732,7 @@ func parseExifData(exifMD *exifData) masterFileMetadata {
 			fRes := exifMD.Resolution.(float64)
 			mdRec.Resolution = int(fRes)
 		default:
-			log.Printf("WARN: unsupported resolution type %s", valType)
+			slog.Warn("unsupported resolution type", "file", mdRec.FileName, "type", valType)
 			mdRec.Resolution = 0
 		}
 	}
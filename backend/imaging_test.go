@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+// renameUnit posts renames for the test unit and returns the response
+func (ts *testService) renameUnit(t *testing.T, query string, renames ...renameRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	c, resp := newTestRequest(http.MethodPost, "/api/units/12/rename"+query, renames, scanner, "uid", "12")
+	ts.renameFiles(c)
+	return resp
+}
+
+// expectFileContent checks that the file written by writeUnitFiles for origName is at tgtFile
+func expectFileContent(t *testing.T, tgtFile string, origName string) {
+	t.Helper()
+	content, err := os.ReadFile(tgtFile)
+	if err != nil {
+		t.Errorf("expected %s to exist: %s", tgtFile, err.Error())
+		return
+	}
+	if string(content) != origName {
+		t.Errorf("expected %s to hold the file originally named %s, got %s", tgtFile, origName, content)
+	}
+}
+
+func (ts *testService) renameJournalStatus(t *testing.T) string {
+	t.Helper()
+	var journal renameJournal
+	if err := ts.DB.Order("id desc").First(&journal).Error; err != nil {
+		t.Fatalf("unable to get rename journal: %s", err.Error())
+	}
+	return journal.Status
+}
+
+func TestRenameFilesDryRun(t *testing.T) {
+	ts := newWorkflowTestService(t)
+	unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif", "000000012_0003.tif")
+
+	resp := ts.renameUnit(t, "?dryrun=true",
+		renameRequest{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "000000012_0002.tif"},
+		renameRequest{Original: path.Join(unitDir, "000000012_0002.tif"), NewName: "000000012_0003.tif"},
+		renameRequest{Original: path.Join(unitDir, "000000012_0003.tif"), NewName: "000000012_0003.tif"},
+		renameRequest{Original: path.Join(unitDir, "000000012_0004.tif"), NewName: "000000012_0005.tif"},
+		renameRequest{Original: path.Join(ts.ImagesDir, "000000013", "000000013_0001.tif"), NewName: "000000012_0006.tif"},
+		renameRequest{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "../000000012_0007.tif"},
+	)
+	expectStatus(t, resp, http.StatusOK)
+	var out renameDryRunResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid dry run response: %s", err.Error())
+	}
+	if out.Files != 2 {
+		t.Errorf("expected 2 files that can be renamed, got %d", out.Files)
+	}
+	if len(out.Conflicts) != 4 {
+		t.Errorf("expected 4 conflicts, got %+v", out.Conflicts)
+	}
+	if len(ts.files.renames) > 0 {
+		t.Errorf("expected no files to be renamed, got %v", ts.files.renames)
+	}
+	var jobs int64
+	ts.DB.Model(&job{}).Count(&jobs)
+	if jobs != 0 {
+		t.Errorf("expected no job for a dry run, got %d", jobs)
+	}
+}
+
+func TestRenameFiles(t *testing.T) {
+	t.Run("swap names and rename in subdirectories", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif",
+			"box1/000000012_0003.tif", "box2/000000012_0004.tif")
+
+		resp := ts.renameUnit(t, "",
+			renameRequest{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "000000012_0002.tif"},
+			renameRequest{Original: path.Join(unitDir, "000000012_0002.tif"), NewName: "000000012_0001.tif"},
+			renameRequest{Original: path.Join(unitDir, "box1", "000000012_0003.tif"), NewName: "cover.tif"},
+			renameRequest{Original: path.Join(unitDir, "box2", "000000012_0004.tif"), NewName: "cover.tif"},
+		)
+		expectStatus(t, resp, http.StatusAccepted)
+		tgtJob := ts.runQueuedJob(t, resp)
+		if tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+
+		expectFileContent(t, path.Join(unitDir, "000000012_0001.tif"), "000000012_0002.tif")
+		expectFileContent(t, path.Join(unitDir, "000000012_0002.tif"), "000000012_0001.tif")
+		expectFileContent(t, path.Join(unitDir, "box1", "cover.tif"), "box1/000000012_0003.tif")
+		expectFileContent(t, path.Join(unitDir, "box2", "cover.tif"), "box2/000000012_0004.tif")
+		if pathExists(path.Join(ts.ImagesDir, "tmp", "000000012")) {
+			t.Errorf("expected the working directory to be removed")
+		}
+		if status := ts.renameJournalStatus(t); status != RenameComplete {
+			t.Errorf("expected complete rename journal, got %s", status)
+		}
+		var events int64
+		ts.DB.Model(&fileEvent{}).Where("action=?", FileEventRename).Count(&events)
+		if events != 4 {
+			t.Errorf("expected 4 rename events, got %d", events)
+		}
+	})
+
+	t.Run("conflicts stop the rename", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+
+		resp := ts.renameUnit(t, "",
+			renameRequest{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "000000012_0003.tif"},
+			renameRequest{Original: path.Join(unitDir, "000000012_0002.tif"), NewName: "000000012_0003.tif"},
+		)
+		expectStatus(t, resp, http.StatusAccepted)
+		tgtJob := ts.runQueuedJob(t, resp)
+		if tgtJob.Status != JobFailed || len(tgtJob.Problems) != 1 {
+			t.Fatalf("expected failed job with 1 problem, got %s with %+v", tgtJob.Status, tgtJob.Problems)
+		}
+		if len(ts.files.renames) > 0 {
+			t.Errorf("expected no files to be renamed, got %v", ts.files.renames)
+		}
+	})
+
+	rollbackTests := []struct {
+		name     string
+		failPath string
+	}{
+		{"roll back a failed stage", "000000012_0002.tif"},
+		{"roll back a failed restore", "000000012_0004.tif"},
+	}
+	for _, tc := range rollbackTests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newWorkflowTestService(t)
+			unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+			// the second file fails as it is staged from its original name or restored to its new name
+			ts.files.failPaths[path.Join(unitDir, tc.failPath)] = errors.New("permission denied")
+
+			resp := ts.renameUnit(t, "",
+				renameRequest{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "000000012_0003.tif"},
+				renameRequest{Original: path.Join(unitDir, "000000012_0002.tif"), NewName: "000000012_0004.tif"},
+			)
+			expectStatus(t, resp, http.StatusAccepted)
+			tgtJob := ts.runQueuedJob(t, resp)
+			if tgtJob.Status != JobFailed {
+				t.Fatalf("expected failed job, got %s", tgtJob.Status)
+			}
+			expectFileContent(t, path.Join(unitDir, "000000012_0001.tif"), "000000012_0001.tif")
+			expectFileContent(t, path.Join(unitDir, "000000012_0002.tif"), "000000012_0002.tif")
+			if pathExists(path.Join(unitDir, "000000012_0003.tif")) || pathExists(path.Join(unitDir, "000000012_0004.tif")) {
+				t.Errorf("expected no renamed files to be left")
+			}
+			if status := ts.renameJournalStatus(t); status != RenameRolledBack {
+				t.Errorf("expected rolled back rename journal, got %s", status)
+			}
+		})
+	}
+}
+
+func TestQueueUnitJob(t *testing.T) {
+	ts := newWorkflowTestService(t)
+	unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif")
+	rename := renameRequest{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "000000012_0002.tif"}
+
+	first := ts.renameUnit(t, "", rename)
+	expectStatus(t, first, http.StatusAccepted)
+	expectStatus(t, ts.renameUnit(t, "", rename), http.StatusConflict)
+
+	// a request that passed the in progress check at the same time cannot queue a second job
+	unitID := testUnitID
+	racer := job{JobType: jobRenameFiles, UnitID: testUnitID, ActiveUnitID: &unitID, Owner: "qa2"}
+	if err := ts.enqueueJob(context.Background(), &racer, []renameRequest{rename}); err == nil {
+		t.Fatalf("expected a second active job for the unit to be refused")
+	}
+
+	if tgtJob := ts.runQueuedJob(t, first); tgtJob.Status != JobFinished {
+		t.Fatalf("expected finished job, got %s: %s", tgtJob.Status, tgtJob.Error)
+	}
+	rename = renameRequest{Original: path.Join(unitDir, "000000012_0002.tif"), NewName: "000000012_0001.tif"}
+	expectStatus(t, ts.renameUnit(t, "", rename), http.StatusAccepted)
+}
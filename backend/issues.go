@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Validation issue severities
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// Validation rule codes. They identify the check that found an issue so the client can act on it
+const (
+	RuleContainerType      = "container-type"
+	RuleFinalizePrep       = "finalize-prep"
+	RuleMissingDirectory   = "missing-directory"
+	RuleUnexpectedFile     = "unexpected-file"
+	RuleUnexpectedNotes    = "unexpected-notes"
+	RuleInvalidFilename    = "invalid-filename"
+	RuleNoImages           = "no-images"
+	RuleSequenceMismatch   = "sequence-mismatch"
+	RuleMetadataUnreadable = "metadata-unreadable"
+	RuleMissingTitle       = "missing-title"
+	RuleMissingLocation    = "missing-location"
+	RuleIncompleteLocation = "incomplete-location"
+	RuleMissingComponent   = "missing-component"
+	RuleMoveFailed         = "move-failed"
+)
+
+// validationIssue is a problem found when a step was finished. Issues are linked to the problem note added
+// for the failure and to the assignment that failed. File is the base name of the image, if the issue is
+// for a single file. Open issues are resolved when the step is next finished successfully.
+type validationIssue struct {
+	ID           int64      `json:"id"`
+	ProjectID    uint       `json:"projectID"`
+	AssignmentID uint       `json:"assignmentID"`
+	NoteID       uint       `json:"noteID"`
+	StepID       uint       `json:"stepID"`
+	File         string     `json:"file,omitempty"`
+	Rule         string     `json:"rule"`
+	Severity     string     `json:"severity"`
+	Message      string     `json:"message"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ResolvedAt   *time.Time `json:"resolvedAt,omitempty"`
+}
+
+func newValidationIssue(rule string, file string, message string) validationIssue {
+	if file != "" {
+		file = path.Base(file)
+	}
+	return validationIssue{Rule: rule, File: file, Severity: IssueError, Message: message}
+}
+
+// saveValidationIssues records the issues for a failed step. Issues left open by an earlier attempt to
+// finish the step are resolved first, even if this failure has none, since they no longer describe the
+// latest attempt
+func (svc *serviceContext) saveValidationIssues(proj *project, currA *assignment, noteID uint, issues []validationIssue) {
+	svc.resolveValidationIssues(proj.ID)
+	if len(issues) == 0 {
+		return
+	}
+	now := time.Now()
+	for idx := range issues {
+		issues[idx].ProjectID = proj.ID
+		issues[idx].AssignmentID = currA.ID
+		issues[idx].NoteID = noteID
+		issues[idx].StepID = currA.StepID
+		issues[idx].CreatedAt = now
+		if issues[idx].Severity == "" {
+			issues[idx].Severity = IssueError
+		}
+	}
+	if err := svc.DB.CreateInBatches(issues, 100).Error; err != nil {
+		log.Printf("ERROR: unable to save %d validation issues for project %d: %s", len(issues), proj.ID, err.Error())
+	}
+}
+
+func (svc *serviceContext) resolveValidationIssues(projectID uint) {
+	resp := svc.DB.Model(&validationIssue{}).Where("project_id=? and resolved_at is null", projectID).Update("resolved_at", time.Now())
+	if resp.Error != nil {
+		log.Printf("ERROR: unable to resolve validation issues for project %d: %s", projectID, resp.Error.Error())
+	} else if resp.RowsAffected > 0 {
+		log.Printf("INFO: resolved %d validation issues for project %d", resp.RowsAffected, projectID)
+	}
+}
+
+// getValidationIssues returns the open validation issues for a project ordered by file
+func (svc *serviceContext) getValidationIssues(c *gin.Context) {
+	projID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid project")
+		return
+	}
+	out := make([]validationIssue, 0)
+	if err := svc.DB.Where("project_id=? and resolved_at is null", projID).Order("file asc, id asc").Find(&out).Error; err != nil {
+		log.Printf("ERROR: unable to get validation issues for project %d: %s", projID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
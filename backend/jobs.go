@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job status values
+const (
+	JobPending  = "pending"
+	JobRunning  = "running"
+	JobFinished = "finished"
+	JobFailed   = "failed"
+)
+
+// Job types
+const (
+	jobUpdateMetadata = "update-metadata"
+	jobRenameFiles    = "rename-files"
+	jobFinishStep     = "finish-step"
+)
+
+const (
+	jobPollInterval      = 5 * time.Second
+	jobHeartbeatInterval = 1 * time.Minute
+	jobStallTimeout      = 5 * time.Minute
+)
+
+// job is a long running unit or project operation. Jobs are persisted in the jobs table and are picked up
+// by the first available worker. The payload holds the JSON request that started the job. ActiveUnitID
+// is set to the unit for a pending or running unit job; it has a unique key so only one can be queued.
+type job struct {
+	ID           int64           `json:"id"`
+	JobType      string          `json:"type"`
+	UnitID       uint            `json:"unitID"`
+	ActiveUnitID *uint           `json:"-"`
+	ProjectID    uint            `json:"projectID"`
+	UserID       uint            `json:"userID"`
+	Owner        string          `json:"owner"`
+	Status       string          `json:"status"`
+	Phase        string          `json:"phase"`
+	Total        int             `json:"total"`
+	Processed    int             `json:"processed"`
+	Problems     []updateProblem `gorm:"serializer:json" json:"problems"`
+	Payload      string          `json:"-"`
+	Error        string          `json:"error,omitempty"`
+	RequestID    string          `json:"requestID"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	UpdatedAt    time.Time       `json:"updatedAt"`
+	StartedAt    *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt   *time.Time      `json:"finishedAt,omitempty"`
+
+	progressSavedAt time.Time
+}
+
+// logger returns a logger with the ID of the request that queued the job, so the log for the request
+// and the job can be found together. tgtJob may be nil.
+func (tgtJob *job) logger() *slog.Logger {
+	if tgtJob == nil {
+		return slog.Default()
+	}
+	return slog.Default().With("requestID", tgtJob.RequestID, "jobID", tgtJob.ID, "jobType", tgtJob.JobType,
+		"projectID", tgtJob.ProjectID, "unitID", tgtJob.UnitID, "computeID", tgtJob.Owner)
+}
+
+// context returns a context for the calls made by the job to other services
+func (tgtJob *job) context() context.Context {
+	if tgtJob == nil {
+		return context.Background()
+	}
+	return withRequestLogger(context.Background(), tgtJob.RequestID, tgtJob.logger())
+}
+
+func (svc *serviceContext) startJobWorkers(workerCnt int) {
+	slog.Info("start job workers", "workerCount", workerCnt)
+	svc.jobSignal = make(chan bool, workerCnt)
+	for w := 1; w <= workerCnt; w++ {
+		go svc.jobWorker(w)
+	}
+
+	go func() {
+		for {
+			svc.failStalledJobs()
+			time.Sleep(jobStallTimeout)
+		}
+	}()
+}
+
+func (svc *serviceContext) jobWorker(workerID int) {
+	for {
+		// pending jobs are left for the next start once the service is shutting down
+		var tgtJob *job
+		if !svc.drain.isDraining() {
+			tgtJob = svc.claimNextJob()
+		}
+		if tgtJob == nil {
+			select {
+			case <-svc.jobSignal:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+		tgtJob.logger().Info("job claimed", "workerID", workerID)
+		done, err := svc.drain.begin(fmt.Sprintf("%s job %d for unit %d by %s", tgtJob.JobType, tgtJob.ID, tgtJob.UnitID, tgtJob.Owner))
+		if err != nil {
+			tgtJob.logger().Info("return job to the queue; the service is shutting down")
+			svc.DB.Model(&job{}).Where("id=?", tgtJob.ID).Updates(map[string]any{"status": JobPending, "started_at": nil})
+			continue
+		}
+		svc.runJob(tgtJob)
+		done()
+	}
+}
+
+// claimNextJob flips the oldest pending job to running. The status check in the update ensures
+// that only one worker (in this or any other instance of the service) can claim a job
+func (svc *serviceContext) claimNextJob() *job {
+	var pending job
+	if err := svc.DB.Where("status=?", JobPending).Order("id asc").Limit(1).Find(&pending).Error; err != nil {
+		slog.Error("unable to check for pending jobs", "error", err.Error())
+		return nil
+	}
+	if pending.ID == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	resp := svc.DB.Model(&job{}).Where("id=? and status=?", pending.ID, JobPending).
+		Updates(map[string]any{"status": JobRunning, "started_at": now})
+	if resp.Error != nil {
+		slog.Error("unable to claim job", "jobID", pending.ID, "error", resp.Error.Error())
+		return nil
+	}
+	if resp.RowsAffected == 0 {
+		// another worker got it first
+		return nil
+	}
+	pending.Status = JobRunning
+	pending.StartedAt = &now
+	return &pending
+}
+
+// enqueueJob saves a new pending job with the specified payload and wakes up a worker to process it.
+// The job keeps the ID of the request in ctx
+func (svc *serviceContext) enqueueJob(ctx context.Context, newJob *job, payload any) error {
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("unable to serialize %s job payload: %s", newJob.JobType, err.Error())
+		}
+		newJob.Payload = string(b)
+	}
+	if newJob.ProjectID == 0 && newJob.UnitID > 0 {
+		var projID uint
+		svc.DB.Table("projects").Select("id").Where("unit_id=?", newJob.UnitID).Limit(1).Scan(&projID)
+		newJob.ProjectID = projID
+	}
+	newJob.Status = JobPending
+	newJob.RequestID = getRequestID(ctx)
+	newJob.Problems = make([]updateProblem, 0)
+	if err := svc.DB.Create(newJob).Error; err != nil {
+		return fmt.Errorf("unable to create %s job: %s", newJob.JobType, err.Error())
+	}
+	getLogger(ctx).Info("job queued", "jobID", newJob.ID, "jobType", newJob.JobType, "unitID", newJob.UnitID)
+
+	select {
+	case svc.jobSignal <- true:
+	default:
+		// all workers are busy; the job will be picked up on the next poll
+	}
+	return nil
+}
+
+// unitJobInProgress returns any pending or running job that will change the files in a unit
+func (svc *serviceContext) unitJobInProgress(unitID uint) *job {
+	var active job
+	svc.DB.Where("unit_id=? and status in ?", unitID, []string{JobPending, JobRunning}).
+		Order("id asc").Limit(1).Find(&active)
+	if active.ID == 0 {
+		return nil
+	}
+	return &active
+}
+
+func (svc *serviceContext) runJob(tgtJob *job) {
+	startTime := time.Now()
+	logger := tgtJob.logger()
+	logger.Info("job started")
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				svc.DB.Model(&job{}).Where("id=?", tgtJob.ID).Update("updated_at", time.Now())
+			}
+		}
+	}()
+
+	var jobErr error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				jobErr = fmt.Errorf("job crashed: %v", r)
+			}
+		}()
+		switch tgtJob.JobType {
+		case jobUpdateMetadata:
+			jobErr = svc.runUpdateMetadataJob(tgtJob)
+		case jobRenameFiles:
+			jobErr = svc.runRenameJob(tgtJob)
+		case jobUndoMetadata, jobRedoMetadata:
+			jobErr = svc.runUndoJob(tgtJob)
+		case jobFixityCheck:
+			jobErr = svc.runFixityJob(tgtJob)
+		case jobFinishStep:
+			jobErr = svc.runFinishStepJob(tgtJob)
+		default:
+			jobErr = fmt.Errorf("unsupported job type %s", tgtJob.JobType)
+		}
+	}()
+	close(done)
+
+	now := time.Now()
+	tgtJob.FinishedAt = &now
+	tgtJob.Status = JobFinished
+	if jobErr != nil {
+		logger.Error("job failed", "error", jobErr.Error())
+		tgtJob.Status = JobFailed
+		tgtJob.Error = jobErr.Error()
+	}
+	tgtJob.ActiveUnitID = nil
+	if err := svc.DB.Model(tgtJob).Select("Status", "Phase", "Error", "Problems", "Processed", "Total", "FinishedAt", "ActiveUnitID").Updates(tgtJob).Error; err != nil {
+		logger.Error("unable to save job results", "error", err.Error())
+	}
+	svc.jobEvents.publish(jobEvent{Type: JobEventDone, JobID: tgtJob.ID, Status: tgtJob.Status, Error: tgtJob.Error,
+		Processed: tgtJob.Processed, Total: tgtJob.Total})
+	logger.Info("job complete", "status", tgtJob.Status, "elapsedMS", time.Since(startTime).Milliseconds())
+}
+
+// failStalledJobs fails any running job that has not had a heartbeat recently. This happens when the
+// service is stopped in the middle of a job. Finish jobs also flag the project step as failed so
+// the assignment is not left in the working state.
+func (svc *serviceContext) failStalledJobs() {
+	var stalled []job
+	cutoff := time.Now().Add(-jobStallTimeout)
+	if err := svc.DB.Where("status=? and updated_at < ?", JobRunning, cutoff).Find(&stalled).Error; err != nil {
+		slog.Error("unable to check for stalled jobs", "error", err.Error())
+		return
+	}
+
+	for _, sj := range stalled {
+		sj.logger().Warn("job has stalled")
+		now := time.Now()
+		resp := svc.DB.Model(&job{}).Where("id=? and status=?", sj.ID, JobRunning).
+			Updates(map[string]any{"status": JobFailed, "error": "job was interrupted before it completed", "finished_at": now, "active_unit_id": nil})
+		if resp.Error != nil || resp.RowsAffected == 0 {
+			continue
+		}
+
+		if sj.JobType == jobFinishStep {
+			var proj project
+			if err := svc.DB.Preload("CurrentStep").First(&proj, sj.ProjectID).Error; err != nil {
+				sj.logger().Error("unable to load project for stalled finish job", "error", err.Error())
+				continue
+			}
+			svc.failStep(sj.context(), &proj, "Other", "<p>Finish processing was interrupted before it completed. Please finish the step again.</p>")
+		}
+	}
+}
+
+func (svc *serviceContext) getJob(c *gin.Context) {
+	jobID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var tgtJob job
+	if err := svc.DB.Where("id=?", jobID).Limit(1).Find(&tgtJob).Error; err != nil {
+		getLogger(c.Request.Context()).Error("unable to get job", "jobID", jobID, "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tgtJob.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("job %d not found", jobID))
+		return
+	}
+	c.JSON(http.StatusOK, tgtJob)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Unit locks are leases held in the unit_locks table. A lease is kept alive by a heartbeat
+// for as long as the owning request is running. If the process dies, the heartbeat stops and
+// the lease expires on its own so the unit is not locked forever. If the heartbeat finds that the
+// lease was released by an admin or expired, the holder is told so it can stop changing the unit.
+const (
+	lockLeaseDuration     = 2 * time.Minute
+	lockHeartbeatInterval = 30 * time.Second
+	lockReapInterval      = 1 * time.Minute
+)
+
+type unitLock struct {
+	ID          int64     `json:"id"`
+	UnitID      uint      `json:"unitID"`
+	Owner       string    `json:"owner"`
+	Operation   string    `json:"operation"` // rename, delete, rotate, update
+	StartedAt   time.Time `json:"startedAt"`
+	HeartbeatAt time.Time `json:"heartbeatAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	stop        chan bool
+	lost        chan bool
+	done        func()
+	logger      *slog.Logger
+}
+
+// leaseLost returns an error once the heartbeat has found that the lease is no longer held. Another
+// change to the unit may have started since, so the holder must stop changing the unit files
+func (lock *unitLock) leaseLost() error {
+	if lock == nil {
+		return nil
+	}
+	select {
+	case <-lock.lost:
+		return fmt.Errorf("the lock on unit %d was released or expired before the %s finished", lock.UnitID, lock.Operation)
+	default:
+		return nil
+	}
+}
+
+// acquireUnitLock will create a lease on the target unit for the specified operation. If the unit is
+// already locked, a conflict error that identifies the current lock holder is returned. The lease is
+// logged with the logger of the request or job in ctx.
+func (svc *serviceContext) acquireUnitLock(ctx context.Context, rawUnitID string, owner string, operation string) (*unitLock, *RequestError) {
+	unitID, err := strconv.ParseUint(rawUnitID, 10, 64)
+	if err != nil {
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("%s is not a valid unit", rawUnitID)}
+	}
+
+	svc.expireStaleLocks(ctx)
+
+	now := time.Now()
+	lock := unitLock{UnitID: uint(unitID), Owner: owner, Operation: operation,
+		StartedAt: now, HeartbeatAt: now, ExpiresAt: now.Add(lockLeaseDuration)}
+	if err := svc.DB.Create(&lock).Error; err != nil {
+		var existing unitLock
+		if svc.DB.Where("unit_id=?", unitID).First(&existing).Error == nil {
+			msg := fmt.Sprintf("this unit is currently being processed by another user (%s is running %s)", existing.Owner, existing.Operation)
+			return nil, &RequestError{StatusCode: http.StatusConflict, Message: msg}
+		}
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: fmt.Sprintf("unable to lock unit %d: %s", unitID, err.Error())}
+	}
+	lock.logger = getLogger(ctx).With("lockID", lock.ID, "lockOperation", operation)
+	lock.logger.Info("unit lock acquired", "owner", owner)
+
+	lock.stop = make(chan bool)
+	lock.lost = make(chan bool)
+	lock.done = svc.drain.track(fmt.Sprintf("%s of unit %d by %s", operation, lock.UnitID, owner))
+	go svc.lockHeartbeat(&lock)
+	return &lock, nil
+}
+
+func (svc *serviceContext) lockHeartbeat(lock *unitLock) {
+	ticker := time.NewTicker(lockHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			if !svc.renewLease(lock) {
+				return
+			}
+		}
+	}
+}
+
+// renewLease extends the lease of the lock. If the lease is no longer held, the holder is told through
+// lost and false is returned
+func (svc *serviceContext) renewLease(lock *unitLock) bool {
+	now := time.Now()
+	resp := svc.DB.Model(&unitLock{}).Where("id=?", lock.ID).
+		Updates(map[string]any{"heartbeat_at": now, "expires_at": now.Add(lockLeaseDuration)})
+	if resp.Error != nil {
+		lock.logger.Error("unit lock heartbeat failed", "error", resp.Error.Error())
+	} else if resp.RowsAffected == 0 {
+		lock.logger.Warn("unit lock is no longer held; it was released or expired")
+		close(lock.lost)
+		return false
+	}
+	return true
+}
+
+// releaseUnitLock stops the heartbeat and removes the lease. It is safe to call on a lock that has
+// already been force-released by an admin
+func (svc *serviceContext) releaseUnitLock(lock *unitLock) {
+	if lock == nil {
+		return
+	}
+	close(lock.stop)
+	lock.done()
+	if err := svc.DB.Where("id=?", lock.ID).Delete(&unitLock{}).Error; err != nil {
+		lock.logger.Error("unable to release unit lock", "error", err.Error())
+		return
+	}
+	lock.logger.Info("unit lock released")
+}
+
+// unitIsLocked reports if the unit has an active lock
+func (svc *serviceContext) unitIsLocked(unitID uint) bool {
+	var lockCnt int64
+	svc.DB.Model(&unitLock{}).Where("unit_id=? and expires_at >= ?", unitID, time.Now()).Count(&lockCnt)
+	return lockCnt > 0
+}
+
+func (svc *serviceContext) expireStaleLocks(ctx context.Context) {
+	resp := svc.DB.Where("expires_at < ?", time.Now()).Delete(&unitLock{})
+	if resp.Error != nil {
+		getLogger(ctx).Error("unable to expire stale unit locks", "error", resp.Error.Error())
+	} else if resp.RowsAffected > 0 {
+		getLogger(ctx).Info("expired stale unit locks", "count", resp.RowsAffected)
+	}
+}
+
+func (svc *serviceContext) startLockReaper() {
+	slog.Info("start stale unit lock reaper")
+	go func() {
+		for {
+			time.Sleep(lockReapInterval)
+			svc.expireStaleLocks(context.Background())
+		}
+	}()
+}
+
+func (svc *serviceContext) getUnitLocks(c *gin.Context) {
+	logger := getLogger(c.Request.Context())
+	logger.Info("get active unit locks")
+	locks := make([]unitLock, 0)
+	if err := svc.DB.Where("expires_at >= ?", time.Now()).Order("started_at asc").Find(&locks).Error; err != nil {
+		logger.Error("unable to get unit locks", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, locks)
+}
+
+// forceReleaseUnitLock removes the lease on a unit. The operation that holds it is not stopped right
+// away; it stops when its next heartbeat finds the lease gone, so it may keep changing the unit for up
+// to lockHeartbeatInterval
+func (svc *serviceContext) forceReleaseUnitLock(c *gin.Context) {
+	unitID := c.Param("uid")
+	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
+	logger.Info("force release of unit lock requested")
+	if claims.Role != "admin" {
+		c.String(http.StatusForbidden, "only admins can release unit locks")
+		return
+	}
+
+	var lock unitLock
+	if err := svc.DB.Where("unit_id=?", unitID).First(&lock).Error; err != nil {
+		logger.Info("unit has no lock to release")
+		c.String(http.StatusNotFound, fmt.Sprintf("unit %s is not locked", unitID))
+		return
+	}
+
+	if err := svc.DB.Where("id=?", lock.ID).Delete(&unitLock{}).Error; err != nil {
+		logger.Error("unable to force release unit lock", "lockID", lock.ID, "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.Info("unit lock force released", "lockID", lock.ID, "owner", lock.Owner, "lockOperation", lock.Operation,
+		"startedAt", lock.StartedAt.Format(time.RFC3339))
+	c.String(http.StatusOK, fmt.Sprintf("released; the %s by %s will stop within %s", lock.Operation, lock.Owner, lockHeartbeatInterval))
+}
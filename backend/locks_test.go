@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"testing"
+)
+
+// lostLock returns a lock whose heartbeat has found the lease gone
+func lostLock(operation string) *unitLock {
+	lock := unitLock{UnitID: testUnitID, Owner: scanner.ComputeID, Operation: operation, lost: make(chan bool)}
+	close(lock.lost)
+	return &lock
+}
+
+func TestLeaseLost(t *testing.T) {
+	ts := newWorkflowTestService(t)
+	lock, lockErr := ts.acquireUnitLock(context.Background(), "12", scanner.ComputeID, "update")
+	if lockErr != nil {
+		t.Fatalf("unable to lock unit: %s", lockErr.Message)
+	}
+	defer ts.releaseUnitLock(lock)
+	if !ts.renewLease(lock) || lock.leaseLost() != nil {
+		t.Fatalf("expected the lease to be renewed")
+	}
+
+	admin := jwtClaims{UserID: 4, ComputeID: "super4", Role: "admin"}
+	c, resp := newTestRequest(http.MethodDelete, "/api/units/12/lock", nil, admin, "uid", "12")
+	ts.forceReleaseUnitLock(c)
+	expectStatus(t, resp, http.StatusOK)
+
+	if ts.renewLease(lock) {
+		t.Errorf("expected the lease to be lost once it was released")
+	}
+	if lock.leaseLost() == nil {
+		t.Errorf("expected the holder to be told the lease was lost")
+	}
+}
+
+func TestLostLockStopsChanges(t *testing.T) {
+	t.Run("rename is rolled back", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+		journal, problems := ts.planRename(testUnitID, []renameRequest{
+			{Original: path.Join(unitDir, "000000012_0001.tif"), NewName: "000000012_0003.tif"},
+			{Original: path.Join(unitDir, "000000012_0002.tif"), NewName: "000000012_0004.tif"},
+		})
+		if len(problems) > 0 {
+			t.Fatalf("unexpected rename problems %+v", problems)
+		}
+		if err := ts.Files.mkdir(journal.WorkDir); err != nil {
+			t.Fatalf("unable to create working directory: %s", err.Error())
+		}
+		if err := ts.DB.Create(journal).Error; err != nil {
+			t.Fatalf("unable to create rename journal: %s", err.Error())
+		}
+
+		if err := ts.applyRename(journal, nil, lostLock("rename")); err == nil {
+			t.Fatalf("expected the rename to fail")
+		}
+		if len(ts.files.renames) > 0 {
+			t.Errorf("expected no files to be renamed, got %v", ts.files.renames)
+		}
+		expectFileContent(t, path.Join(unitDir, "000000012_0001.tif"), "000000012_0001.tif")
+		expectFileContent(t, path.Join(unitDir, "000000012_0002.tif"), "000000012_0002.tif")
+		if status := ts.renameJournalStatus(t); status != RenameRolledBack {
+			t.Errorf("expected rolled back rename journal, got %s", status)
+		}
+	})
+
+	t.Run("metadata is not updated", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif", "000000012_0003.tif")
+		commands := make([]exifFileCommands, 0)
+		for _, name := range []string{"000000012_0001.tif", "000000012_0002.tif", "000000012_0003.tif"} {
+			tgtFile := path.Join(unitDir, name)
+			commands = append(commands, exifFileCommands{File: tgtFile, Commands: []string{"-iptc:headline=Cover", tgtFile}})
+		}
+		tgtJob := job{UnitID: testUnitID}
+
+		failed := ts.runMetadataCommands(&tgtJob, lostLock("update"), commands)
+		if len(failed) != 3 || len(tgtJob.Problems) != 3 {
+			t.Errorf("expected 3 files that were not updated, got %v with problems %+v", failed, tgtJob.Problems)
+		}
+		if len(ts.exifTool.commands) > 0 {
+			t.Errorf("expected no exiftool commands, got %v", ts.exifTool.commands)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the ID of a request. An ID sent by the caller is used as-is, otherwise one is
+// generated. It is returned in the response and sent along on calls to TrackSys and dpg-jobs
+const requestIDHeader = "X-Request-ID"
+
+type logContextKey int
+
+const (
+	loggerKey logContextKey = iota
+	requestIDKey
+)
+
+var (
+	bearerPattern = regexp.MustCompile(`(?i)bearer\s+\S+`)
+	jwtPattern    = regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]*`)
+)
+
+var logLevelPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"INFO:", slog.LevelInfo},
+	{"WARNING:", slog.LevelWarn},
+	{"ERROR:", slog.LevelError},
+}
+
+// initLogging sends all logging, including the log.Printf calls, to stderr as JSON
+func initLogging() {
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{ReplaceAttr: redactLogAttr})
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	log.SetOutput(logBridge{handler: handler})
+}
+
+// redactLogAttr hides bearer tokens and JWTs that end up in a log message or attribute
+func redactLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(redactTokens(a.Value.String()))
+	}
+	return a
+}
+
+func redactTokens(msg string) string {
+	msg = bearerPattern.ReplaceAllString(msg, "Bearer REDACTED")
+	return jwtPattern.ReplaceAllString(msg, "REDACTED")
+}
+
+// logBridge turns log.Printf output into slog records. The INFO:, WARNING: and ERROR: prefixes
+// used by the log.Printf calls become the level of the record
+type logBridge struct {
+	handler slog.Handler
+}
+
+func (lb logBridge) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	for _, lp := range logLevelPrefixes {
+		if strings.HasPrefix(msg, lp.prefix) {
+			level = lp.level
+			msg = strings.TrimSpace(strings.TrimPrefix(msg, lp.prefix))
+			break
+		}
+	}
+	rec := slog.NewRecord(time.Now(), level, msg, 0)
+	if err := lb.handler.Handle(context.Background(), rec); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestLogger returns a context that carries the request ID and a logger for the request
+func withRequestLogger(ctx context.Context, requestID string, logger *slog.Logger) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, requestID)
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// getLogger returns the logger for the request or job in the context
+func getLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func getRequestID(ctx context.Context) string {
+	if reqID, ok := ctx.Value(requestIDKey).(string); ok {
+		return reqID
+	}
+	return ""
+}
+
+// addLogAttrs adds attributes to the logger for the rest of the request
+func addLogAttrs(c *gin.Context, args ...any) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(withRequestLogger(ctx, getRequestID(ctx), getLogger(ctx).With(args...)))
+}
+
+// requestLogger assigns the request ID and sets up the logger used by the handlers. Requests for
+// a project or unit include the ID in the logger so all lines for that project or unit can be found.
+// The user computeID is added once the request has been authorized.
+func requestLogger(c *gin.Context) {
+	reqID := c.GetHeader(requestIDHeader)
+	if reqID == "" || len(reqID) > 64 {
+		reqID = newRequestID()
+	}
+	c.Header(requestIDHeader, reqID)
+
+	logger := slog.Default().With("requestID", reqID)
+	route := c.FullPath()
+	if strings.HasPrefix(route, "/api/projects/:id") {
+		logger = logger.With("projectID", c.Param("id"))
+	} else if strings.HasPrefix(route, "/api/units/:uid") {
+		logger = logger.With("unitID", c.Param("uid"))
+	}
+	c.Request = c.Request.WithContext(withRequestLogger(c.Request.Context(), reqID, logger))
+
+	startTime := time.Now()
+	c.Next()
+
+	// the handlers may have added attributes; use the final logger for the summary
+	logger = getLogger(c.Request.Context())
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+	logger.Log(c.Request.Context(), level, "request complete", "method", c.Request.Method, "path", c.Request.URL.Path,
+		"status", c.Writer.Status(), "elapsedMS", time.Since(startTime).Milliseconds())
+}
@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/contrib/static"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Version of the service
 const Version = "6.1.1"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "check" {
+		checkConfiguration(os.Args[3:])
+		return
+	}
+
 	// Load cfg
+	initLogging()
 	log.Printf("===> DPG Imaging Service is starting up <===")
 	cfg := getConfiguration()
 	svc := initializeService(Version, cfg)
@@ -22,7 +40,8 @@ func main() {
 	// Set routes and start server
 	gin.SetMode(gin.ReleaseMode)
 	gin.DisableConsoleColor()
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), requestLogger, httpMetrics, svc.drainGuard)
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
 	corsCfg := cors.DefaultConfig()
 	corsCfg.AllowAllOrigins = true
@@ -34,6 +53,7 @@ func main() {
 	router.GET("/config", svc.getConfig)
 	router.GET("/version", svc.getVersion)
 	router.GET("/healthcheck", svc.healthCheck)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/authenticate", svc.authenticate)
 
 	// external API used by TrackSys / dpg-jobs
@@ -65,6 +85,9 @@ func main() {
 		api.DELETE("/projects/:id", svc.deleteProject)
 		api.PUT("/projects/:id/images/count", svc.updateProjecImageCount)
 		api.GET("/projects/:id/status", svc.getProjectStatus)
+		api.GET("/projects/:id/files/unexpected", svc.getUnexpectedFiles)
+		api.GET("/projects/:id/preflight", svc.getProjectPreflight)
+		api.GET("/projects/:id/issues", svc.getValidationIssues)
 		api.POST("/projects/:id/assign/:uid", svc.assignProject)
 		api.POST("/projects/:id/equipment", svc.setProjectEquipment)
 		api.POST("/projects/:id/note", svc.addNoteRequest)
@@ -75,11 +98,26 @@ func main() {
 		api.GET("/units/:uid/validate/components", svc.validateComponentSettings)
 		api.GET("/units/:uid/masterfiles", svc.getUnitMasterFiles)
 		api.GET("/units/:uid/masterfiles/metadata", svc.getMasterFilesMetadata)
-		api.POST("/units/:uid/update", svc.updateMetadataBatch)       // this is protected by BatchUnitsInProgress
-		api.POST("/units/:uid/rename", svc.renameFiles)               // this is protected by BatchUnitsInProgress
-		api.POST("/units/:uid/delete", svc.deleteFiles)               // this is protected by BatchUnitsInProgress
-		api.POST("/units/:uid/:file/rotate", svc.rotateFile)          // this is protected by BatchUnitsInProgress
-		api.POST("/units/:uid/:file/update", svc.updateImageMetadata) // this is protected by BatchUnitsInProgress
+		api.POST("/units/:uid/update", svc.updateMetadataBatch)       // this is protected by a unit lock
+		api.POST("/units/:uid/undo", svc.undoMetadata)                // this is protected by a unit lock
+		api.POST("/units/:uid/redo", svc.redoMetadata)                // this is protected by a unit lock
+		api.POST("/units/:uid/rename", svc.renameFiles)               // this is protected by a unit lock
+		api.POST("/units/:uid/delete", svc.deleteFiles)               // this is protected by a unit lock
+		api.POST("/units/:uid/:file/rotate", svc.rotateFile)          // this is protected by a unit lock
+		api.POST("/units/:uid/:file/update", svc.updateImageMetadata) // this is protected by a unit lock
+
+		api.GET("/units/:uid/history", svc.getUnitHistory)
+		api.POST("/units/:uid/fixity", svc.checkFixity)
+		api.GET("/units/:uid/trash", svc.getTrashedFiles)
+		api.POST("/units/:uid/trash/:id/restore", svc.restoreTrashedFile) // this is protected by a unit lock
+
+		api.GET("/jobs/:id", svc.getJob)
+		api.GET("/jobs/:id/events", svc.streamJobEvents)
+
+		api.GET("/locks", svc.getUnitLocks)
+		api.POST("/locks/:uid/release", svc.forceReleaseUnitLock)
+
+		api.GET("/metadata/cache", svc.getMetadataCacheStats)
 
 		api.GET("/user/:id/messages", svc.getMessages)
 		api.POST("/user/:id/messages/:msgid/delete", svc.deleteMessage)
@@ -96,6 +134,7 @@ func main() {
 	{
 		cleanup.POST("/finished-projects", svc.cleanupOldProjects)
 		cleanup.POST("/deleted-messages", svc.cleanupDeletedMessages)
+		cleanup.POST("/trash", svc.cleanupTrash)
 	}
 
 	// Note: in dev mode, this is never actually used. The front end is served
@@ -111,7 +150,18 @@ func main() {
 
 	portStr := fmt.Sprintf(":%d", cfg.port)
 	log.Printf("INFO: start DPG Imaging Service on port %s with CORS support enabled", portStr)
-	log.Fatal(router.Run(portStr))
+	srv := &http.Server{Addr: portStr, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait for a deploy or ctrl-c, then let in-flight unit operations finish before exiting
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-sigCtx.Done()
+	stop()
+	svc.shutdown(srv, time.Duration(cfg.drainTimeout)*time.Second)
 }
 
 // log.Printf("HACK THE PROJECT UPDATES =============================")
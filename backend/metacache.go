@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const metadataCacheSaveInterval = 5 * time.Minute
+
+// metadataCache holds masterFileMetadata keyed by the full path of the master file. Each entry also
+// records the size and modification time of the file when it was read. An entry is only used if the
+// file still has that size and time, so changes made outside of this service are picked up. Changes
+// made by this service invalidate the affected entries directly.
+type metadataCache struct {
+	mutex         sync.RWMutex
+	entries       map[string]metadataCacheEntry
+	maxEntries    int
+	cacheFile     string
+	dirty         bool
+	hits          int64
+	misses        int64
+	stale         int64
+	invalidations int64
+}
+
+type metadataCacheEntry struct {
+	Size     int64              `json:"size"`
+	ModTime  time.Time          `json:"modTime"`
+	Metadata masterFileMetadata `json:"metadata"`
+}
+
+type metadataCacheStats struct {
+	Entries       int     `json:"entries"`
+	MaxEntries    int     `json:"maxEntries"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Stale         int64   `json:"stale"`
+	Invalidations int64   `json:"invalidations"`
+	HitRate       float64 `json:"hitRate"`
+	CacheFile     string  `json:"cacheFile,omitempty"`
+}
+
+// newMetadataCache creates the cache. If cacheFile is set, the cache is loaded from that file and
+// saved back to it periodically so it survives restarts.
+func newMetadataCache(maxEntries int, cacheFile string) *metadataCache {
+	mc := metadataCache{entries: make(map[string]metadataCacheEntry), maxEntries: maxEntries, cacheFile: cacheFile}
+	if cacheFile == "" {
+		log.Printf("INFO: metadata cache is in memory only with a max of %d entries", maxEntries)
+		return &mc
+	}
+
+	log.Printf("INFO: load metadata cache from %s", cacheFile)
+	if data, err := os.ReadFile(cacheFile); err != nil {
+		log.Printf("INFO: no metadata cache loaded: %s", err.Error())
+	} else if err := json.Unmarshal(data, &mc.entries); err != nil {
+		log.Printf("WARNING: unable to parse metadata cache %s; starting with an empty cache: %s", cacheFile, err.Error())
+		mc.entries = make(map[string]metadataCacheEntry)
+	} else {
+		log.Printf("INFO: loaded %d entries into the metadata cache", len(mc.entries))
+	}
+
+	go func() {
+		for {
+			time.Sleep(metadataCacheSaveInterval)
+			mc.save()
+		}
+	}()
+	return &mc
+}
+
+// get returns the cached metadata for a file if the file has not changed since it was cached
+func (mc *metadataCache) get(fullPath string) (*masterFileMetadata, bool) {
+	info, statErr := os.Stat(fullPath)
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	entry, found := mc.entries[fullPath]
+	if !found {
+		mc.misses++
+		return nil, false
+	}
+	if statErr != nil || info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+		mc.stale++
+		mc.misses++
+		delete(mc.entries, fullPath)
+		mc.dirty = true
+		return nil, false
+	}
+	mc.hits++
+	md := entry.Metadata
+	return &md, true
+}
+
+// put caches metadata with the file info from a stat made before the metadata was read. If the file has
+// changed since then, the metadata may be from before the change, so it is not cached
+func (mc *metadataCache) put(fullPath string, readInfo os.FileInfo, md masterFileMetadata) {
+	if readInfo == nil {
+		return
+	}
+	info, err := os.Stat(fullPath)
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if err != nil || info.Size() != readInfo.Size() || !info.ModTime().Equal(readInfo.ModTime()) {
+		if _, found := mc.entries[fullPath]; found {
+			delete(mc.entries, fullPath)
+			mc.dirty = true
+		}
+		return
+	}
+	if _, found := mc.entries[fullPath]; !found && len(mc.entries) >= mc.maxEntries {
+		// make room by dropping an arbitrary entry
+		for key := range mc.entries {
+			delete(mc.entries, key)
+			break
+		}
+	}
+	mc.entries[fullPath] = metadataCacheEntry{Size: readInfo.Size(), ModTime: readInfo.ModTime(), Metadata: md}
+	mc.dirty = true
+}
+
+// invalidate removes the entries for files that have been changed by this service
+func (mc *metadataCache) invalidate(fullPaths ...string) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	for _, fullPath := range fullPaths {
+		if _, found := mc.entries[fullPath]; found {
+			delete(mc.entries, fullPath)
+			mc.invalidations++
+			mc.dirty = true
+		}
+	}
+}
+
+func (mc *metadataCache) save() {
+	mc.mutex.Lock()
+	if mc.cacheFile == "" || !mc.dirty {
+		mc.mutex.Unlock()
+		return
+	}
+	data, err := json.Marshal(mc.entries)
+	mc.dirty = false
+	cnt := len(mc.entries)
+	mc.mutex.Unlock()
+
+	if err != nil {
+		log.Printf("ERROR: unable to serialize metadata cache: %s", err.Error())
+		return
+	}
+	tmpFile := mc.cacheFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		log.Printf("ERROR: unable to write metadata cache %s: %s", tmpFile, err.Error())
+		return
+	}
+	if err := os.Rename(tmpFile, mc.cacheFile); err != nil {
+		log.Printf("ERROR: unable to replace metadata cache %s: %s", mc.cacheFile, err.Error())
+		return
+	}
+	log.Printf("INFO: saved %d metadata cache entries to %s", cnt, mc.cacheFile)
+}
+
+func (mc *metadataCache) stats() metadataCacheStats {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+	out := metadataCacheStats{Entries: len(mc.entries), MaxEntries: mc.maxEntries, Hits: mc.hits, Misses: mc.misses,
+		Stale: mc.stale, Invalidations: mc.invalidations, CacheFile: mc.cacheFile}
+	if mc.hits+mc.misses > 0 {
+		out.HitRate = float64(mc.hits) / float64(mc.hits+mc.misses)
+	}
+	return out
+}
+
+func (svc *serviceContext) getMetadataCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, svc.MetadataCache.stats())
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "dpg_imaging"
+
+// file operations run from a fraction of a second for small units to many minutes for large ones
+var longRunningBuckets = []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600, 1200}
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to handle API requests by route",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	externalRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "external_request_duration_seconds",
+		Help:      "Time for each request attempt to TrackSys and dpg-jobs by endpoint",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method", "endpoint", "status"})
+
+	exifToolDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "exiftool_call_duration_seconds",
+		Help:      "Time for a single exiftool call once a process is available",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"outcome"})
+
+	exifToolWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "exiftool_wait_duration_seconds",
+		Help:      "Time exiftool calls wait for an available process",
+		Buckets:   []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 120},
+	})
+
+	dirWalkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "directory_walk_duration_seconds",
+		Help:      "Time to walk a unit directory by operation",
+		Buckets:   longRunningBuckets,
+	}, []string{"operation"})
+
+	fileCopyDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_copy_duration_seconds",
+		Help:      "Time to copy a unit directory when files are moved between steps",
+		Buckets:   longRunningBuckets,
+	})
+
+	fileCopyThroughput = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_copy_throughput_bytes_per_second",
+		Help:      "Throughput of unit directory copies",
+		Buckets:   prometheus.ExponentialBuckets(1024*1024, 2, 12),
+	})
+
+	fileCopyBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_copy_bytes_total",
+		Help:      "Total bytes copied when files are moved between steps",
+	})
+)
+
+// stepStatusNames are the metric labels for the assignment status values
+var stepStatusNames = map[int]string{
+	StepPending:    "pending",
+	StepStarted:    "started",
+	StepFinished:   "finished",
+	StepRejected:   "rejected",
+	StepError:      "error",
+	StepReassigned: "reassigned",
+	StepFinalizing: "finalizing",
+	StepWorking:    "working",
+}
+
+var idSegmentPattern = regexp.MustCompile(`/\d+(/|$)`)
+
+// metricsEndpoint converts a request path to an endpoint label by dropping the query and replacing IDs,
+// so /units/123?x=1 becomes /units/:id
+func metricsEndpoint(reqPath string) string {
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	return idSegmentPattern.ReplaceAllString(reqPath, "/:id$1")
+}
+
+func observeExternalRequest(service string, method string, reqPath string, status int, startTime time.Time) {
+	externalRequestDuration.WithLabelValues(service, method, metricsEndpoint(reqPath), strconv.Itoa(status)).
+		Observe(time.Since(startTime).Seconds())
+}
+
+func observeDirWalk(operation string, startTime time.Time) {
+	dirWalkDuration.WithLabelValues(operation).Observe(time.Since(startTime).Seconds())
+}
+
+func observeFileCopy(bytes int64, elapsed time.Duration) {
+	fileCopyDuration.Observe(elapsed.Seconds())
+	fileCopyBytes.Add(float64(bytes))
+	if elapsed > 0 {
+		fileCopyThroughput.Observe(float64(bytes) / elapsed.Seconds())
+	}
+}
+
+// dirSize returns the total size of the files in a directory tree
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// httpMetrics records the time taken by each request. Routes are labeled by their pattern, not the request path
+func httpMetrics(c *gin.Context) {
+	startTime := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+		Observe(time.Since(startTime).Seconds())
+}
+
+// dbStatsCollector reports gauges for the current state of the work tracked in the DB. The values are
+// read at scrape time so they are correct for every instance of the service
+type dbStatsCollector struct {
+	svc            *serviceContext
+	unitsLocked    *prometheus.Desc
+	jobsInFlight   *prometheus.Desc
+	activeProjects *prometheus.Desc
+}
+
+func newDBStatsCollector(svc *serviceContext) *dbStatsCollector {
+	return &dbStatsCollector{svc: svc,
+		unitsLocked: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "units_locked"),
+			"Number of units with an active lock", nil, nil),
+		jobsInFlight: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "jobs_in_flight"),
+			"Number of pending and running background jobs", []string{"type", "status"}, nil),
+		activeProjects: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "projects"),
+			"Number of unfinished projects by workflow, current step and assignment status", []string{"workflow", "step", "status"}, nil),
+	}
+}
+
+func (dc *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dc.unitsLocked
+	ch <- dc.jobsInFlight
+	ch <- dc.activeProjects
+}
+
+func (dc *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	var lockCnt int64
+	if err := dc.svc.DB.Model(&unitLock{}).Where("expires_at >= ?", time.Now()).Count(&lockCnt).Error; err != nil {
+		log.Printf("ERROR: unable to count unit locks for metrics: %s", err.Error())
+	} else {
+		ch <- prometheus.MustNewConstMetric(dc.unitsLocked, prometheus.GaugeValue, float64(lockCnt))
+	}
+
+	var jobCounts []struct {
+		JobType string
+		Status  string
+		Total   int64
+	}
+	err := dc.svc.DB.Model(&job{}).Select("job_type, status, count(*) as total").
+		Where("status in ?", []string{JobPending, JobRunning}).Group("job_type, status").Scan(&jobCounts).Error
+	if err != nil {
+		log.Printf("ERROR: unable to count jobs for metrics: %s", err.Error())
+	} else {
+		for _, jc := range jobCounts {
+			ch <- prometheus.MustNewConstMetric(dc.jobsInFlight, prometheus.GaugeValue, float64(jc.Total), jc.JobType, jc.Status)
+		}
+	}
+
+	// the status of a project is the status of its most recent assignment
+	var projCounts []struct {
+		Workflow string
+		Step     string
+		Status   *int
+		Total    int64
+	}
+	projQ := "select w.name as workflow, s.name as step, a.status as status, count(*) as total from projects p"
+	projQ += " inner join workflows w on w.id = p.workflow_id inner join steps s on s.id = p.current_step_id"
+	projQ += " left join assignments a on a.id = (select a2.id from assignments a2 where a2.project_id = p.id order by a2.assigned_at desc limit 1)"
+	projQ += " where p.finished_at is null group by w.name, s.name, a.status"
+	if err := dc.svc.DB.Raw(projQ).Scan(&projCounts).Error; err != nil {
+		log.Printf("ERROR: unable to count projects for metrics: %s", err.Error())
+		return
+	}
+	for _, pc := range projCounts {
+		status := "unassigned"
+		if pc.Status != nil {
+			status = strconv.Itoa(*pc.Status)
+			if name, found := stepStatusNames[*pc.Status]; found {
+				status = name
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(dc.activeProjects, prometheus.GaugeValue, float64(pc.Total), pc.Workflow, pc.Step, status)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed db/migrations/*.sql
+var migrationFiles embed.FS
+
+type migrateLogger struct{}
+
+func (ml migrateLogger) Printf(format string, v ...any) {
+	log.Printf("INFO: migrate "+format, v...)
+}
+
+func (ml migrateLogger) Verbose() bool {
+	return false
+}
+
+type schemaStatus struct {
+	Version uint `json:"version"`
+	Latest  uint `json:"latest"`
+	Dirty   bool `json:"dirty"`
+}
+
+func newMigrator(db dbConfig, migrationsTable string) (*migrate.Migrate, error) {
+	src, err := iofs.New(migrationFiles, "db/migrations")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read bundled migrations: %s", err.Error())
+	}
+	// migrations can contain several statements so they need a connection that allows it
+	dbURL := fmt.Sprintf("mysql://%s:%s@tcp(%s:%d)/%s?multiStatements=true&x-migrations-table=%s",
+		db.User, db.Pass, db.Host, db.Port, db.Name, migrationsTable)
+	m, err := migrate.NewWithSourceInstance("iofs", src, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database for migrations: %s", err.Error())
+	}
+	m.Log = migrateLogger{}
+	return m, nil
+}
+
+// runMigrations applies any pending migrations. It is called at startup before the service uses the DB
+func runMigrations(db dbConfig, migrationsTable string) error {
+	m, err := newMigrator(db, migrationsTable)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	startVer, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("unable to get schema version: %s", err.Error())
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty; a failed migration must be fixed and forced with the migrate tool", startVer)
+	}
+	if errors.Is(err, migrate.ErrNilVersion) {
+		if startVer, err = baselineSchema(m, db); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("INFO: schema is at version %d; apply any pending migrations", startVer)
+	if err := m.Up(); err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			log.Printf("INFO: schema is up to date")
+			return nil
+		}
+		return fmt.Errorf("migration failed: %s", err.Error())
+	}
+	endVer, _, _ := m.Version()
+	log.Printf("INFO: schema migrated from version %d to %d", startVer, endVer)
+	return nil
+}
+
+// baselineSchema records version 1 for a schema that was built by hand before the migrations were
+// tracked, so the initial schema migration is not run against tables that already exist. It returns
+// the schema version after the check
+func baselineSchema(m *migrate.Migrate, db dbConfig) (uint, error) {
+	conn, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", db.User, db.Pass, db.Host, db.Port, db.Name))
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to database to check the schema: %s", err.Error())
+	}
+	defer conn.Close()
+
+	var tables int
+	err = conn.QueryRow("select count(*) from information_schema.tables where table_schema=? and table_name=?", db.Name, "projects").Scan(&tables)
+	if err != nil {
+		return 0, fmt.Errorf("unable to check for an existing schema: %s", err.Error())
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+
+	log.Printf("INFO: schema has no recorded version but the projects table exists; record it as version 1")
+	if err := m.Force(1); err != nil {
+		return 0, fmt.Errorf("unable to record the existing schema as version 1: %s", err.Error())
+	}
+	return 1, nil
+}
+
+// latestMigration returns the version of the newest bundled migration
+func latestMigration() uint {
+	var latest uint
+	files, _ := fs.Glob(migrationFiles, "db/migrations/*.up.sql")
+	for _, f := range files {
+		var ver uint
+		if _, err := fmt.Sscanf(f, "db/migrations/%d_", &ver); err == nil && ver > latest {
+			latest = ver
+		}
+	}
+	return latest
+}
+
+// getSchemaStatus returns the version of the schema recorded by the migrations
+func (svc *serviceContext) getSchemaStatus() (*schemaStatus, error) {
+	var rec struct {
+		Version uint
+		Dirty   bool
+	}
+	if err := svc.DB.Table(svc.MigrationsTable).Select("version", "dirty").Limit(1).Scan(&rec).Error; err != nil {
+		return nil, err
+	}
+	return &schemaStatus{Version: rec.Version, Latest: latestMigration(), Dirty: rec.Dirty}, nil
+}
+
+// runMigrateCommand handles `imagingsvc migrate [db flags] up|down [N]|status`
+func runMigrateCommand(args []string) {
+	var db dbConfig
+	migrateFlags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	addDBFlags(migrateFlags, &db)
+	migrationsTable := migrateFlags.String("migrationstable", "schema_migrations", "Table used to track the schema version")
+	migrateFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: imagingsvc migrate [flags] up|down [N]|status\n")
+		migrateFlags.PrintDefaults()
+	}
+	migrateFlags.Parse(args)
+	if errs := applyConfigLayers(migrateFlags, ""); len(errs) > 0 {
+		log.Fatal(strings.Join(errs, "; "))
+	}
+	if db.Host == "" || db.Name == "" || db.User == "" || db.Pass == "" {
+		log.Fatal("Parameters dbhost, dbname, dbuser and dbpass are required")
+	}
+
+	m, err := newMigrator(db, *migrationsTable)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	switch migrateFlags.Arg(0) {
+	case "up":
+		if _, _, verErr := m.Version(); errors.Is(verErr, migrate.ErrNilVersion) {
+			if _, err := baselineSchema(m, db); err != nil {
+				log.Fatal(err.Error())
+			}
+		}
+		err = m.Up()
+	case "down":
+		// only step back one migration unless told otherwise; a full down drops everything
+		steps := 1
+		if migrateFlags.Arg(1) != "" {
+			steps, err = strconv.Atoi(migrateFlags.Arg(1))
+			if err != nil || steps < 1 {
+				log.Fatalf("%s is not a valid number of migrations", migrateFlags.Arg(1))
+			}
+		}
+		err = m.Steps(-steps)
+	case "status":
+		ver, dirty, verErr := m.Version()
+		if verErr != nil && !errors.Is(verErr, migrate.ErrNilVersion) {
+			log.Fatalf("unable to get schema version: %s", verErr.Error())
+		}
+		files, _ := fs.Glob(migrationFiles, "db/migrations/*.up.sql")
+		for _, f := range files {
+			var fileVer uint
+			fmt.Sscanf(f, "db/migrations/%d_", &fileVer)
+			state := "pending"
+			if verErr == nil && fileVer <= ver {
+				state = "applied"
+			}
+			fmt.Printf("%-10s %s\n", state, f)
+		}
+		fmt.Printf("schema version %d of %d, dirty=%t\n", ver, latestMigration(), dirty)
+		return
+	default:
+		migrateFlags.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		log.Fatalf("migrate %s failed: %s", migrateFlags.Arg(0), err.Error())
+	}
+	ver, dirty, _ := m.Version()
+	log.Printf("INFO: schema is at version %d, dirty=%t", ver, dirty)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// delay before the mock dpg-jobs reports the result of a finalize request, like the real finalization job
+const mockFinalizeDelay = 5 * time.Second
+
+//go:embed mockdata/*.json
+var mockFixtures embed.FS
+
+// mockTrackSys is a stand-in for the TrackSys API and dpg-jobs used for local development. It serves the
+// reference data and unit details from the fixtures in mockdata and handles finalization by calling back
+// to this service the same way dpg-jobs does.
+type mockTrackSys struct {
+	serviceURL string
+	httpClient *http.Client
+	fixtures   map[string][]byte
+	units      map[int64]mockUnit
+	components map[string]json.RawMessage
+}
+
+// mockUnit is the unitInfo returned by the TrackSys API plus settings that control the mock behavior
+type mockUnit struct {
+	unitInfo
+	MockFinalize string `json:"mockFinalize,omitempty"` // fail to have finalization of the unit fail
+}
+
+// mockTrackSysURLs returns the URLs for the mock TrackSys API and dpg-jobs services
+func mockTrackSysURLs(port int) (string, string) {
+	baseURL := fmt.Sprintf("http://localhost:%d", port)
+	return baseURL + "/api", baseURL + "/jobs"
+}
+
+// startMockTrackSys starts the mock on the specified port. Point the service at it with mockTrackSysURLs
+func startMockTrackSys(port int, serviceURL string) {
+	log.Printf("WARNING: using mock TrackSys and dpg-jobs services on port %d", port)
+	mock := mockTrackSys{serviceURL: serviceURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		fixtures:   make(map[string][]byte),
+		units:      make(map[int64]mockUnit),
+		components: make(map[string]json.RawMessage),
+	}
+	for _, name := range []string{"staff", "customers", "agencies", "containertypes", "ocr"} {
+		mock.fixtures[name] = mock.loadFixture(name, nil)
+	}
+	var units []mockUnit
+	mock.loadFixture("units", &units)
+	for _, u := range units {
+		mock.units[u.ID] = u
+	}
+	var components []json.RawMessage
+	mock.loadFixture("components", &components)
+	for _, cmp := range components {
+		var cmpID struct {
+			ID int64 `json:"id"`
+		}
+		json.Unmarshal(cmp, &cmpID)
+		mock.components[fmt.Sprintf("%d", cmpID.ID)] = cmp
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	api := router.Group("/api")
+	{
+		api.GET("/staff", mock.getFixture("staff"))
+		api.GET("/staff/lookup", mock.lookupStaff)
+		api.GET("/customers", mock.getFixture("customers"))
+		api.GET("/agencies", mock.getFixture("agencies"))
+		api.GET("/containertypes", mock.getFixture("containertypes"))
+		api.GET("/ocr", mock.getFixture("ocr"))
+		api.GET("/units/:id", mock.getUnit)
+		api.GET("/components/:id", mock.getComponent)
+	}
+	jobs := router.Group("/jobs")
+	{
+		jobs.POST("/units/:id/finalize", mock.finalizeUnit)
+		jobs.POST("/units/:id/ocr-settings", mock.updateOCRSettings)
+	}
+
+	go func() {
+		log.Fatal(router.Run(fmt.Sprintf(":%d", port)))
+	}()
+}
+
+func (mock *mockTrackSys) loadFixture(name string, out any) []byte {
+	data, err := mockFixtures.ReadFile(fmt.Sprintf("mockdata/%s.json", name))
+	if err != nil {
+		log.Fatalf("unable to read mock fixture %s: %s", name, err.Error())
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			log.Fatalf("unable to parse mock fixture %s: %s", name, err.Error())
+		}
+	}
+	return data
+}
+
+func (mock *mockTrackSys) getFixture(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", mock.fixtures[name])
+	}
+}
+
+func (mock *mockTrackSys) lookupStaff(c *gin.Context) {
+	var staff []staffMember
+	json.Unmarshal(mock.fixtures["staff"], &staff)
+	cid := c.Query("cid")
+	id, _ := strconv.ParseUint(c.Query("id"), 10, 64)
+	for _, sm := range staff {
+		if (cid != "" && sm.ComputingID == cid) || (id != 0 && uint64(sm.ID) == id) {
+			c.JSON(http.StatusOK, sm)
+			return
+		}
+	}
+	c.String(http.StatusNotFound, "staff member not found")
+}
+
+// getUnit returns the fixture for the unit. Any other unit ID gets generated details so projects can
+// be created for whatever unit is needed
+func (mock *mockTrackSys) getUnit(c *gin.Context) {
+	unitID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid unit", c.Param("id")))
+		return
+	}
+	c.JSON(http.StatusOK, mock.getMockUnit(unitID))
+}
+
+func (mock *mockTrackSys) getMockUnit(unitID int64) mockUnit {
+	if u, found := mock.units[unitID]; found {
+		return u
+	}
+	return mockUnit{unitInfo: unitInfo{ID: unitID, MetadataID: unitID, MetadataPID: fmt.Sprintf("uva-lib:%d", unitID),
+		Title: fmt.Sprintf("Mock unit %d", unitID), CallNumber: fmt.Sprintf("MOCK %d", unitID),
+		IntendedUse: "Digital Collection Building", Status: "approved"}}
+}
+
+func (mock *mockTrackSys) getComponent(c *gin.Context) {
+	cmp, found := mock.components[c.Param("id")]
+	if !found {
+		c.String(http.StatusNotFound, fmt.Sprintf("component %s not found", c.Param("id")))
+		return
+	}
+	c.Data(http.StatusOK, "application/json", cmp)
+}
+
+func (mock *mockTrackSys) updateOCRSettings(c *gin.Context) {
+	log.Printf("INFO: mock dpg-jobs received ocr settings for unit %s", c.Param("id"))
+	c.String(http.StatusOK, "ok")
+}
+
+// finalizeUnit accepts the request then reports the result back to this service after a delay using
+// the token and request ID from the request, the same as the dpg-jobs finalization job
+func (mock *mockTrackSys) finalizeUnit(c *gin.Context) {
+	unitID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid unit", c.Param("id")))
+		return
+	}
+	log.Printf("INFO: mock dpg-jobs received finalize request for unit %d", unitID)
+	authHeader := c.GetHeader("Authorization")
+	reqID := c.GetHeader(requestIDHeader)
+	tgtUnit := mock.getMockUnit(unitID)
+	go func() {
+		time.Sleep(mockFinalizeDelay)
+		mock.finalizeCallback(tgtUnit, authHeader, reqID)
+	}()
+	c.String(http.StatusOK, "finalization started")
+}
+
+func (mock *mockTrackSys) finalizeCallback(tgtUnit mockUnit, authHeader string, reqID string) {
+	lookupURL := fmt.Sprintf("%s/projects/lookup?unit=%d", mock.serviceURL, tgtUnit.ID)
+	resp, err := mock.httpClient.Get(lookupURL)
+	if err != nil {
+		log.Printf("ERROR: mock dpg-jobs unable to lookup project for unit %d: %s", tgtUnit.ID, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	var lookup struct {
+		Exists    bool `json:"exists"`
+		ProjectID uint `json:"projectID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lookup); err != nil || !lookup.Exists {
+		log.Printf("ERROR: mock dpg-jobs found no project for unit %d", tgtUnit.ID)
+		return
+	}
+
+	callbackURL := fmt.Sprintf("%s/api/projects/%d/done", mock.serviceURL, lookup.ProjectID)
+	payload := map[string]any{"processingMins": 1}
+	if tgtUnit.MockFinalize == "fail" {
+		callbackURL = fmt.Sprintf("%s/api/projects/%d/fail", mock.serviceURL, lookup.ProjectID)
+		payload["reason"] = fmt.Sprintf("mock finalization of unit %d failed", tgtUnit.ID)
+	}
+	log.Printf("INFO: mock dpg-jobs finalization of unit %d complete; call %s", tgtUnit.ID, callbackURL)
+	b, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("POST", callbackURL, bytes.NewBuffer(b))
+	req.Header.Add("Content-type", "application/json")
+	req.Header.Add("Authorization", authHeader)
+	req.Header.Add(requestIDHeader, reqID)
+	cbResp, err := mock.httpClient.Do(req)
+	if err != nil {
+		log.Printf("ERROR: mock dpg-jobs callback %s failed: %s", callbackURL, err.Error())
+		return
+	}
+	cbResp.Body.Close()
+	if cbResp.StatusCode != http.StatusOK {
+		log.Printf("ERROR: mock dpg-jobs callback %s failed with status %d", callbackURL, cbResp.StatusCode)
+	}
+}
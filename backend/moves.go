@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Directory move status values
+const (
+	MoveInProgress = "in-progress"
+	MoveComplete   = "complete"
+)
+
+// directoryMove tracks the move of a unit directory between steps. Each file is recorded once it has been
+// copied and verified, so a move that was interrupted or failed picks up where it stopped when the step
+// is finished again. The copy counts show the progress of the move on the project.
+type directoryMove struct {
+	ID          int64      `json:"id"`
+	ProjectID   uint       `json:"projectID"`
+	UnitID      uint       `json:"unitID"`
+	SrcDir      string     `json:"srcDir"`
+	DestDir     string     `json:"destDir"`
+	Checksum    string     `json:"checksum"`
+	Status      string     `json:"status"`
+	TotalFiles  int        `json:"totalFiles"`
+	TotalBytes  int64      `json:"totalBytes"`
+	CopiedFiles int        `json:"copiedFiles"`
+	CopiedBytes int64      `json:"copiedBytes"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+
+	progressSavedAt time.Time
+}
+
+type directoryMoveFile struct {
+	ID       int64
+	MoveID   int64
+	Path     string
+	Size     int64
+	Checksum string
+}
+
+// progress is written to the DB at most this often while files are copied
+const moveProgressSaveDelay = 1 * time.Second
+
+// activeMove returns the unfinished move of a project to the destination, if there is one
+func (svc *serviceContext) activeMove(projectID uint, destDir string) (*directoryMove, error) {
+	var move directoryMove
+	err := svc.DB.Where("project_id=? and dest_dir=? and status=?", projectID, destDir, MoveInProgress).
+		Order("id desc").Limit(1).Find(&move).Error
+	if err != nil {
+		return nil, err
+	}
+	if move.ID == 0 {
+		return nil, nil
+	}
+	return &move, nil
+}
+
+// startMove records a new move along with the number of files and bytes to copy
+func (svc *serviceContext) startMove(proj *project, srcDir string, destDir string) (*directoryMove, error) {
+	move := directoryMove{ProjectID: proj.ID, UnitID: proj.UnitID, SrcDir: srcDir, DestDir: destDir,
+		Checksum: svc.Checksum, Status: MoveInProgress}
+	err := filepath.WalkDir(srcDir, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || isSourceManifest(srcDir, srcPath) {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		move.TotalFiles++
+		move.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to size %s: %s", srcDir, err.Error())
+	}
+	if err := svc.DB.Create(&move).Error; err != nil {
+		return nil, fmt.Errorf("unable to record move of %s: %s", srcDir, err.Error())
+	}
+	return &move, nil
+}
+
+// manifests in the source describe the source; a new one is written for the destination
+func isSourceManifest(srcDir string, srcPath string) bool {
+	return filepath.Dir(srcPath) == srcDir && isManifestFile(filepath.Base(srcPath))
+}
+
+// copyMoveFiles copies the files that have not already been copied by an earlier attempt and returns the
+// manifest for all of the files in the move. A file copied by an earlier attempt is checked against the
+// checksum recorded for it; one that does not match, or was left part way through a copy, is copied again
+func (svc *serviceContext) copyMoveFiles(move *directoryMove, tgtJob *job) (*fileManifest, error) {
+	newHash, found := checksumAlgorithms[move.Checksum]
+	if !found {
+		return nil, fmt.Errorf("unsupported checksum algorithm %s", move.Checksum)
+	}
+	var copied []directoryMoveFile
+	if err := svc.DB.Where("move_id=?", move.ID).Find(&copied).Error; err != nil {
+		return nil, fmt.Errorf("unable to get files copied by move %d: %s", move.ID, err.Error())
+	}
+	done := make(map[string]directoryMoveFile)
+	for _, mf := range copied {
+		done[mf.Path] = mf
+	}
+
+	svc.jobPhase(tgtJob, "move")
+	svc.jobAddWork(tgtJob, move.TotalFiles)
+	move.CopiedFiles = 0
+	move.CopiedBytes = 0
+	var bytesCopied int64
+	startTime := time.Now()
+	err := filepath.WalkDir(move.SrcDir, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(move.SrcDir, srcPath)
+		destPath := filepath.Join(move.DestDir, relPath)
+		if entry.IsDir() {
+			if exists(destPath) {
+				return nil
+			}
+			return svc.Files.mkdir(destPath)
+		}
+		if isSourceManifest(move.SrcDir, srcPath) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", srcPath)
+		}
+
+		relPath = filepath.ToSlash(relPath)
+		if mf, found := done[relPath]; found {
+			if checksum, err := fileChecksum(destPath, newHash()); err == nil && checksum == mf.Checksum {
+				svc.moveFileCopied(move, tgtJob, relPath, mf.Size)
+				return nil
+			}
+			tgtJob.logger().Warn("copied file is missing or does not match its recorded checksum; copy it again", "moveID", move.ID, "path", destPath)
+			svc.DB.Delete(&mf)
+			delete(done, relPath)
+		}
+		if exists(destPath) {
+			if err := svc.Files.remove(destPath); err != nil {
+				return fmt.Errorf("unable to remove partial copy %s: %s", destPath, err.Error())
+			}
+		}
+
+		checksum, err := svc.Files.copyFile(srcPath, destPath, move.Checksum)
+		if err != nil {
+			return err
+		}
+		mf := directoryMoveFile{MoveID: move.ID, Path: relPath, Size: info.Size(), Checksum: checksum}
+		if err := svc.DB.Create(&mf).Error; err != nil {
+			return fmt.Errorf("unable to record copy of %s: %s", srcPath, err.Error())
+		}
+		done[relPath] = mf
+		bytesCopied += info.Size()
+		svc.moveFileCopied(move, tgtJob, relPath, info.Size())
+		return nil
+	})
+	observeFileCopy(bytesCopied, time.Since(startTime))
+	svc.saveMoveProgress(move, tgtJob, err)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := fileManifest{Algorithm: move.Checksum, Entries: make([]manifestEntry, 0, len(done))}
+	for _, mf := range done {
+		manifest.Entries = append(manifest.Entries, manifestEntry{Path: mf.Path, Size: mf.Size, Checksum: mf.Checksum})
+	}
+	sort.Slice(manifest.Entries, func(i, j int) bool { return manifest.Entries[i].Path < manifest.Entries[j].Path })
+	return &manifest, nil
+}
+
+func (svc *serviceContext) moveFileCopied(move *directoryMove, tgtJob *job, relPath string, size int64) {
+	move.CopiedFiles++
+	move.CopiedBytes += size
+	svc.jobFileProcessed(tgtJob, relPath)
+	if time.Since(move.progressSavedAt) > moveProgressSaveDelay {
+		svc.saveMoveProgress(move, tgtJob, nil)
+	}
+}
+
+// saveMoveProgress writes the copy counts to the DB. The error from a failed attempt is kept until the next attempt
+func (svc *serviceContext) saveMoveProgress(move *directoryMove, tgtJob *job, moveErr error) {
+	move.progressSavedAt = time.Now()
+	move.Error = ""
+	if moveErr != nil {
+		move.Error = moveErr.Error()
+	}
+	if err := svc.DB.Model(move).Select("CopiedFiles", "CopiedBytes", "Error").Updates(move).Error; err != nil {
+		tgtJob.logger().Error("unable to save move progress", "moveID", move.ID, "error", err.Error())
+	}
+}
+
+func (svc *serviceContext) finishMove(move *directoryMove, tgtJob *job) {
+	now := time.Now()
+	move.Status = MoveComplete
+	move.FinishedAt = &now
+	if err := svc.DB.Model(move).Select("Status", "FinishedAt").Updates(move).Error; err != nil {
+		tgtJob.logger().Error("unable to mark move as complete", "moveID", move.ID, "error", err.Error())
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -32,6 +32,7 @@ type note struct {
 func (svc *serviceContext) addNoteRequest(c *gin.Context) {
 	projID := c.Param("id")
 	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	var noteReq struct {
 		StepID     uint   `json:"stepID"`
 		TypeID     uint   `json:"noteTypeID"`
@@ -41,24 +42,24 @@ func (svc *serviceContext) addNoteRequest(c *gin.Context) {
 
 	qpErr := c.ShouldBindJSON(&noteReq)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid note payload for project %s: %s", qpErr.Error(), projID)
+		logger.Error("invalid note payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	log.Printf("INFO: user %s is adding a note to project %s: %+v", claims.ComputeID, projID, noteReq)
+	logger.Info("add note to project", "request", fmt.Sprintf("%+v", noteReq))
 
 	var proj project
 	err := svc.DB.First(&proj, projID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s: %s", projID, err.Error())
+		logger.Error("unable to get project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	newNote := note{ProjectID: proj.ID, StepID: noteReq.StepID, StaffMemberID: claims.UserID, NoteType: noteReq.TypeID, Note: noteReq.Note}
-	notes, err := svc.addNote(proj, newNote, noteReq.ProblemIDs)
+	notes, err := svc.addNote(c.Request.Context(), proj, newNote, noteReq.ProblemIDs)
 	if err != nil {
-		log.Printf("ERROR: add note to project %d failed: %s", proj.ID, err.Error())
+		logger.Error("add note to project failed", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -66,8 +67,9 @@ func (svc *serviceContext) addNoteRequest(c *gin.Context) {
 	c.JSON(http.StatusOK, notes)
 }
 
-func (svc *serviceContext) addNote(proj project, newNote note, problemIDs []uint) ([]note, error) {
-	log.Printf("INFO: add note to project %d", proj.ID)
+func (svc *serviceContext) addNote(ctx context.Context, proj project, newNote note, problemIDs []uint) ([]note, error) {
+	logger := getLogger(ctx)
+	logger.Info("add note", "projectID", proj.ID)
 	var notes []note
 	err := svc.DB.Model(&proj).Association("Notes").Append(&newNote)
 	if err != nil {
@@ -83,7 +85,7 @@ func (svc *serviceContext) addNote(proj project, newNote note, problemIDs []uint
 		pq += strings.Join(vals, ",")
 		resp := svc.DB.Exec(pq)
 		if resp.Error != nil {
-			log.Printf("ERROR: unable to add problems to note: %s", resp.Error.Error())
+			logger.Error("unable to add problems to note", "noteID", newNote.ID, "error", resp.Error.Error())
 		}
 	}
 
@@ -96,26 +98,30 @@ func (svc *serviceContext) addNote(proj project, newNote note, problemIDs []uint
 	return notes, nil
 }
 
-func (svc *serviceContext) failStep(proj *project, problemName string, message string) {
-	log.Printf("INFO: flag project %d step %s with an error", proj.ID, proj.CurrentStep.Name)
+// failStep flags the current assignment with an error and adds a problem note with the message. Any
+// validation issues are recorded against the note so they can be listed by file
+func (svc *serviceContext) failStep(ctx context.Context, proj *project, problemName string, message string, issues ...validationIssue) {
+	logger := getLogger(ctx).With("step", proj.CurrentStep.Name)
+	logger.Info("flag step with an error")
 	var currA assignment
 	if err := svc.DB.Where("project_id=?", proj.ID).Order("assigned_at DESC").First(&currA).Error; err != nil {
-		log.Printf("ERROR: unable to get active assignment for project %d: %s", proj.ID, err.Error())
+		logger.Error("unable to get active assignment", "error", err.Error())
 		return
 	}
 
 	currA.Status = StepError
 	svc.DB.Model(&currA).Select("Status").Updates(currA)
 
-	log.Printf("INFO: adding problem(%s) note to project %d step %s", problemName, proj.ID, proj.CurrentStep.Name)
+	logger.Info("add problem note", "problem", problemName)
 	now := time.Now()
 	newNote := note{ProjectID: proj.ID, StepID: *proj.CurrentStepID, StaffMemberID: *proj.OwnerID,
 		NoteType: 2, Note: message, CreatedAt: &now, UpdatedAt: &now}
 	err := svc.DB.Model(&proj).Association("Notes").Append(&newNote)
 	if err != nil {
-		log.Printf("ERROR: unable to add note to project %d: %s", proj.ID, err.Error())
+		logger.Error("unable to add problem note", "error", err.Error())
 		return
 	}
+	svc.saveValidationIssues(proj, &currA, newNote.ID, issues)
 
 	var p problem
 	resp := svc.DB.Where("label = ?", problemName).First(&p)
@@ -130,6 +136,6 @@ func (svc *serviceContext) failStep(proj *project, problemName string, message s
 	pq += strings.Join(vals, ",")
 	resp = svc.DB.Exec(pq)
 	if resp.Error != nil {
-		log.Printf("ERROR: unable to add problems to note: %s", resp.Error.Error())
+		logger.Error("unable to add problems to note", "noteID", newNote.ID, "error", resp.Error.Error())
 	}
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// preflightIssue is a problem that would fail the current step when it is finished. The category
+// matches the problem name used for the note added by a failed finish and the rule matches the
+// validation issue that would be recorded
+type preflightIssue struct {
+	File     string `json:"file,omitempty"`
+	Category string `json:"category"`
+	Rule     string `json:"rule"`
+	Problem  string `json:"problem"`
+}
+
+type preflightResponse struct {
+	ProjectID uint             `json:"projectID"`
+	Step      string           `json:"step"`
+	Directory string           `json:"directory"`
+	Files     int              `json:"files"`
+	Issues    []preflightIssue `json:"issues"`
+}
+
+// getProjectPreflight runs the checks made when the current step is finished without changing anything.
+// All issues are returned rather than just the first one found. Assignment status and notes are not
+// touched, system files are not removed and the finalization prep by the viewer is not run
+func (svc *serviceContext) getProjectPreflight(c *gin.Context) {
+	projID := c.Param("id")
+	ctx := c.Request.Context()
+	logger := getLogger(ctx)
+	logger.Info("preflight check requested")
+
+	var proj project
+	if err := svc.DB.Preload("CurrentStep").Preload("Workflow").First(&proj, projID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.String(http.StatusNotFound, fmt.Sprintf("project %s not found", projID))
+			return
+		}
+		logger.Error("unable to get project for preflight", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if proj.CurrentStep == nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("project %d has no current step", proj.ID))
+		return
+	}
+
+	rules := svc.getStepValidation(proj.CurrentStep)
+	tgtDir, _, err := svc.stepDirectory(&proj, rules)
+	if err != nil {
+		logger.Error("invalid step validations", "step", proj.CurrentStep.Name, "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := preflightResponse{ProjectID: proj.ID, Step: proj.CurrentStep.Name, Directory: tgtDir, Issues: make([]preflightIssue, 0)}
+	if rules.ContainerTypeRequired && proj.ContainerTypeID == nil {
+		out.Issues = append(out.Issues, preflightIssue{Category: "Other", Rule: RuleContainerType, Problem: "This project is missing the required Container Type setting"})
+	}
+
+	if !exists(tgtDir) {
+		if rules.DirectoryExists {
+			out.Issues = append(out.Issues, preflightIssue{Category: "Filesystem", Rule: RuleMissingDirectory, Problem: fmt.Sprintf("Directory %s does not exist", tgtDir)})
+		}
+	} else if rules.checksImages() {
+		images, issues := svc.checkStepImages(ctx, &proj, rules, tgtDir, true, nil)
+		out.Files = len(images)
+		out.Issues = append(out.Issues, issues...)
+	}
+
+	logger.Info("preflight check complete", "step", proj.CurrentStep.Name, "issues", len(out.Issues))
+	c.JSON(http.StatusOK, out)
+}
+
+// checkStepImages checks the directory content, image names and sequence and the image headers required by
+// the step with a single walk of the directory. All issues are returned rather than just the first one, along
+// with the images found. Header check progress is reported to tgtJob, which may be nil. Nothing is changed when
+// readOnly is set; otherwise system files left by macOS and samba are removed.
+func (svc *serviceContext) checkStepImages(ctx context.Context, proj *project, rules *stepValidation, tgtDir string, readOnly bool, tgtJob *job) ([]string, []preflightIssue) {
+	if readOnly {
+		defer observeDirWalk("preflight", time.Now())
+	} else {
+		defer observeDirWalk("validate-images", time.Now())
+	}
+	logger := getLogger(ctx)
+	unitDir := padLeft(fmt.Sprintf("%d", proj.UnitID), 9)
+	issues := make([]preflightIssue, 0)
+	sequences := make(map[int]bool)
+	highest := 0
+	images := make([]string, 0)
+
+	err := filepath.WalkDir(tgtDir, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		relPath, _ := filepath.Rel(tgtDir, fullPath)
+		lcFN := strings.ToLower(entry.Name())
+		if lcFN == "notes.txt" {
+			if !rules.AllowNotes {
+				issues = append(issues, preflightIssue{File: relPath, Category: "Filesystem", Rule: RuleUnexpectedNotes, Problem: "Unexpected notes file"})
+			}
+			return nil
+		}
+		if isManifestFile(entry.Name()) {
+			return nil
+		}
+		if entry.Name() == ".DS_Store" || strings.HasPrefix(entry.Name(), ".smbdelete") {
+			if !readOnly {
+				logger.Info("remove system file", "file", fullPath)
+				svc.Files.remove(fullPath)
+			}
+			return nil
+		}
+		if rules.OnlyTif && filepath.Ext(lcFN) != ".tif" {
+			issues = append(issues, preflightIssue{File: relPath, Category: "Filesystem", Rule: RuleUnexpectedFile, Problem: "Unexpected file"})
+			return nil
+		}
+		if !rules.SequenceContiguous {
+			images = append(images, fullPath)
+			return nil
+		}
+
+		noExtFN := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		prefix, seqStr, found := strings.Cut(noExtFN, "_")
+		seq, seqErr := strconv.Atoi(seqStr)
+		if !found || prefix != unitDir || seqErr != nil {
+			issues = append(issues, preflightIssue{File: relPath, Category: "Filename", Rule: RuleInvalidFilename, Problem: "Incorrectly named image file"})
+			return nil
+		}
+		if sequences[seq] {
+			issues = append(issues, preflightIssue{File: relPath, Category: "Filename", Rule: RuleSequenceMismatch, Problem: fmt.Sprintf("Duplicate image sequence number %d", seq)})
+		}
+		sequences[seq] = true
+		if seq > highest {
+			highest = seq
+		}
+		images = append(images, fullPath)
+		return nil
+	})
+	if err != nil {
+		issues = append(issues, preflightIssue{Category: "Filesystem", Rule: RuleMissingDirectory, Problem: fmt.Sprintf("Unable to read %s: %s", tgtDir, err.Error())})
+		return images, issues
+	}
+
+	if len(images) == 0 {
+		issues = append(issues, preflightIssue{Category: "Filesystem", Rule: RuleNoImages, Problem: fmt.Sprintf("No image files found in %s", tgtDir)})
+		return images, issues
+	}
+	if rules.SequenceContiguous && highest != len(images) {
+		missing := make([]string, 0)
+		for seq := 1; seq <= highest; seq++ {
+			if !sequences[seq] {
+				missing = append(missing, fmt.Sprintf("%d", seq))
+			}
+		}
+		msg := fmt.Sprintf("Number of image files does not match highest image sequence number %d", highest)
+		if len(missing) > 0 {
+			msg += fmt.Sprintf("; missing sequence numbers %s", strings.Join(missing, ", "))
+		}
+		issues = append(issues, preflightIssue{Category: "Filename", Rule: RuleSequenceMismatch, Problem: msg})
+	}
+
+	if rules.checksHeaders() {
+		issues = append(issues, svc.checkImageHeaders(ctx, images, rules, tgtDir, tgtJob)...)
+	}
+	return images, issues
+}
+
+// checkImageHeaders runs the header checks in batches and returns the problems sorted by file. When the
+// metadata cannot be read at all, only the first such problem is returned
+func (svc *serviceContext) checkImageHeaders(ctx context.Context, images []string, rules *stepValidation, tgtDir string, tgtJob *job) []preflightIssue {
+	logger := getLogger(ctx)
+	startTime := time.Now()
+	fileDone := func(file string) {
+		svc.jobFileProcessed(tgtJob, path.Base(file))
+	}
+	problemChannel := make(chan updateProblem)
+	var checkWG sync.WaitGroup
+	for start := 0; start < len(images); start += svc.BatchSize {
+		end := min(start+svc.BatchSize, len(images))
+		checkWG.Add(1)
+		svc.jobAddWork(tgtJob, end-start)
+		go func(files []string) {
+			defer checkWG.Done()
+			svc.checkExifHeaders(ctx, files, rules, problemChannel, fileDone)
+		}(images[start:end])
+	}
+	go func() {
+		checkWG.Wait()
+		close(problemChannel)
+		logger.Info("all header checks have completed", "dir", tgtDir, "elapsedMS", time.Since(startTime).Milliseconds())
+	}()
+
+	issues := make([]preflightIssue, 0)
+	unreadable := false
+	for problem := range problemChannel {
+		issue := preflightIssue{Category: "Metadata", Rule: problem.Rule, Problem: problem.Problem}
+		if problem.File == "all" {
+			if unreadable {
+				continue
+			}
+			unreadable = true
+			issue.Problem = fmt.Sprintf("Unable to extract metadata from images: %s", problem.Problem)
+		} else {
+			issue.File, _ = filepath.Rel(tgtDir, problem.File)
+			if issue.File == "" {
+				issue.File = path.Base(problem.File)
+			}
+		}
+		issues = append(issues, issue)
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].File < issues[j].File })
+	return issues
+}
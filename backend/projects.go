@@ -1,15 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
 	"regexp"
-	"slices"
 	"strconv"
 	"time"
 
@@ -126,6 +122,7 @@ type project struct {
 	OCRMasterFiles      bool          `gorm:"-" json:"ocrMasterFiles"`      // pulled from TS API call, not gorm
 	UnitStatus          string        `gorm:"-" json:"-"`                   // pulled from TS API call, not gorm (used for backend checks)
 	ImageCount          int           `json:"imageCount"`
+	LastFileActivity    *time.Time    `json:"lastFileActivity,omitempty"`
 	Assignments         []*assignment `gorm:"foreignKey:ProjectID" json:"assignments"`
 	CurrentStepID       *uint         `json:"currentStepID"`
 	CurrentStep         *step         `gorm:"foreignKey:CurrentStepID" json:"currentStep"`
@@ -145,6 +142,8 @@ type project struct {
 	ContainerTypeID     *uint         `json:"containerTypeID"`
 	Notes               []*note       `gorm:"foreignKey:ProjectID" json:"notes,omitempty"`
 	Equipment           []*equipment  `gorm:"many2many:project_equipment" json:"equipment,omitempty"`
+
+	Move *directoryMove `gorm:"-" json:"move,omitempty"` // unfinished move of the unit files between steps
 }
 
 // Structture of data returned from the tracksys-api /units/:id call
@@ -167,39 +166,46 @@ type unitInfo struct {
 
 func (svc *serviceContext) getProject(c *gin.Context) {
 	projID := c.Param("id")
-	claims := getJWTClaims(c)
-	log.Printf("INFO: user %s is requesting project %s details", claims.ComputeID, projID)
+	logger := getLogger(c.Request.Context())
+	logger.Info("get project details")
 
 	var proj *project
 	projQ := svc.DB.Model(&project{}).InnerJoins("Workflow").InnerJoins("Category").Joins("CurrentStep").Preload("Equipment").Preload("Workstation")
 	if err := projQ.First(&proj, projID).Error; err != nil {
-		log.Printf("ERROR: unable to get project %s: %s", projID, err.Error())
+		logger.Error("unable to get project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// now pull some details from the API
-	if err := svc.getProjectUnitDetails(proj); err != nil {
-		log.Printf("ERROR: unable to get project %d unit %d details: %s", proj.ID, proj.UnitID, err.Error())
+	if err := svc.getProjectUnitDetails(c.Request.Context(), proj); err != nil {
+		logger.Error("unable to get unit details", "unitID", proj.UnitID, "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: get project %d assignments", proj.ID)
+	logger.Info("get project assignments")
 	if err := svc.DB.Where("project_id=?", proj.ID).Joins("Step").Order("assigned_at DESC").Find(&proj.Assignments).Error; err != nil {
-		log.Printf("ERROR: unable to get project %d assignments: %s", proj.ID, err.Error())
+		logger.Error("unable to get project assignments", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: get project %d notes", proj.ID)
+	logger.Info("get project notes")
 	if err := svc.DB.Where("project_id=?", proj.ID).Joins("Step").Preload("Problems").
 		Order("notes.created_at DESC").Find(&proj.Notes).Error; err != nil {
-		log.Printf("ERROR: unable to get project %d notes: %s", proj.ID, err.Error())
+		logger.Error("unable to get project notes", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	var moves []*directoryMove
+	if err := svc.DB.Where("project_id=? and status=?", proj.ID, MoveInProgress).Order("id desc").Limit(1).Find(&moves).Error; err != nil {
+		logger.Warn("unable to get project file move", "error", err.Error())
+	} else if len(moves) > 0 {
+		proj.Move = moves[0]
+	}
+
 	c.JSON(http.StatusOK, proj)
 }
 
@@ -221,14 +227,9 @@ func (svc *serviceContext) getProjectContainerType(proj *project) (*containerTyp
 	return tgtContainer, nil
 }
 
-func (svc *serviceContext) getProjectUnitDetails(proj *project) error {
-	respBytes, reqErr := svc.getRequest(fmt.Sprintf("%s/units/%d", svc.TrackSys.API, proj.UnitID))
-	if reqErr != nil {
-		return fmt.Errorf("%s", reqErr.Message)
-	}
-
-	var unitMD unitInfo
-	if err := json.Unmarshal(respBytes, &unitMD); err != nil {
+func (svc *serviceContext) getProjectUnitDetails(ctx context.Context, proj *project) error {
+	unitMD, err := svc.TrackSysAPI.unit(ctx, int64(proj.UnitID))
+	if err != nil {
 		return err
 	}
 	proj.MetadataID = uint(unitMD.MetadataID)
@@ -245,14 +246,15 @@ func (svc *serviceContext) getProjectUnitDetails(proj *project) error {
 func (svc *serviceContext) deleteProject(c *gin.Context) {
 	projID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	claims := getJWTClaims(c)
-	log.Printf("INFO: %s requests project %d delete", claims.ComputeID, projID)
+	logger := getLogger(c.Request.Context())
+	logger.Info("delete project requested")
 	if claims.Role != "admin" && claims.Role != "supervisor" {
 		c.String(http.StatusForbidden, "you cannot delete this project")
 		return
 	}
 
-	if err := svc.doProjectDelete(projID); err != nil {
-		log.Printf("ERROR: %s", err.Error())
+	if err := svc.doProjectDelete(c.Request.Context(), projID); err != nil {
+		logger.Error("unable to delete project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -260,23 +262,24 @@ func (svc *serviceContext) deleteProject(c *gin.Context) {
 	c.String(http.StatusOK, "deleted")
 }
 
-func (svc *serviceContext) doProjectDelete(projID int64) error {
-	log.Printf("INFO: delete notes associated with project %d", projID)
+func (svc *serviceContext) doProjectDelete(ctx context.Context, projID int64) error {
+	logger := getLogger(ctx).With("projectID", projID)
+	logger.Info("delete notes associated with project")
 	if err := svc.DB.Exec("delete from notes where project_id=?", projID).Error; err != nil {
 		return fmt.Errorf("unable to delete notes for canceled project %d: %s", projID, err.Error())
 	}
 
-	log.Printf("INFO: delete equipment associated with project %d", projID)
+	logger.Info("delete equipment associated with project")
 	if err := svc.DB.Exec("delete from project_equipment where project_id=?", projID).Error; err != nil {
 		return fmt.Errorf("unable to delete equipment for canceled project %d: %s", projID, err.Error())
 	}
 
-	log.Printf("INFO: delete assigmnents associated with project %d", projID)
+	logger.Info("delete assignments associated with project")
 	if err := svc.DB.Exec("delete from assignments where project_id=?", projID).Error; err != nil {
 		return fmt.Errorf("unable to delete assignments for canceled project %d: %s", projID, err.Error())
 	}
 
-	log.Printf("INFO: delete project %d", projID)
+	logger.Info("delete project")
 	if err := svc.DB.Exec("delete from projects where id=?", projID).Error; err != nil {
 		return fmt.Errorf("unable to project %d: %s", projID, err.Error())
 	}
@@ -285,36 +288,34 @@ func (svc *serviceContext) doProjectDelete(projID int64) error {
 
 func (svc *serviceContext) updateProjecImageCount(c *gin.Context) {
 	projID := c.Param("id")
-	log.Printf("INFO: check project %s image count", projID)
+	logger := getLogger(c.Request.Context())
+	logger.Info("check project image count")
 	var proj project
 	err := svc.DB.Preload("CurrentStep").Find(&proj, projID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s: %s", projID, err.Error())
+		logger.Error("unable to get project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	noCountSteps := []string{"Scan", "Process", "Create Metadata"}
-	hasCount := !slices.Contains(noCountSteps, proj.CurrentStep.Name)
-
-	if hasCount == false {
-		log.Printf("INFO: project %s is on step %s, no image count yet", projID, proj.CurrentStep.Name)
+	if !svc.getStepValidation(proj.CurrentStep).UpdateImageCount {
+		logger.Info("no image count yet", "step", proj.CurrentStep.Name)
 		c.String(http.StatusOK, "ok")
 		return
 	}
 
 	mfCnt := svc.getImageCount(proj.UnitID)
 	if mfCnt != proj.ImageCount {
-		log.Printf("INFO: update project %s image count to: %d", projID, mfCnt)
+		logger.Info("update project image count", "imageCount", mfCnt)
 		proj.ImageCount = mfCnt
 		err = svc.DB.Table("projects").Where("id = ?", proj.ID).Update("image_count", mfCnt).Error
 		if err != nil {
-			log.Printf("ERROR: unable to update project %s image count to %d: %s", projID, mfCnt, err.Error())
+			logger.Error("unable to update project image count", "imageCount", mfCnt, "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 	} else {
-		log.Printf("INFO: project %s has %d images; no update needed", projID, mfCnt)
+		logger.Info("image count is unchanged; no update needed", "imageCount", mfCnt)
 	}
 	c.String(http.StatusOK, fmt.Sprintf("%d", mfCnt))
 }
@@ -324,34 +325,21 @@ func (svc *serviceContext) getImageCount(unitID uint) int {
 	uidStr := padLeft(fmt.Sprintf("%d", unitID), 9)
 	unitDir := path.Join(svc.ImagesDir, uidStr)
 	mfRegex := regexp.MustCompile(`^\d{9}_\w{4,}\.tif$`)
-	err := filepath.Walk(unitDir, func(path string, f os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("WARNING: directory traverse failed: %s", err.Error())
-			return nil
+	for _, mfPath := range svc.UnitIndex.files(unitDir) {
+		if mfRegex.Match([]byte(path.Base(mfPath))) {
+			mfCnt++
 		}
-
-		if !f.IsDir() {
-			fName := f.Name()
-			if mfRegex.Match([]byte(fName)) {
-				mfCnt++
-				return nil
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		log.Printf("ERROR: unable to get image count for unit %d: %s", unitID, err.Error())
-		mfCnt = 0
 	}
 	return mfCnt
 }
 
 func (svc *serviceContext) getProjectStatus(c *gin.Context) {
 	projID := c.Param("id")
+	logger := getLogger(c.Request.Context())
 	var proj *project
 	err := svc.DB.First(&proj, projID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s for status check: %s", projID, err.Error())
+		logger.Error("unable to get project for status check", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -362,7 +350,7 @@ func (svc *serviceContext) getProjectStatus(c *gin.Context) {
 		var currAssgn assignment
 		err = svc.DB.Where("project_id=?", proj.ID).Order("assigned_at desc").First(&currAssgn).Error
 		if err != nil {
-			log.Printf("ERROR: unable to get current assignemnt for project %s: %s", projID, err.Error())
+			logger.Error("unable to get current assignment", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -372,6 +360,7 @@ func (svc *serviceContext) getProjectStatus(c *gin.Context) {
 
 func (svc *serviceContext) getProjects(c *gin.Context) {
 	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	pageSize := 20
 	pageQ := c.Query("page")
 	if pageQ == "" {
@@ -379,7 +368,7 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 	}
 	page, err := strconv.Atoi(pageQ)
 	if err != nil {
-		log.Printf("ERROR: invalid page %s specified, default to 1", pageQ)
+		logger.Error("invalid page specified, default to 1", "page", pageQ)
 		page = 1
 	}
 	offset := (page - 1) * pageSize
@@ -404,12 +393,12 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 		}
 	}
 	if filterIdx == -1 {
-		log.Printf("ERROR: invalid filter %s specified", filter)
+		logger.Error("invalid filter specified", "filter", filter)
 		c.String(http.StatusBadRequest, fmt.Sprintf("%s is an invalid filter", filter))
 		return
 	}
 
-	log.Printf("INFO: user %s requests projects page %d", claims.ComputeID, page)
+	logger.Info("get projects page", "page", page, "filter", filter)
 
 	whereQ := ""
 	qWorkflow := c.Query("workflow")
@@ -449,13 +438,13 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 	if qUnitID != "" {
 		id, _ := strconv.Atoi(qUnitID)
 		whereQ += fmt.Sprintf(" AND unit_id = %d", id)
-		log.Printf("INFO: query for unit %d", id)
+		logger.Info("query for unit", "unitID", id)
 	}
 	qOrderID := c.Query("order")
 	if qOrderID != "" {
 		id, _ := strconv.Atoi(qOrderID)
 		whereQ += fmt.Sprintf(" AND order_id = %d", id)
-		log.Printf("INFO: query for order %d", id)
+		logger.Info("query for order", "orderID", id)
 	}
 
 	type projResp struct {
@@ -475,7 +464,7 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 		countQ := q + whereQ
 		err = svc.getBaseSearchQuery().Where(countQ).Count(&total).Error
 		if err != nil {
-			log.Printf("WARNING: unable to get count of projects: %s", err.Error())
+			logger.Warn("unable to get count of projects", "filter", filters[idx], "error", err.Error())
 			total = 0
 		}
 		switch idx {
@@ -502,7 +491,7 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 		Where(whereQ).
 		Find(&out.Projects).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get projects: %s", err.Error())
+		logger.Error("unable to get projects", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -510,8 +499,8 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 	// iterate all results to get details held outdide of dpg imaging and all assignments
 	for _, p := range out.Projects {
 		// get external data
-		if err := svc.getProjectUnitDetails(p); err != nil {
-			log.Printf("ERROR: unable to get project %d unit %d detail: %s", p.ID, p.UnitID, err.Error())
+		if err := svc.getProjectUnitDetails(c.Request.Context(), p); err != nil {
+			logger.Error("unable to get unit details", "projectID", p.ID, "unitID", p.UnitID, "error", err.Error())
 			// c.String(http.StatusInternalServerError, err.Error())
 			// return
 		}
@@ -519,7 +508,7 @@ func (svc *serviceContext) getProjects(c *gin.Context) {
 		// get assignments
 		if err := svc.DB.Where("project_id=?", p.ID).Joins("Step").
 			Order("assigned_at DESC").Find(&p.Assignments).Error; err != nil {
-			log.Printf("ERROR: unable to get project %d assignments: %s", p.ID, err.Error())
+			logger.Error("unable to get project assignments", "projectID", p.ID, "error", err.Error())
 			// c.String(http.StatusInternalServerError, err.Error())
 			// return
 		}
@@ -532,10 +521,11 @@ func (svc *serviceContext) assignProject(c *gin.Context) {
 	projID := c.Param("id")
 	userID := c.Param("uid")
 	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	if userID == "0" {
-		log.Printf("INFO: user %s is clearing %s assignment", claims.ComputeID, projID)
+		logger.Info("clear project assignment")
 	} else {
-		log.Printf("INFO: user %s is assigning project %s to user ID %s", claims.ComputeID, projID, userID)
+		logger.Info("assign project", "assigneeID", userID)
 	}
 
 	newOwnerID, _ := strconv.ParseUint(userID, 10, 64)
@@ -547,18 +537,18 @@ func (svc *serviceContext) assignProject(c *gin.Context) {
 		OwnerID: uint(newOwnerID),
 	}
 
-	log.Printf("INFO: looking up project %s", projID)
+	logger.Info("look up project")
 	var proj project
 	if err := svc.DB.Joins("CurrentStep").First(&proj, projID).Error; err != nil {
-		log.Printf("ERROR: unable to get project %s for reassign: %s", projID, err.Error())
+		logger.Error("unable to get project for reassign", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: lookup active assignent for project %s", projID)
+	logger.Info("look up active assignment")
 	var activeAssign assignment
 	if err := svc.DB.Where("project_id=?", proj.ID).Joins("Step").Order("assigned_at DESC").Limit(1).Find(&activeAssign).Error; err != nil {
-		log.Printf("ERROR: unable to get active assignment project %s reassign: %s", projID, err.Error())
+		logger.Error("unable to get active assignment for reassign", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -570,21 +560,21 @@ func (svc *serviceContext) assignProject(c *gin.Context) {
 		newNote := note{ProjectID: proj.ID, StepID: *proj.CurrentStepID, StaffMemberID: *proj.OwnerID,
 			NoteType: 0, Note: msg, CreatedAt: &now, UpdatedAt: &now}
 		problemIDs := make([]uint, 0)
-		_, err := svc.addNote(proj, newNote, problemIDs)
+		_, err := svc.addNote(c.Request.Context(), proj, newNote, problemIDs)
 		if err != nil {
-			log.Printf("ERROR: unable to add note to project %d: %s", proj.ID, err.Error())
+			logger.Error("unable to add cancel assignment note", "error", err.Error())
 			return
 		}
 
-		log.Printf("INFO: mark assignment %d as reassigned", activeAssign.ID)
+		logger.Info("mark assignment as reassigned", "assignmentID", activeAssign.ID)
 		activeAssign.Status = StepReassigned
 		if err := svc.DB.Model(&activeAssign).Select("Status").Updates(activeAssign).Error; err != nil {
-			log.Printf("ERROR: unable to mark project %d active assignment as reassigned: %s", proj.ID, err.Error())
+			logger.Error("unable to mark active assignment as reassigned", "assignmentID", activeAssign.ID, "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		log.Printf("INFO: clear owner for project %s", projID)
+		logger.Info("clear project owner")
 		proj.OwnerID = nil
 		if err := svc.DB.Model(&proj).Select("OwnerID").Updates(proj).Error; err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
@@ -592,54 +582,60 @@ func (svc *serviceContext) assignProject(c *gin.Context) {
 		}
 	} else {
 		if proj.OwnerID != nil && *proj.OwnerID == out.OwnerID {
-			log.Printf("INFO: project %d owner is already %d, no change needed", proj.ID, *proj.OwnerID)
+			logger.Info("project owner is unchanged", "ownerID", *proj.OwnerID)
 		} else {
-			if err := svc.canAssignProject(out.OwnerID, claims, &proj); err != nil {
+			// the owner checks compare the assignee to the prior and original owners
+			if err := svc.DB.Where("project_id=?", proj.ID).Joins("Step").Order("assigned_at DESC").Find(&proj.Assignments).Error; err != nil {
+				logger.Error("unable to get project assignments for reassign", "error", err.Error())
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			if err := svc.canAssignProject(c.Request.Context(), out.OwnerID, claims, &proj); err != nil {
 				c.String(http.StatusBadRequest, err.Error())
 				return
 			}
 
-			log.Printf("INFO: user %s can assign project %d to %s; updating data", claims.ComputeID, proj.ID, svc.getComputeID(out.OwnerID))
+			logger.Info("project can be assigned; update data", "assignee", svc.getComputeID(out.OwnerID))
 
 			// If someone else has this assignment, flag it as reassigned. Do not mark the finished time as it was never actually finished
 			if proj.OwnerID != nil {
-				log.Printf("INFO: marking assignment %d as reassigned", activeAssign.ID)
+				logger.Info("mark assignment as reassigned", "assignmentID", activeAssign.ID)
 				activeAssign.Status = StepReassigned
 				if err := svc.DB.Model(&activeAssign).Select("Status").Updates(activeAssign).Error; err != nil {
-					log.Printf("ERROR: unable to mark project %d active assignment as reassigned: %s", proj.ID, err.Error())
+					logger.Error("unable to mark active assignment as reassigned", "assignmentID", activeAssign.ID, "error", err.Error())
 					c.String(http.StatusInternalServerError, err.Error())
 					return
 				}
 			}
 
-			log.Printf("INFO: create assigmment for new owner %d", out.OwnerID)
+			logger.Info("create assignment for new owner", "ownerID", out.OwnerID)
 			now := time.Now()
 			newA := assignment{ProjectID: proj.ID, StepID: *proj.CurrentStepID, StaffMemberID: out.OwnerID, AssignedAt: &now}
 			if err := svc.DB.Create(&newA).Error; err != nil {
-				log.Printf("ERROR: unable to create new assignment for project %d step %d: %s", proj.ID, proj.CurrentStepID, err.Error())
+				logger.Error("unable to create new assignment", "stepID", *proj.CurrentStepID, "error", err.Error())
 				c.String(http.StatusInternalServerError, err.Error())
 				return
 			}
 
-			log.Printf("INFO: new owner %d for project %d", out.OwnerID, proj.ID)
+			logger.Info("set new project owner", "ownerID", out.OwnerID)
 			proj.OwnerID = &out.OwnerID
 			if err := svc.DB.Model(&proj).Select("OwnerID").Updates(proj).Error; err != nil {
-				log.Printf("ERROR: unable set project %d owner to %d: %s", proj.ID, out.OwnerID, err.Error())
+				logger.Error("unable to set project owner", "ownerID", out.OwnerID, "error", err.Error())
 				c.String(http.StatusInternalServerError, err.Error())
 				return
 			}
-			log.Printf("INFO: project %d assigned to user %d", proj.ID, out.OwnerID)
+			logger.Info("project assigned", "ownerID", out.OwnerID)
 		}
 	}
 
-	log.Printf("INFO: update data for reassigned project %d", proj.ID)
+	logger.Info("refresh data for reassigned project")
 	if err := svc.DB.Where("project_id=?", proj.ID).Joins("Step").Order("assigned_at DESC").Find(&out.Assignments).Error; err != nil {
-		log.Printf("ERROR: unable to refresh assigned project %s assignments: %s", projID, err.Error())
+		logger.Error("unable to refresh assigned project assignments", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if err := svc.DB.Where("project_id=?", proj.ID).Joins("Step").Preload("Problems").Order("notes.created_at DESC").Find(&out.Notes).Error; err != nil {
-		log.Printf("ERROR: unable to refresh assigned project %s notes: %s", projID, err.Error())
+		logger.Error("unable to refresh assigned project notes", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -649,7 +645,7 @@ func (svc *serviceContext) assignProject(c *gin.Context) {
 
 func (svc *serviceContext) updateProject(c *gin.Context) {
 	projID := c.Param("id")
-	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	var updateData struct {
 		ContainerTypeID uint   `json:"containerTypeID"`
 		CategoryID      uint   `json:"categoryID"`
@@ -662,22 +658,20 @@ func (svc *serviceContext) updateProject(c *gin.Context) {
 
 	qpErr := c.ShouldBindJSON(&updateData)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid update project %s payload: %v", projID, qpErr)
+		logger.Error("invalid update project payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	log.Printf("INFO: user %s is updating project %s: %+v", claims.ComputeID, projID, updateData)
+	logger.Info("update project", "request", fmt.Sprintf("%+v", updateData))
 
-	log.Printf("INFO: lookup project %s", projID)
 	var proj project
 	err := svc.DB.Preload("Workflow").First(&proj, projID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s update: %s", projID, err.Error())
+		logger.Error("unable to get project for update", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: update data for project %s", projID)
 	proj.CategoryID = updateData.CategoryID
 	proj.ItemCondition = updateData.Condition
 	proj.ConditionNote = updateData.Note
@@ -686,16 +680,15 @@ func (svc *serviceContext) updateProject(c *gin.Context) {
 	}
 	err = svc.DB.Model(&proj).Select("ContainerTypeID", "CategoryID", "ItemCondition", "ConditionNote").Updates(proj).Error
 	if err != nil {
-		log.Printf("ERROR: unable to update data for project %s: %s", projID, err.Error())
+		logger.Error("unable to update project data", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: call jobs service to upload ocr settings for project %s", projID)
-	_, httpErr := svc.postJobsRequest(fmt.Sprintf("%s/units/%d/ocr-settings", svc.TrackSys.Jobs, proj.UnitID), updateData, getJWT(c))
-	if httpErr != nil {
-		log.Printf("ERROR: update ocr settings request failed: %s", httpErr.Message)
-		c.String(httpErr.StatusCode, httpErr.Message)
+	logger.Info("call jobs service to update ocr settings")
+	if err := svc.Jobs.updateOCRSettings(c.Request.Context(), proj.UnitID, updateData, getJWT(c)); err != nil {
+		logger.Error("update ocr settings request failed", "error", err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
 	}
 
@@ -704,7 +697,7 @@ func (svc *serviceContext) updateProject(c *gin.Context) {
 
 func (svc *serviceContext) setProjectEquipment(c *gin.Context) {
 	projID := c.Param("id")
-	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	var equipPost struct {
 		WorkstationID     uint   `json:"workstationID"`
 		CaptureResolution uint   `json:"captureResolution"`
@@ -714,48 +707,49 @@ func (svc *serviceContext) setProjectEquipment(c *gin.Context) {
 
 	qpErr := c.ShouldBindJSON(&equipPost)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid set equipment payload: %v", qpErr)
+		logger.Error("invalid set equipment payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	log.Printf("INFO: user %s is setting project %s equipment: %+v", claims.ComputeID, projID, equipPost)
+	logger = logger.With("workstationID", equipPost.WorkstationID)
+	logger.Info("set project equipment", "request", fmt.Sprintf("%+v", equipPost))
 
 	var proj project
 	err := svc.DB.First(&proj, projID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s for equipment update: %s", projID, err.Error())
+		logger.Error("unable to get project for equipment update", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: get current equipment for workstation %d", equipPost.WorkstationID)
+	logger.Info("get current equipment for workstation")
 	var ws workstation
 	err = svc.DB.Preload("Equipment").First(&ws, equipPost.WorkstationID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get workstation %d: %s", equipPost.WorkstationID, err.Error())
+		logger.Error("unable to get workstation", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: remove all equipment for project %s", projID)
+	logger.Info("remove all equipment from project")
 	err = svc.DB.Model(&proj).Association("Equipment").Clear()
 	if err != nil {
-		log.Printf("ERROR: unable to clear existing equipment from project %s: %s", projID, err.Error())
+		logger.Error("unable to clear existing equipment from project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: add equipment to project %s", projID)
+	logger.Info("add equipment to project")
 	now := time.Now()
 	for _, e := range ws.Equipment {
 		pe := projectEquipment{ProjectID: proj.ID, EquipmentID: e.ID, CreatedAt: &now, UpdatedAt: &now}
 		resp := svc.DB.Create(&pe)
 		if resp.Error != nil {
-			log.Printf("ERROR: unable to add equipment %s to project %s: %s", e.Name, projID, resp.Error.Error())
+			logger.Error("unable to add equipment to project", "equipment", e.Name, "error", resp.Error.Error())
 		}
 	}
 
-	log.Printf("INFO: set workstation for project %s", projID)
+	logger.Info("set workstation for project")
 	proj.WorkstationID = equipPost.WorkstationID
 	proj.Workstation = ws
 	proj.CaptureResolution = equipPost.CaptureResolution
@@ -763,34 +757,34 @@ func (svc *serviceContext) setProjectEquipment(c *gin.Context) {
 	proj.ResolutionNote = equipPost.ResolutionNote
 	err = svc.DB.Model(&proj).Select("WorkstationID", "CaptureResolution", "ResizedResolution", "ResolutionNote").Updates(proj).Error
 	if err != nil {
-		log.Printf("ERROR: unable to set workstation for project %s: %s", projID, err.Error())
+		logger.Error("unable to set workstation for project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: load updated equipment for project %d", proj.ID)
+	logger.Info("load updated project equipment")
 	err = svc.DB.Preload("Equipment").Preload("Workstation").First(&proj, projID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s for equipment update: %s", projID, err.Error())
+		logger.Error("unable to load updated project equipment", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, proj)
 }
 
-func (svc *serviceContext) canAssignProject(assigneeID uint, assigner *jwtClaims, proj *project) error {
-	log.Printf("INFO: check if project %d can be asigned to user id %d by %s",
-		proj.ID, assigneeID, assigner.ComputeID)
+func (svc *serviceContext) canAssignProject(ctx context.Context, assigneeID uint, assigner *jwtClaims, proj *project) error {
+	logger := getLogger(ctx)
+	logger.Info("check if project can be assigned", "assigneeID", assigneeID, "assigner", assigner.ComputeID)
 	assignee, err := svc.getStaff(assigneeID)
 	if err != nil {
-		log.Printf("ERROR: unable to get assignee %d: %s", assigneeID, err.Error())
+		logger.Error("unable to get assignee", "assigneeID", assigneeID, "error", err.Error())
 		return err
 	}
 
 	// admin/supervisor can caim or assign anything
 	assigneeRole := assignee.roleString()
 	if assigneeRole == "supervisor" || assigneeRole == "admin" || assigner.Role == "supervisor" || assigner.Role == "admin" {
-		log.Printf("INFO: assigner or assignee is addmin or supervisor; no further checks needed")
+		logger.Info("assigner or assignee is admin or supervisor; no further checks needed")
 		return nil
 	}
 
@@ -798,7 +792,7 @@ func (svc *serviceContext) canAssignProject(assigneeID uint, assigner *jwtClaims
 
 	// Any owner
 	if proj.CurrentStep.OwnerType == 0 {
-		log.Printf("INFO: project %d, step %s has no ownership constraints", proj.ID, proj.CurrentStep.Name)
+		logger.Info("step has no ownership constraints", "step", proj.CurrentStep.Name)
 		return nil
 	}
 
@@ -807,8 +801,7 @@ func (svc *serviceContext) canAssignProject(assigneeID uint, assigner *jwtClaims
 		lastAssign := proj.Assignments[0]
 		if lastAssign.StaffMemberID != assignee.ID {
 			currAssigneeComputeID := svc.getComputeID(lastAssign.StaffMemberID)
-			log.Printf("INFO: project %d requires prior owner %s to claim, not %s",
-				proj.ID, currAssigneeComputeID, assignee.ComputingID)
+			logger.Info("project requires prior owner to claim", "priorOwner", currAssigneeComputeID, "assignee", assignee.ComputingID)
 			return fmt.Errorf("this project requires prior owner %s, not %s", currAssigneeComputeID, assignee.ComputingID)
 		}
 		return nil
@@ -818,7 +811,7 @@ func (svc *serviceContext) canAssignProject(assigneeID uint, assigner *jwtClaims
 	if proj.CurrentStep.OwnerType == 2 {
 		for _, a := range proj.Assignments {
 			if a.StaffMemberID == assignee.ID {
-				log.Printf("INFO: project %d requires a unique owner, but %s has previously claimed it", proj.ID, assignee.ComputingID)
+				logger.Info("project requires a unique owner but assignee has previously claimed it", "assignee", assignee.ComputingID)
 				return fmt.Errorf("this project requires a unique owner, and %s has previously owned it", assignee.ComputingID)
 			}
 		}
@@ -830,8 +823,7 @@ func (svc *serviceContext) canAssignProject(assigneeID uint, assigner *jwtClaims
 		origAssign := proj.Assignments[len(proj.Assignments)-1]
 		if origAssign.StaffMemberID != assignee.ID {
 			origAssigneeComputeID := svc.getComputeID(origAssign.StaffMemberID)
-			log.Printf("INFO: project %d requires original owner %s to claim, not %s",
-				proj.ID, origAssigneeComputeID, assignee.ComputingID)
+			logger.Info("project requires original owner to claim", "originalOwner", origAssigneeComputeID, "assignee", assignee.ComputingID)
 			return fmt.Errorf("this project can only be claimed by the original owner %s", origAssigneeComputeID)
 		}
 		return nil
@@ -842,11 +834,11 @@ func (svc *serviceContext) canAssignProject(assigneeID uint, assigner *jwtClaims
 		if assigneeRole == "supervisor" || assigneeRole == "admin" {
 			return nil
 		}
-		log.Printf("INFO: project %d requries a supervisor owner, and %s is not a supervisor", proj.ID, assignee.ComputingID)
+		logger.Info("project requires a supervisor owner and assignee is not a supervisor", "assignee", assignee.ComputingID)
 		return fmt.Errorf("this project requires a supervisor to claim, and %s is not one", assignee.ComputingID)
 	}
 
-	log.Printf("ERROR: unrecognized owner type: %d", proj.CurrentStep.OwnerType)
+	logger.Error("unrecognized owner type", "ownerType", proj.CurrentStep.OwnerType)
 	return fmt.Errorf("%s cannot claim this project (internal error)", assignee.ComputingID)
 }
 
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCanAssignProject(t *testing.T) {
+	ts := newWorkflowTestService(t)
+	student := &jwtClaims{UserID: 1, ComputeID: "scan1", Role: "student"}
+	supervisor := &jwtClaims{UserID: 4, ComputeID: "super4", Role: "supervisor"}
+
+	// assignments are most recent first; staff 1 was the original owner and staff 2 the prior owner
+	history := []*assignment{{StaffMemberID: 2}, {StaffMemberID: 3}, {StaffMemberID: 1}}
+	tests := []struct {
+		name      string
+		ownerType uint
+		assignee  uint
+		assigner  *jwtClaims
+		allowed   bool
+	}{
+		{"any owner", 0, 3, student, true},
+		{"prior owner", 1, 2, student, true},
+		{"not the prior owner", 1, 1, student, false},
+		{"unique owner", 2, 5, student, true},
+		{"previous owner is not unique", 2, 3, student, false},
+		{"original owner", 3, 1, student, true},
+		{"not the original owner", 3, 2, student, false},
+		{"supervisor owner", 4, 4, student, true},
+		{"student is not a supervisor", 4, 2, student, false},
+		{"supervisor assigns anyone", 1, 1, supervisor, true},
+		{"supervisor claims anything", 3, 4, student, true},
+		{"unknown assignee", 0, 99, student, false},
+	}
+	ts.trackSys.addStaff(5, "new5", 2)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			proj := project{CurrentStep: &step{Name: "Test", OwnerType: tc.ownerType}, Assignments: history}
+			err := ts.canAssignProject(context.Background(), tc.assignee, tc.assigner, &proj)
+			if tc.allowed && err != nil {
+				t.Errorf("expected staff %d to be assignable, got %s", tc.assignee, err.Error())
+			} else if !tc.allowed && err == nil {
+				t.Errorf("expected staff %d to be refused", tc.assignee)
+			}
+		})
+	}
+}
+
+// assignResponse is the response to an assign request
+type assignResponse struct {
+	OwnerID     uint         `json:"ownerID"`
+	Assignments []assignment `json:"assignments"`
+	Notes       []note       `json:"notes"`
+}
+
+func TestAssignProject(t *testing.T) {
+	admin := jwtClaims{UserID: 4, ComputeID: "super4", Role: "admin"}
+	assign := func(t *testing.T, ts *testService, proj *project, ownerID uint, claims jwtClaims) (*assignResponse, int) {
+		t.Helper()
+		projID := fmt.Sprintf("%d", proj.ID)
+		uid := fmt.Sprintf("%d", ownerID)
+		c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/assign/"+uid, nil, claims, "id", projID, "uid", uid)
+		ts.assignProject(c)
+		if resp.Code != http.StatusOK {
+			return nil, resp.Code
+		}
+		var out assignResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+			t.Fatalf("invalid assign response %s: %s", resp.Body.String(), err.Error())
+		}
+		return &out, resp.Code
+	}
+
+	t.Run("claim requires the original owner", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepError, StepPending, 1, 2)
+
+		if _, status := assign(t, ts, proj, 3, jwtClaims{UserID: 3, ComputeID: "qa3", Role: "student"}); status != http.StatusBadRequest {
+			t.Fatalf("expected claim by another student to fail, got %d", status)
+		}
+		out, status := assign(t, ts, proj, 1, scanner)
+		if status != http.StatusOK {
+			t.Fatalf("expected claim by the original owner, got %d", status)
+		}
+		if out.OwnerID != 1 || len(out.Assignments) != 3 {
+			t.Errorf("expected owner 1 with 3 assignments, got owner %d with %d", out.OwnerID, len(out.Assignments))
+		}
+		assigns := ts.loadAssignments(t, proj.ID)
+		if assigns[1].Status != StepReassigned || assigns[2].StaffMemberID != 1 || assigns[2].Status != StepPending {
+			t.Errorf("expected reassigned assignment and pending one for staff 1, got %+v", assigns)
+		}
+		if updated := ts.loadProject(t, proj.ID); updated.OwnerID == nil || *updated.OwnerID != 1 {
+			t.Errorf("expected project owner 1, got %v", updated.OwnerID)
+		}
+	})
+
+	t.Run("unique owner on an unclaimed step", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		created := ts.createProject(t, testUnitID, testStepProcess, StepFinished, 1)
+		ts.DB.Model(created).Select("CurrentStepID", "OwnerID").Updates(map[string]any{"current_step_id": testStepQA, "owner_id": nil})
+		proj := ts.loadProject(t, created.ID)
+
+		if _, status := assign(t, ts, proj, 1, scanner); status != http.StatusBadRequest {
+			t.Fatalf("expected the scanner to be refused QA, got %d", status)
+		}
+		if _, status := assign(t, ts, proj, 2, reviewer); status != http.StatusOK {
+			t.Fatalf("expected claim by a new owner, got %d", status)
+		}
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[0].Status != StepFinished || len(assigns) != 2 {
+			t.Errorf("expected the finished assignment to be left alone, got %+v", assigns)
+		}
+	})
+
+	t.Run("clear assignment", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepStarted, 1, 2)
+
+		out, status := assign(t, ts, proj, 0, admin)
+		if status != http.StatusOK {
+			t.Fatalf("expected assignment to be cleared, got %d", status)
+		}
+		if out.OwnerID != 0 || len(out.Notes) != 1 {
+			t.Errorf("expected no owner and a cancel note, got owner %d with %d notes", out.OwnerID, len(out.Notes))
+		}
+		if updated := ts.loadProject(t, proj.ID); updated.OwnerID != nil {
+			t.Errorf("expected no project owner, got %d", *updated.OwnerID)
+		}
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[1].Status != StepReassigned {
+			t.Errorf("expected reassigned assignment, got status %d", assigns[1].Status)
+		}
+	})
+}
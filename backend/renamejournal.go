@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rename journal status values
+const (
+	RenameInProgress = "in-progress"
+	RenameComplete   = "complete"
+	RenameRolledBack = "rolled-back"
+	RenameFailed     = "failed"
+)
+
+// A rename is done in two passes so files can swap names. Each pass is recorded in the journal before
+// it starts so the location of every file is known if the rename is interrupted:
+//
+//	stage:            original -> working dir with the new name
+//	restore:          working dir -> unit dir with the new name
+//	rollback-stage:   unit dir with the new name -> working dir (only needed once restore has started)
+//	rollback-restore: working dir -> original
+const (
+	renamePhaseStage           = "stage"
+	renamePhaseRestore         = "restore"
+	renamePhaseRollbackStage   = "rollback-stage"
+	renamePhaseRollbackRestore = "rollback-restore"
+)
+
+// renameJournal records a unit rename. It is saved before any file is moved and updated as the rename
+// moves through its phases. Incomplete journals are finished or rolled back when the service starts.
+type renameJournal struct {
+	ID        int64                `json:"id"`
+	UnitID    uint                 `json:"unitID"`
+	JobID     int64                `json:"jobID"`
+	Owner     string               `json:"owner"`
+	Status    string               `json:"status"`
+	Phase     string               `json:"phase"`
+	WorkDir   string               `json:"workDir"`
+	Entries   []renameJournalEntry `gorm:"serializer:json" json:"entries"`
+	Error     string               `json:"error,omitempty"`
+	CreatedAt time.Time            `json:"createdAt"`
+	UpdatedAt time.Time            `json:"updatedAt"`
+}
+
+// logger returns a logger for the rename with the job that runs it. An interrupted rename is recovered
+// without its job, so tgtJob may be nil
+func (journal *renameJournal) logger(tgtJob *job) *slog.Logger {
+	if tgtJob == nil {
+		return slog.Default().With("jobID", journal.JobID, "unitID", journal.UnitID, "journalID", journal.ID)
+	}
+	return tgtJob.logger().With("journalID", journal.ID)
+}
+
+type renameJournalEntry struct {
+	Original string `json:"original"`
+	Temp     string `json:"temp"`
+	Renamed  string `json:"renamed"`
+}
+
+type renameDryRunResponse struct {
+	Files     int             `json:"files"`
+	Conflicts []updateProblem `json:"conflicts"`
+}
+
+func pathExists(tgtPath string) bool {
+	_, err := os.Lstat(tgtPath)
+	return err == nil
+}
+
+// planRename checks the requested renames and builds the journal for them without touching any files.
+// Any conflicts that would stop the rename are returned; the rename must not be started if there are any
+func (svc *serviceContext) planRename(unitID uint, rnPost []renameRequest) (*renameJournal, []updateProblem) {
+	unit := padLeft(fmt.Sprintf("%d", unitID), 9)
+	unitDir := path.Join(svc.ImagesDir, unit)
+	journal := renameJournal{UnitID: unitID, Status: RenameInProgress, Phase: renamePhaseStage,
+		WorkDir: path.Join(svc.ImagesDir, "tmp", unit), Entries: make([]renameJournalEntry, 0, len(rnPost))}
+	problems := make([]updateProblem, 0)
+
+	if leftover, err := os.ReadDir(journal.WorkDir); err == nil && len(leftover) > 0 {
+		// files left here by an interrupted rename have their new names and exist nowhere else
+		problems = append(problems, updateProblem{File: journal.WorkDir,
+			Problem: fmt.Sprintf("has %d files from an interrupted rename. They must be restored before renaming again", len(leftover))})
+	}
+
+	originals := make(map[string]bool)
+	for _, rn := range rnPost {
+		originals[path.Clean(rn.Original)] = true
+	}
+	newNames := make(map[string]string)
+	for _, rn := range rnPost {
+		original := path.Clean(rn.Original)
+		if !strings.HasPrefix(original, unitDir+"/") {
+			problems = append(problems, updateProblem{File: rn.Original, Problem: fmt.Sprintf("is not in unit directory %s", unitDir)})
+			continue
+		}
+		if rn.NewName == "" || path.Base(rn.NewName) != rn.NewName || strings.HasPrefix(rn.NewName, ".") {
+			problems = append(problems, updateProblem{File: rn.Original, Problem: fmt.Sprintf("new name [%s] is not a valid file name", rn.NewName)})
+			continue
+		}
+		if !pathExists(original) {
+			problems = append(problems, updateProblem{File: rn.Original, Problem: "does not exist"})
+			continue
+		}
+
+		origDir, _ := path.Split(original)
+		renamed := path.Join(origDir, rn.NewName)
+		if other, found := newNames[renamed]; found {
+			problems = append(problems, updateProblem{File: rn.Original, Problem: fmt.Sprintf("would have the same name as %s", other)})
+			continue
+		}
+		newNames[renamed] = rn.Original
+		// the new name can only be in use by a file that is also being renamed
+		if pathExists(renamed) && !originals[renamed] {
+			problems = append(problems, updateProblem{File: rn.Original, Problem: fmt.Sprintf("%s already exists", renamed)})
+			continue
+		}
+		// files in unit subdirectories keep their subdirectory in the working directory so files with the
+		// same new name in different subdirectories do not collide there
+		journal.Entries = append(journal.Entries, renameJournalEntry{Original: original,
+			Temp: path.Join(journal.WorkDir, strings.TrimPrefix(renamed, unitDir+"/")), Renamed: renamed})
+	}
+	return &journal, problems
+}
+
+// applyRename moves the files in the journal to their new names. If a move fails or the unit lock
+// is lost, the rename is rolled back.
+func (svc *serviceContext) applyRename(journal *renameJournal, tgtJob *job, lock *unitLock) error {
+	logger := journal.logger(tgtJob)
+	for _, entry := range journal.Entries {
+		if err := lock.leaseLost(); err != nil {
+			return svc.rollbackRename(journal, tgtJob, err)
+		}
+		if err := svc.mkdirBelow(journal.WorkDir, path.Dir(entry.Temp)); err != nil {
+			return svc.rollbackRename(journal, tgtJob, fmt.Errorf("unable to create working directory for %s: %s", entry.Original, err.Error()))
+		}
+		logger.Info("stage file", "src", entry.Original, "dest", entry.Temp)
+		err := svc.Files.rename(entry.Original, entry.Temp)
+		svc.MetadataCache.invalidate(entry.Original)
+		if err != nil {
+			return svc.rollbackRename(journal, tgtJob, fmt.Errorf("unable to rename %s: %s", entry.Original, err.Error()))
+		}
+	}
+
+	if err := svc.setRenamePhase(journal, renamePhaseRestore); err != nil {
+		return svc.rollbackRename(journal, tgtJob, err)
+	}
+	return svc.finishRename(journal, tgtJob, lock)
+}
+
+// mkdirBelow creates a directory along with any of its missing parents below baseDir
+func (svc *serviceContext) mkdirBelow(baseDir string, tgtDir string) error {
+	if tgtDir == baseDir || pathExists(tgtDir) {
+		return nil
+	}
+	if err := svc.mkdirBelow(baseDir, path.Dir(tgtDir)); err != nil {
+		return err
+	}
+	return svc.Files.mkdir(tgtDir)
+}
+
+// finishRename moves the files in the working directory back to the unit directory with their new names.
+// It is used by applyRename and to replay a rename that was interrupted during the restore phase
+func (svc *serviceContext) finishRename(journal *renameJournal, tgtJob *job, lock *unitLock) error {
+	logger := journal.logger(tgtJob)
+	for _, entry := range journal.Entries {
+		if !pathExists(entry.Temp) {
+			// already moved by an earlier attempt
+			continue
+		}
+		if err := lock.leaseLost(); err != nil {
+			return svc.rollbackRename(journal, tgtJob, err)
+		}
+		// if renamed already exists, something is wrong! do not overwrite it
+		if pathExists(entry.Renamed) {
+			logger.Error("renamed file already exists", "path", entry.Renamed)
+			return svc.rollbackRename(journal, tgtJob, fmt.Errorf("renamed file %s already exists", entry.Renamed))
+		}
+
+		logger.Info("restore file", "src", entry.Temp, "dest", entry.Renamed)
+		err := svc.Files.rename(entry.Temp, entry.Renamed)
+		svc.MetadataCache.invalidate(entry.Renamed)
+		if err != nil {
+			return svc.rollbackRename(journal, tgtJob, fmt.Errorf("unable to restore %s from %s: %s", entry.Renamed, entry.Temp, err.Error()))
+		}
+		svc.jobFileProcessed(tgtJob, path.Base(entry.Renamed))
+	}
+
+	logger.Info("clean up working directory", "dir", journal.WorkDir)
+	if err := svc.Files.removeAll(journal.WorkDir); err != nil {
+		logger.Error("unable to clean up working directory", "dir", journal.WorkDir, "error", err.Error())
+	}
+	svc.endRenameJournal(journal, tgtJob, RenameComplete, "")
+
+	events := make([]fileEvent, 0, len(journal.Entries))
+	for _, entry := range journal.Entries {
+		events = append(events, fileEvent{UnitID: journal.UnitID, JobID: journal.JobID, ComputeID: journal.Owner, Action: FileEventRename,
+			File: entry.Original, Field: "name", OldValue: path.Base(entry.Original), NewValue: path.Base(entry.Renamed)})
+	}
+	svc.recordFileEvents(tgtJob.context(), events...)
+	return nil
+}
+
+// rollbackRename returns all files in the journal to their original names after a rename fails. The
+// returned error describes the original failure and the outcome of the rollback
+func (svc *serviceContext) rollbackRename(journal *renameJournal, tgtJob *job, cause error) error {
+	logger := journal.logger(tgtJob)
+	logger.Warn("roll back rename", "phase", journal.Phase, "cause", cause.Error())
+	problems := make([]string, 0)
+
+	// once the restore phase has started, files may be in the unit directory with their new names.
+	// Move them back to the working directory first so no original name is taken by a renamed file.
+	if journal.Phase == renamePhaseRestore || journal.Phase == renamePhaseRollbackStage {
+		if err := svc.setRenamePhase(journal, renamePhaseRollbackStage); err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, entry := range journal.Entries {
+			if pathExists(entry.Temp) || !pathExists(entry.Renamed) {
+				continue
+			}
+			logger.Info("roll back renamed file", "src", entry.Renamed, "dest", entry.Temp)
+			err := svc.Files.rename(entry.Renamed, entry.Temp)
+			svc.MetadataCache.invalidate(entry.Renamed)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("unable to move %s to %s: %s", entry.Renamed, entry.Temp, err.Error()))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		if err := svc.setRenamePhase(journal, renamePhaseRollbackRestore); err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, entry := range journal.Entries {
+			if !pathExists(entry.Temp) {
+				continue
+			}
+			if pathExists(entry.Original) {
+				problems = append(problems, fmt.Sprintf("unable to restore %s from %s; the original name is in use", entry.Original, entry.Temp))
+				continue
+			}
+			logger.Info("roll back staged file", "src", entry.Temp, "dest", entry.Original)
+			err := svc.Files.rename(entry.Temp, entry.Original)
+			svc.MetadataCache.invalidate(entry.Original)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("unable to restore %s from %s: %s", entry.Original, entry.Temp, err.Error()))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		msg := fmt.Sprintf("%s. Rollback failed: %s. Manual corrections are required.", cause.Error(), strings.Join(problems, "; "))
+		logger.Error("rename rollback failed", "error", msg)
+		svc.endRenameJournal(journal, tgtJob, RenameFailed, msg)
+		return errors.New(msg)
+	}
+
+	if err := svc.Files.removeAll(journal.WorkDir); err != nil {
+		logger.Error("unable to clean up working directory", "dir", journal.WorkDir, "error", err.Error())
+	}
+	msg := fmt.Sprintf("%s. All files have been returned to their original names.", cause.Error())
+	svc.endRenameJournal(journal, tgtJob, RenameRolledBack, msg)
+	return errors.New(msg)
+}
+
+func (svc *serviceContext) setRenamePhase(journal *renameJournal, phase string) error {
+	journal.Phase = phase
+	if err := svc.DB.Model(journal).Select("Phase").Updates(journal).Error; err != nil {
+		return fmt.Errorf("unable to record rename phase %s: %s", phase, err.Error())
+	}
+	return nil
+}
+
+func (svc *serviceContext) endRenameJournal(journal *renameJournal, tgtJob *job, status string, errMsg string) {
+	journal.Status = status
+	journal.Error = errMsg
+	if err := svc.DB.Model(journal).Select("Status", "Error").Updates(journal).Error; err != nil {
+		journal.logger(tgtJob).Error("unable to update rename journal status", "status", status, "error", err.Error())
+	}
+}
+
+// recoverRenames finishes or rolls back renames that were interrupted. A rename that was moving files
+// back to the unit directory is finished; one that was still staging files or rolling back is rolled back
+func (svc *serviceContext) recoverRenames() {
+	var journals []renameJournal
+	if err := svc.DB.Where("status=?", RenameInProgress).Order("id asc").Find(&journals).Error; err != nil {
+		slog.Error("unable to check for interrupted renames", "error", err.Error())
+		return
+	}
+	for _, journal := range journals {
+		logger := journal.logger(nil)
+		rawUnitID := fmt.Sprintf("%d", journal.UnitID)
+		lock, lockErr := svc.acquireUnitLock(context.Background(), rawUnitID, "system", "rename-recovery")
+		if lockErr != nil {
+			// another instance of the service is still working on the rename
+			logger.Info("skip recovery of rename", "error", lockErr.Message)
+			continue
+		}
+
+		logger.Warn("recover interrupted rename", "phase", journal.Phase)
+		var err error
+		if journal.Phase == renamePhaseRestore {
+			err = svc.finishRename(&journal, nil, lock)
+		} else {
+			err = svc.rollbackRename(&journal, nil, errors.New("rename was interrupted"))
+		}
+		outcome := "rename was interrupted and was completed when the service restarted"
+		if err != nil {
+			outcome = err.Error()
+		}
+		logger.Info("rename recovery complete", "outcome", outcome)
+
+		// the job that ran the rename was interrupted too
+		now := time.Now()
+		svc.DB.Model(&job{}).Where("id=? and status=?", journal.JobID, JobRunning).
+			Updates(map[string]any{"status": JobFailed, "error": outcome, "finished_at": now, "active_unit_id": nil})
+		svc.UnitIndex.invalidate(path.Join(svc.ImagesDir, padLeft(rawUnitID, 9)))
+		svc.releaseUnitLock(lock)
+	}
+}
+
+// dryRunRename reports any conflicts that would stop a rename without changing any files
+func (svc *serviceContext) dryRunRename(c *gin.Context, unitID uint, rnPost []renameRequest) {
+	journal, problems := svc.planRename(unitID, rnPost)
+	getLogger(c.Request.Context()).Info("dry run rename complete", "files", len(rnPost), "conflicts", len(problems))
+	c.JSON(http.StatusOK, renameDryRunResponse{Files: len(journal.Entries), Conflicts: problems})
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"log"
 	"net/http"
 	"slices"
@@ -140,7 +139,7 @@ func (svc *serviceContext) getRateReports(c *gin.Context) {
 
 	// all 3 reports need total masterfiles for all units in the date range from project
 	// using the supplied workflow. Get this info first and share it with all reports (its expensive)
-	unitImages, cntErr := svc.getUnitImagesCount(workflowID, startDate, endDate)
+	unitImages, cntErr := svc.getUnitImagesCount(c.Request.Context(), workflowID, startDate, endDate)
 	if cntErr != nil {
 		log.Printf("ERROR: unit master file counts for the rate reports: %s", cntErr.Error())
 		c.String(http.StatusInternalServerError, cntErr.Error())
@@ -411,7 +410,7 @@ func (svc *serviceContext) getRatesReport(workflowID, startDate, endDate string,
 	return resp, nil
 }
 
-func (svc *serviceContext) getUnitImagesCount(workflowID string, startDate string, endDate string) ([]unitImageRec, error) {
+func (svc *serviceContext) getUnitImagesCount(ctx context.Context, workflowID string, startDate string, endDate string) ([]unitImageRec, error) {
 	log.Printf("INFO: get unit masterfile counts")
 	var unitImages []unitImageRec
 	var unitIDs []int64
@@ -421,15 +420,9 @@ func (svc *serviceContext) getUnitImagesCount(workflowID string, startDate strin
 	}
 	log.Printf("INFO: %d units found for workflow %s between %s and %s", len(unitIDs), workflowID, startDate, endDate)
 	for _, uID := range unitIDs {
-		respBytes, reqErr := svc.getRequest(fmt.Sprintf("%s/units/%d", svc.TrackSys.API, uID))
-		if reqErr != nil {
-			log.Printf("ERROR: unable to get unit info for %d: %s", uID, reqErr.Message)
-			continue
-		}
-
-		var unitMD unitInfo
-		if err := json.Unmarshal(respBytes, &unitMD); err != nil {
-			log.Printf("ERROR: unable to parse unit info for %d: %s", uID, err.Error())
+		unitMD, err := svc.TrackSysAPI.unit(ctx, uID)
+		if err != nil {
+			log.Printf("ERROR: unable to get unit info for %d: %s", uID, err.Error())
 			continue
 		}
 		unitImages = append(unitImages, unitImageRec{ID: uID, ImageCount: unitMD.MasterFileCount})
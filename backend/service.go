@@ -1,11 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"net"
 	"net/http"
@@ -18,6 +17,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sys/unix"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -25,20 +25,32 @@ import (
 
 // ServiceContext contains common data used by all handlers
 type serviceContext struct {
-	Version              string
-	ServiceURL           string
-	ImagesDir            string
-	ScanDir              string
-	FinalizeDir          string
-	IIIFURL              string
-	TrackSys             tracksysURLs
-	HTTPClient           *http.Client
-	DB                   *gorm.DB
-	DevAuthUser          string
-	JWTKey               string
-	BatchSize            int
-	batchMutex           sync.Mutex
-	BatchUnitsInProgress []string
+	Version         string
+	ServiceURL      string
+	ImagesDir       string
+	ScanDir         string
+	FinalizeDir     string
+	IIIFURL         string
+	TrackSys        tracksysURLs
+	TrackSysAPI     trackSysAPI
+	Jobs            jobsAPI
+	HTTPClient      *http.Client
+	DB              *gorm.DB
+	MigrationsTable string
+	DevAuthUser     string
+	JWTKey          string
+	TrashDays       int
+	Checksum        string
+	BatchSize       int
+	jobSignal       chan bool
+	jobMutex        sync.Mutex
+	jobEvents       *jobEventBroker
+	drain           *drainTracker
+	ExifTool        metadataTool
+	ImageTool       imageTool
+	Files           fileMover
+	MetadataCache   *metadataCache
+	UnitIndex       *unitIndex
 }
 
 // RequestError contains http status code and message for a failed HTTP request
@@ -47,18 +59,42 @@ type RequestError struct {
 	Message    string
 }
 
+func (re *RequestError) Error() string {
+	return re.Message
+}
+
+// requestErrorStatus returns the HTTP status for an error, using the status of a RequestError if there is one
+func requestErrorStatus(err error) int {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode
+	}
+	return http.StatusInternalServerError
+}
+
 // InitializeService sets up the service context for all API handlers
 func initializeService(version string, cfg *configData) *serviceContext {
 	ctx := serviceContext{Version: version,
-		ImagesDir:   cfg.imagesDir,
-		IIIFURL:     cfg.iiifURL,
-		ScanDir:     cfg.scanDir,
-		FinalizeDir: cfg.finalizeDir,
-		JWTKey:      cfg.jwtKey,
-		ServiceURL:  cfg.serviceURL,
-		TrackSys:    cfg.tracksys,
-		DevAuthUser: cfg.devAuthUser,
-		BatchSize:   10} // for all parallel processing. number of images processed per batch
+		ImagesDir:       cfg.imagesDir,
+		IIIFURL:         cfg.iiifURL,
+		ScanDir:         cfg.scanDir,
+		FinalizeDir:     cfg.finalizeDir,
+		JWTKey:          cfg.jwtKey,
+		ServiceURL:      cfg.serviceURL,
+		TrackSys:        cfg.tracksys,
+		DevAuthUser:     cfg.devAuthUser,
+		MigrationsTable: cfg.migrationsTable,
+		TrashDays:       cfg.trashDays,
+		Checksum:        cfg.checksum,
+		BatchSize:       10} // for all parallel processing. number of images processed per batch
+
+	if cfg.migrate {
+		if err := runMigrations(cfg.db, cfg.migrationsTable); err != nil {
+			log.Fatal(err.Error())
+		}
+	} else {
+		log.Printf("INFO: startup migrations are disabled")
+	}
 
 	log.Printf("INFO: connecting to DB...")
 	connectStr := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
@@ -69,19 +105,37 @@ func initializeService(version string, cfg *configData) *serviceContext {
 	}
 	ctx.DB = gdb
 	log.Printf("INFO: DB Connection established")
-
-	log.Printf("INFO: create tmp directory for working files...")
-	tmpDir := path.Join(ctx.ImagesDir, "tmp")
-	_, existErr := os.Stat(tmpDir)
-	if existErr != nil {
-		err := os.Mkdir(tmpDir, 0777)
-		if err != nil {
-			log.Printf("ERROR: unable to create tmp directory")
-			log.Fatalf("unable to make tmp dir %s: %s", tmpDir, err.Error())
+	prometheus.MustRegister(newDBStatsCollector(&ctx))
+	ctx.startLockReaper()
+	ctx.jobEvents = newJobEventBroker()
+	ctx.drain = newDrainTracker()
+
+	// tmp holds working files and trash holds deleted master files until they are purged
+	for _, dirName := range []string{"tmp", "trash"} {
+		log.Printf("INFO: create %s directory...", dirName)
+		tgtDir := path.Join(ctx.ImagesDir, dirName)
+		_, existErr := os.Stat(tgtDir)
+		if existErr != nil {
+			err := os.Mkdir(tgtDir, 0777)
+			if err != nil {
+				log.Printf("ERROR: unable to create %s directory", dirName)
+				log.Fatalf("unable to make %s dir %s: %s", dirName, tgtDir, err.Error())
+			}
+			log.Printf("INFO: %s directory created", dirName)
+		} else {
+			log.Printf("INFO: %s directory already exists", dirName)
 		}
-		log.Printf("INFO: tmp directory created")
-	} else {
-		log.Printf("INFO: tmp directory already exists")
+	}
+
+	ctx.ExifTool = newExifToolPool(cfg.exifWorkers, time.Duration(cfg.exifTimeout)*time.Second)
+	ctx.ImageTool = magickTool{}
+	ctx.Files = localFileMover{}
+	ctx.MetadataCache = newMetadataCache(cfg.metaCacheSize, cfg.metaCacheFile)
+	ctx.UnitIndex = newUnitIndex()
+	ctx.recoverRenames()
+	ctx.reportStrandedRenames()
+	if cfg.watchDirs {
+		ctx.startDirWatcher(ctx.ScanDir, ctx.ImagesDir)
 	}
 
 	log.Printf("INFO: create HTTP client...")
@@ -103,6 +157,15 @@ func initializeService(version string, cfg *configData) *serviceContext {
 		Timeout:   5 * time.Second,
 	}
 	log.Printf("INFO: HTTP Client created")
+	if cfg.mockTrackSys {
+		startMockTrackSys(cfg.mockPort, cfg.serviceURL)
+	}
+	ctx.TrackSysAPI = newTrackSysClient(cfg.tracksys.API, ctx.HTTPClient, time.Duration(cfg.tracksysCacheTTL)*time.Second)
+	ctx.Jobs = &jobsClient{jobsURL: cfg.tracksys.Jobs, httpClient: ctx.HTTPClient}
+
+	// jobs left pending by the last shutdown are claimed as soon as the workers start, so they are
+	// started once all dependencies are set and any interrupted renames have been recovered
+	ctx.startJobWorkers(cfg.jobWorkers)
 	return &ctx
 }
 
@@ -147,6 +210,27 @@ func (svc *serviceContext) healthCheck(c *gin.Context) {
 		serviceOK = false
 	}
 
+	// an open breaker means tracksys calls are failing fast. The service still runs, but with limited function
+	hcMap["tracksys"] = hcResp{Healthy: true}
+	tsStatus := svc.TrackSysAPI.status()
+	if tsStatus.State != breakerClosed.String() {
+		hcMap["tracksys"] = hcResp{Healthy: tsStatus.State != breakerOpen.String(),
+			Message: fmt.Sprintf("circuit breaker %s after %d failures", tsStatus.State, tsStatus.Failures)}
+	}
+
+	hcMap["schema"] = hcResp{Healthy: true}
+	schema, err := svc.getSchemaStatus()
+	if err != nil {
+		hcMap["schema"] = hcResp{Healthy: false, Message: err.Error()}
+	} else if schema.Dirty {
+		hcMap["schema"] = hcResp{Healthy: false, Message: fmt.Sprintf("version %d is dirty", schema.Version)}
+		serviceOK = false
+	} else if schema.Version != schema.Latest {
+		hcMap["schema"] = hcResp{Healthy: false, Message: fmt.Sprintf("version %d; expected %d", schema.Version, schema.Latest)}
+	} else {
+		hcMap["schema"] = hcResp{Healthy: true, Message: fmt.Sprintf("version %d", schema.Version)}
+	}
+
 	hcMap["service"] = hcResp{Healthy: serviceOK}
 
 	c.JSON(http.StatusOK, hcMap)
@@ -175,54 +259,15 @@ func (svc *serviceContext) getVersion(c *gin.Context) {
 	vMap := make(map[string]string)
 	vMap["version"] = Version
 	vMap["build"] = build
-	c.JSON(http.StatusOK, vMap)
-}
-
-func (svc *serviceContext) isBatchInProgress(tgtUnitID string) bool {
-	svc.batchMutex.Lock()
-	defer svc.batchMutex.Unlock()
-	for _, uid := range svc.BatchUnitsInProgress {
-		if uid == tgtUnitID {
-			return true
-		}
+	vMap["schema"] = "unknown"
+	if schema, err := svc.getSchemaStatus(); err == nil {
+		vMap["schema"] = fmt.Sprintf("%d", schema.Version)
 	}
-	return false
-}
-func (svc *serviceContext) addBatchProcess(tgtUnitID string) {
-	svc.batchMutex.Lock()
-	defer svc.batchMutex.Unlock()
-	svc.BatchUnitsInProgress = append(svc.BatchUnitsInProgress, tgtUnitID)
-	log.Printf("INFO: added unit %s to batch processing list: %+v", tgtUnitID, svc.BatchUnitsInProgress)
-}
-func (svc *serviceContext) removeBatchProcess(tgtUnitID string) {
-	svc.batchMutex.Lock()
-	defer svc.batchMutex.Unlock()
-	tgtIdx := -1
-	for idx, uid := range svc.BatchUnitsInProgress {
-		if uid == tgtUnitID {
-			tgtIdx = idx
-			break
-		}
-	}
-
-	lastIdx := len(svc.BatchUnitsInProgress) - 1
-	if tgtIdx == lastIdx {
-		// this is the last item in the list; just shorten the slice
-		svc.BatchUnitsInProgress = svc.BatchUnitsInProgress[:lastIdx]
-	} else {
-		// copy the last element into the target index, then shorten the slice
-		svc.BatchUnitsInProgress[tgtIdx] = svc.BatchUnitsInProgress[lastIdx]
-		svc.BatchUnitsInProgress = svc.BatchUnitsInProgress[:lastIdx]
-	}
-	log.Printf("INFO: removed unit %s from batch processing list: %+v", tgtUnitID, svc.BatchUnitsInProgress)
+	c.JSON(http.StatusOK, vMap)
 }
 
 func (svc *serviceContext) getConfig(c *gin.Context) {
 	log.Printf("INFO: get service configuration")
-	type ocrData struct {
-		Hints     []ocrHint         `json:"hints"`
-		Languages []ocrLanguageHint `json:"languages"`
-	}
 	type cfgData struct {
 		TrackSysURL    string          `json:"tracksysURL"`
 		QAImageDir     string          `json:"qaImageDir"`
@@ -235,7 +280,7 @@ func (svc *serviceContext) getConfig(c *gin.Context) {
 		Categories     []category      `json:"categories"`
 		ContainerTypes []containerType `json:"containerTypes"`
 		Problems       []problem       `json:"problems"`
-		OCR            ocrData         `json:"ocr"`
+		OCR            ocrInfo         `json:"ocr"`
 		Steps          []string        `json:"steps"`
 	}
 	resp := cfgData{
@@ -245,66 +290,49 @@ func (svc *serviceContext) getConfig(c *gin.Context) {
 	}
 
 	log.Printf("INFO: load staff members")
-	rawResp, err := svc.getRequest(fmt.Sprintf("%s/staff", svc.TrackSys.API))
+	staff, err := svc.TrackSysAPI.staff(c.Request.Context())
 	if err != nil {
-		log.Printf("ERROR: unable to get staff members: %s", err.Message)
-		c.String(err.StatusCode, err.Message)
-		return
-	}
-	if err := json.Unmarshal(rawResp, &resp.Staff); err != nil {
-		log.Printf("ERROR: unable to parse staff members: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		log.Printf("ERROR: unable to get staff members: %s", err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
 	}
+	resp.Staff = staff
 
 	log.Printf("INFO: load customers")
-	rawResp, err = svc.getRequest(fmt.Sprintf("%s/customers", svc.TrackSys.API))
+	customers, err := svc.TrackSysAPI.customers(c.Request.Context())
 	if err != nil {
-		log.Printf("ERROR: unable to get customers: %s", err.Message)
-		c.String(err.StatusCode, err.Message)
-		return
-	}
-	if err := json.Unmarshal(rawResp, &resp.Customers); err != nil {
-		log.Printf("ERROR: unable to parse customers: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		log.Printf("ERROR: unable to get customers: %s", err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
 	}
+	resp.Customers = customers
 
 	log.Printf("INFO: load agencies")
-	rawResp, err = svc.getRequest(fmt.Sprintf("%s/agencies", svc.TrackSys.API))
+	agencies, err := svc.TrackSysAPI.agencies(c.Request.Context())
 	if err != nil {
-		log.Printf("ERROR: unable to get agencies: %s", err.Message)
-		c.String(err.StatusCode, err.Message)
-		return
-	}
-	if err := json.Unmarshal(rawResp, &resp.Agencies); err != nil {
-		log.Printf("ERROR: unable to parse agencies: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		log.Printf("ERROR: unable to get agencies: %s", err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
 	}
+	resp.Agencies = agencies
 
 	log.Printf("INFO: load container types")
-	cTypes, ctErr := svc.getContainerTypes()
-	if ctErr != nil {
-		log.Printf("ERROR: %s", ctErr)
-		c.String(http.StatusInternalServerError, ctErr.Error())
+	cTypes, err := svc.TrackSysAPI.containerTypes(c.Request.Context())
+	if err != nil {
+		log.Printf("ERROR: unable to get container types: %s", err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
-	} else {
-		resp.ContainerTypes = cTypes
 	}
+	resp.ContainerTypes = cTypes
 
 	log.Printf("INFO: load ocr hints")
-	rawResp, err = svc.getRequest(fmt.Sprintf("%s/ocr", svc.TrackSys.API))
+	ocr, err := svc.TrackSysAPI.ocr(c.Request.Context())
 	if err != nil {
-		log.Printf("ERROR: unable to get ocr info: %s", err.Message)
-		c.String(err.StatusCode, err.Message)
-		return
-	}
-	if err := json.Unmarshal(rawResp, &resp.OCR); err != nil {
-		log.Printf("ERROR: unable to ocr: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		log.Printf("ERROR: unable to get ocr info: %s", err.Error())
+		c.String(requestErrorStatus(err), err.Error())
 		return
 	}
+	resp.OCR = *ocr
 
 	log.Printf("INFO: load categories")
 	dbResp := svc.DB.Order("name asc").Find(&resp.Categories)
@@ -353,29 +381,19 @@ func (svc *serviceContext) getConfig(c *gin.Context) {
 }
 
 func (svc *serviceContext) getContainerTypes() ([]containerType, error) {
-	rawResp, err := svc.getRequest(fmt.Sprintf("%s/containertypes", svc.TrackSys.API))
+	out, err := svc.TrackSysAPI.containerTypes(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("unable to get container types: %s", err.Message)
+		return nil, fmt.Errorf("unable to get container types: %s", err.Error())
 	}
-	var out []containerType
-	if err := json.Unmarshal(rawResp, &out); err != nil {
-		return nil, err
-	}
-
 	return out, nil
 }
 
 func (svc *serviceContext) getStaff(staffID uint) (*staffMember, error) {
-	respBytes, reqErr := svc.getRequest(fmt.Sprintf("%s/staff/lookup?id=%d", svc.TrackSys.API, staffID))
-	if reqErr != nil {
-		return nil, fmt.Errorf("staff %d not found", staffID)
-	}
-
-	var sm staffMember
-	if err := json.Unmarshal(respBytes, &sm); err != nil {
-		return nil, err
+	sm, err := svc.TrackSysAPI.staffByID(context.Background(), staffID)
+	if err != nil {
+		return nil, fmt.Errorf("staff %d not found: %s", staffID, err.Error())
 	}
-	return &sm, nil
+	return sm, nil
 }
 
 func (svc *serviceContext) getComputeID(staffID uint) string {
@@ -396,48 +414,6 @@ func padLeft(str string, tgtLen int) string {
 	}
 }
 
-func (svc *serviceContext) getRequest(url string) ([]byte, *RequestError) {
-	log.Printf("GET request: %s", url)
-	startTime := time.Now()
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Content-type", "application/json")
-	httpClient := svc.HTTPClient
-	rawResp, rawErr := httpClient.Do(req)
-	resp, err := handleAPIResponse(url, rawResp, rawErr)
-	elapsed := time.Since(startTime)
-	elapsedMS := int64(elapsed / time.Millisecond)
-
-	if err != nil {
-		log.Printf("ERROR: Failed response from GET %s - %d:%s. Elapsed Time: %d (ms)",
-			url, err.StatusCode, err.Message, elapsedMS)
-	} else {
-		log.Printf("Successful response from GET %s. Elapsed Time: %d (ms)", url, elapsedMS)
-	}
-	return resp, err
-}
-
-func (svc *serviceContext) postJobsRequest(url string, payload any, jwt string) ([]byte, *RequestError) {
-	log.Printf("INFO: jobs post request: %s", url)
-	startTime := time.Now()
-	b, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(b))
-	req.Header.Add("Content-type", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
-	httpClient := svc.HTTPClient
-	rawResp, rawErr := httpClient.Do(req)
-	resp, err := handleAPIResponse(url, rawResp, rawErr)
-	elapsed := time.Since(startTime)
-	elapsedMS := int64(elapsed / time.Millisecond)
-
-	if err != nil {
-		log.Printf("ERROR: Failed response from POST %s - %d:%s. Elapsed Time: %d (ms)",
-			url, err.StatusCode, err.Message, elapsedMS)
-	} else {
-		log.Printf("Successful response from POST %s. Elapsed Time: %d (ms)", url, elapsedMS)
-	}
-	return resp, err
-}
-
 func handleAPIResponse(logURL string, resp *http.Response, err error) ([]byte, *RequestError) {
 	if err != nil {
 		status := http.StatusBadRequest
@@ -472,18 +448,3 @@ func exists(tgtDir string) bool {
 	}
 	return true
 }
-
-func findFile(basePath, tgtFileName string) string {
-	fullPath := ""
-	filepath.WalkDir(basePath, func(path string, entry fs.DirEntry, err error) error {
-		if err != nil || entry.IsDir() {
-			return nil
-		}
-		if entry.Name() == tgtFileName {
-			fullPath = path
-			return filepath.SkipAll //
-		}
-		return nil
-	})
-	return fullPath
-}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// grace period for requests and event streams to finish once all operations have drained
+const shutdownGracePeriod = 10 * time.Second
+
+// drainTracker tracks the unit operations and jobs that are in progress so a shutdown can wait for
+// them to finish. Once draining starts no new operations can begin.
+type drainTracker struct {
+	mutex    sync.Mutex
+	draining bool
+	nextID   int64
+	active   map[int64]string
+	idle     *sync.Cond
+	stopping chan bool
+}
+
+// drainExemptRoutes are mutating requests that are still accepted while draining. These are the
+// callbacks from dpg-jobs; they only update the DB and refusing them would leave projects stuck.
+var drainExemptRoutes = map[string]bool{
+	"/api/projects/:id/done": true,
+	"/api/projects/:id/fail": true,
+}
+
+func newDrainTracker() *drainTracker {
+	dt := drainTracker{active: make(map[int64]string), stopping: make(chan bool)}
+	dt.idle = sync.NewCond(&dt.mutex)
+	return &dt
+}
+
+// begin registers a new operation. An error is returned if the service is shutting down.
+// The returned function must be called when the operation is done
+func (dt *drainTracker) begin(description string) (func(), *RequestError) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	if dt.draining {
+		return nil, &RequestError{StatusCode: http.StatusServiceUnavailable, Message: "the service is shutting down; please try again shortly"}
+	}
+	return dt.add(description), nil
+}
+
+// track registers an operation that is part of a request or job that was already accepted, so it is
+// registered even while draining. The returned function must be called when the operation is done
+func (dt *drainTracker) track(description string) func() {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	return dt.add(description)
+}
+
+func (dt *drainTracker) add(description string) func() {
+	dt.nextID++
+	opID := dt.nextID
+	dt.active[opID] = description
+	return func() {
+		dt.mutex.Lock()
+		defer dt.mutex.Unlock()
+		delete(dt.active, opID)
+		if len(dt.active) == 0 {
+			dt.idle.Broadcast()
+		}
+	}
+}
+
+func (dt *drainTracker) isDraining() bool {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	return dt.draining
+}
+
+// drain stops new operations from starting and waits for the active ones to finish. If they have not
+// finished by the deadline, the descriptions of the ones still running are returned
+func (dt *drainTracker) drain(deadline time.Time) []string {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	dt.draining = true
+
+	// wake up at the deadline even if no operation finishes
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		dt.mutex.Lock()
+		dt.idle.Broadcast()
+		dt.mutex.Unlock()
+	})
+	defer timer.Stop()
+	for len(dt.active) > 0 && time.Now().Before(deadline) {
+		log.Printf("INFO: waiting for %d operations to finish", len(dt.active))
+		dt.idle.Wait()
+	}
+
+	remaining := make([]string, 0, len(dt.active))
+	for _, desc := range dt.active {
+		remaining = append(remaining, desc)
+	}
+	sort.Strings(remaining)
+	return remaining
+}
+
+// drainGuard refuses requests that change units or projects once the service has started to shut down.
+// Read requests are still served so clients can follow the progress of the operations being drained
+func (svc *serviceContext) drainGuard(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	if svc.drain.isDraining() && !drainExemptRoutes[c.FullPath()] {
+		log.Printf("WARNING: %s %s refused; the service is shutting down", c.Request.Method, c.Request.URL.Path)
+		c.Header("Retry-After", "60")
+		c.String(http.StatusServiceUnavailable, "the service is shutting down; please try again shortly")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// shutdown drains the in-flight unit operations and jobs, stops the HTTP server and then shuts down
+// the exiftool processes and saves the metadata cache
+func (svc *serviceContext) shutdown(srv *http.Server, drainTimeout time.Duration) {
+	log.Printf("INFO: shutdown requested; drain in-flight operations for up to %s", drainTimeout)
+	remaining := svc.drain.drain(time.Now().Add(drainTimeout))
+	if len(remaining) > 0 {
+		log.Printf("WARNING: %d operations did not finish before the drain deadline and will be interrupted:", len(remaining))
+		for _, desc := range remaining {
+			log.Printf("WARNING:    %s", desc)
+		}
+	} else {
+		log.Printf("INFO: all operations have finished")
+	}
+
+	// end any job event streams; they would otherwise hold the connection open until the grace period ends
+	close(svc.drain.stopping)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("WARNING: http server did not shut down cleanly: %s", err.Error())
+	}
+
+	svc.ExifTool.shutdown()
+	svc.MetadataCache.save()
+	log.Printf("INFO: shutdown complete")
+}
+
+var renameWorkDirPattern = regexp.MustCompile(`^\d{9}$`)
+
+// reportStrandedRenames looks for rename working directories that are still in ImagesDir/tmp after the
+// rename journals have been recovered. These were left by a rename that could not be rolled back or one
+// that has no journal, so the files in them must be restored by hand.
+func (svc *serviceContext) reportStrandedRenames() {
+	tmpDir := path.Join(svc.ImagesDir, "tmp")
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		log.Printf("ERROR: unable to check %s for interrupted renames: %s", tmpDir, err.Error())
+		return
+	}
+
+	stranded := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !renameWorkDirPattern.MatchString(entry.Name()) {
+			continue
+		}
+		workDir := path.Join(tmpDir, entry.Name())
+		var unitID uint
+		fmt.Sscanf(entry.Name(), "%d", &unitID)
+		if svc.unitIsLocked(unitID) {
+			// another instance of the service is working on the unit
+			continue
+		}
+		files, _ := os.ReadDir(workDir)
+		if len(files) == 0 {
+			continue
+		}
+		stranded++
+		log.Printf("WARNING: %s was left by an interrupted rename of unit %d; %d files must be moved back to %s",
+			workDir, unitID, len(files), path.Join(svc.ImagesDir, entry.Name()))
+	}
+	if stranded > 0 {
+		log.Printf("WARNING: found %d interrupted renames in %s", stranded, tmpDir)
+	} else {
+		log.Printf("INFO: no interrupted renames found in %s", tmpDir)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+)
+
+// Unit directories used by step validations
+const (
+	StepDirScan     = "scan"
+	StepDirImages   = "images"
+	StepDirFinalize = "finalize"
+)
+
+// stepValidation declares the checks made when a step is finished and where the unit files are moved
+// once the checks pass. Directory is where the unit files are while the step is worked. MoveTo is empty
+// when the files stay where they are. ClearLocation clears the temporary box and folder held in the keywords
+// and location of each image when it is prepped for finalization. Each step has one; new workflows only need
+// rows added for their steps.
+type stepValidation struct {
+	ID                    uint   `json:"id"`
+	StepID                uint   `json:"stepID"`
+	Directory             string `json:"directory"`
+	DirectoryExists       bool   `json:"directoryExists"`
+	OnlyTif               bool   `json:"onlyTif"`
+	AllowNotes            bool   `json:"allowNotes"`
+	SequenceContiguous    bool   `json:"sequenceContiguous"`
+	TitleRequired         bool   `json:"titleRequired"`
+	LocationRequired      bool   `json:"locationRequired"`
+	ComponentRequired     bool   `json:"componentRequired"`
+	ContainerTypeRequired bool   `json:"containerTypeRequired"`
+	FinalizePrep          bool   `json:"finalizePrep"`
+	ClearLocation         bool   `json:"clearLocation"`
+	MoveTo                string `json:"moveTo"`
+	UpdateImageCount      bool   `json:"updateImageCount"`
+}
+
+// checksImages is true if the step checks anything more than the existence of the unit directory
+func (sv *stepValidation) checksImages() bool {
+	return sv.OnlyTif || sv.SequenceContiguous || sv.checksHeaders()
+}
+
+// checksHeaders is true if the step requires any image metadata
+func (sv *stepValidation) checksHeaders() bool {
+	return sv.TitleRequired || sv.LocationRequired || sv.ComponentRequired
+}
+
+// getStepValidation returns the validation settings for a step. A step without any gets the checks for a
+// normal step in the images directory; error steps only check that the directory exists
+func (svc *serviceContext) getStepValidation(tgtStep *step) *stepValidation {
+	var out stepValidation
+	err := svc.DB.Where("step_id=?", tgtStep.ID).Limit(1).Find(&out).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get validations for step %d: %s", tgtStep.ID, err.Error())
+	}
+	if err == nil && out.ID > 0 {
+		return &out
+	}
+
+	log.Printf("WARNING: step %d %s has no validations; use the defaults", tgtStep.ID, tgtStep.Name)
+	out = stepValidation{StepID: tgtStep.ID, Directory: StepDirImages, DirectoryExists: true, UpdateImageCount: true}
+	if tgtStep.StepType != 2 {
+		out.OnlyTif = true
+		out.SequenceContiguous = true
+	}
+	return &out
+}
+
+// stepBaseDir returns the configured directory for a step validation directory name
+func (svc *serviceContext) stepBaseDir(dirName string) (string, error) {
+	switch dirName {
+	case StepDirScan:
+		return svc.ScanDir, nil
+	case StepDirImages:
+		return svc.ImagesDir, nil
+	case StepDirFinalize:
+		return svc.FinalizeDir, nil
+	}
+	return "", fmt.Errorf("%s is not a valid step directory", dirName)
+}
+
+// stepUnitDir returns the unit directory within a step validation directory
+func (svc *serviceContext) stepUnitDir(dirName string, unitID uint) (string, error) {
+	baseDir, err := svc.stepBaseDir(dirName)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(baseDir, padLeft(fmt.Sprintf("%d", unitID), 9)), nil
+}
@@ -0,0 +1,287 @@
+// gen writes the TIFF fixtures used to compare the native metadata reader with exiftool.
+// Run it from the backend directory with: go run ./testdata/tiff/gen.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"unicode/utf16"
+)
+
+type iptcValue struct {
+	record  byte
+	dataset byte
+	value   string
+}
+
+type fixture struct {
+	name       string
+	order      binary.ByteOrder
+	width      int
+	height     int
+	resolution [2]uint32
+	iptc       []iptcValue
+	photoshop  bool // IPTC in the Photoshop image resources rather than the IPTC-NAA tag
+	icc        []byte
+}
+
+func main() {
+	fixtures := []fixture{
+		{
+			// Latin-1 IPTC in the IPTC-NAA tag with a repeated headline and a numeric owner ID
+			name: "le_iptc", order: binary.LittleEndian, width: 8, height: 8, resolution: [2]uint32{300, 1},
+			iptc: []iptcValue{
+				{2, 0, "\x00\x04"},
+				{2, 105, "Draft headline"},
+				{2, 105, "Caf\xe9 menu, 1923"},
+				{2, 120, "Menu from the Caf\xe9 de la Paix"},
+				{2, 25, "Box 3"},
+				{2, 27, "Folder 12"},
+				{2, 92, "Shelf 4"},
+				{2, 188, "12345"},
+				{2, 225, "needs review"},
+			},
+			icc: iccV2("sRGB IEC61966-2.1"),
+		},
+		{
+			// UTF-8 IPTC in the Photoshop image resources with repeated list datasets
+			name: "be_photoshop", order: binary.BigEndian, width: 64, height: 72, resolution: [2]uint32{600, 1},
+			photoshop: true,
+			iptc: []iptcValue{
+				{1, 0, "\x00\x04"},
+				{1, 90, "\x1b%G"},
+				{2, 0, "\x00\x04"},
+				{2, 105, "Ångström’s “notes”"},
+				{2, 120, "Field notebook – volume 2"},
+				{2, 25, "Box 1"},
+				{2, 25, "Box 2"},
+				{2, 27, "Folder 1"},
+				{2, 27, "Folder 2"},
+				{2, 27, "Folder 3"},
+				{2, 188, "uva-lib:2534961"},
+			},
+			icc: iccV4(map[string]string{"enUS": "Adobe RGB (1998)", "frFR": "Adobe RVB (1998)"}),
+		},
+		{
+			// Latin-1 IPTC with repeated keywords and a fractional resolution, large enough for whole kB
+			name: "latin1_fraction", order: binary.LittleEndian, width: 120, height: 100, resolution: [2]uint32{1200, 7},
+			iptc: []iptcValue{
+				{2, 0, "\x00\x04"},
+				{2, 105, "R\xe9sum\xe9 of accounts"},
+				{2, 25, "Bo\xeete 1"},
+				{2, 25, "Bo\xeete 2"},
+				{2, 27, "Dossier \xe0 part"},
+			},
+		},
+		{name: "inf_resolution", order: binary.BigEndian, width: 1, height: 1, resolution: [2]uint32{72, 0}},
+		{name: "undef_resolution", order: binary.LittleEndian, width: 1, height: 1, resolution: [2]uint32{0, 0}},
+	}
+	for _, f := range fixtures {
+		tgtFile := path.Join("testdata", "tiff", f.name+".tif")
+		if err := os.WriteFile(tgtFile, f.tiff(), 0664); err != nil {
+			log.Fatalf("unable to write %s: %s", tgtFile, err.Error())
+		}
+		log.Printf("wrote %s", tgtFile)
+	}
+}
+
+type ifdEntry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	data     []byte
+}
+
+// tiff builds an uncompressed 8-bit grayscale TIFF with a single strip
+func (f *fixture) tiff() []byte {
+	short := func(v uint16) []byte {
+		b := make([]byte, 2)
+		f.order.PutUint16(b, v)
+		return b
+	}
+	long := func(vals ...uint32) []byte {
+		b := make([]byte, 4*len(vals))
+		for i, v := range vals {
+			f.order.PutUint32(b[i*4:], v)
+		}
+		return b
+	}
+
+	pixels := make([]byte, f.width*f.height)
+	for i := range pixels {
+		pixels[i] = byte(i * 7)
+	}
+	entries := []ifdEntry{
+		{256, 3, 1, short(uint16(f.width))},
+		{257, 3, 1, short(uint16(f.height))},
+		{258, 3, 1, short(8)},
+		{259, 3, 1, short(1)},
+		{262, 3, 1, short(1)},
+		{273, 4, 1, nil}, // strip offset is set once the layout is known
+		{277, 3, 1, short(1)},
+		{278, 3, 1, short(uint16(f.height))},
+		{279, 4, 1, long(uint32(len(pixels)))},
+		{282, 5, 1, long(f.resolution[0], f.resolution[1])},
+		{283, 5, 1, long(f.resolution[0], f.resolution[1])},
+		{296, 3, 1, short(2)},
+	}
+	if len(f.iptc) > 0 {
+		iptc := iptcBlock(f.iptc)
+		if f.photoshop {
+			entries = append(entries, ifdEntry{34377, 7, uint32(len(photoshopBlock(iptc))), photoshopBlock(iptc)})
+		} else {
+			// Photoshop writes the IPTC-NAA tag as LONG, so the block is padded to a multiple of 4
+			for len(iptc)%4 != 0 {
+				iptc = append(iptc, 0)
+			}
+			entries = append(entries, ifdEntry{33723, 4, uint32(len(iptc) / 4), iptc})
+		}
+	}
+	if f.icc != nil {
+		entries = append(entries, ifdEntry{34675, 7, uint32(len(f.icc)), f.icc})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	ifdSize := 2 + 12*len(entries) + 4
+	dataOffset := 8 + ifdSize
+	var extra bytes.Buffer
+	offsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if e.tag == 273 || len(e.data) <= 4 {
+			continue
+		}
+		offsets[i] = uint32(dataOffset + extra.Len())
+		extra.Write(e.data)
+		if extra.Len()%2 != 0 {
+			extra.WriteByte(0)
+		}
+	}
+	stripOffset := uint32(dataOffset + extra.Len())
+
+	var out bytes.Buffer
+	if f.order == binary.LittleEndian {
+		out.WriteString("II")
+	} else {
+		out.WriteString("MM")
+	}
+	out.Write(short(42))
+	out.Write(long(8))
+	out.Write(short(uint16(len(entries))))
+	for i, e := range entries {
+		out.Write(short(e.tag))
+		out.Write(short(e.dataType))
+		out.Write(long(e.count))
+		switch {
+		case e.tag == 273:
+			out.Write(long(stripOffset))
+		case len(e.data) <= 4:
+			val := make([]byte, 4)
+			copy(val, e.data)
+			out.Write(val)
+		default:
+			out.Write(long(offsets[i]))
+		}
+	}
+	out.Write(long(0))
+	out.Write(extra.Bytes())
+	out.Write(pixels)
+	return out.Bytes()
+}
+
+func iptcBlock(values []iptcValue) []byte {
+	var out bytes.Buffer
+	for _, v := range values {
+		out.Write([]byte{0x1c, v.record, v.dataset})
+		binary.Write(&out, binary.BigEndian, uint16(len(v.value)))
+		out.WriteString(v.value)
+	}
+	return out.Bytes()
+}
+
+// photoshopBlock wraps the IPTC in an IPTC-NAA image resource after a resolution info resource
+func photoshopBlock(iptc []byte) []byte {
+	var out bytes.Buffer
+	resource := func(resID uint16, data []byte) {
+		out.WriteString("8BIM")
+		binary.Write(&out, binary.BigEndian, resID)
+		out.Write([]byte{0, 0}) // empty name padded to an even size
+		binary.Write(&out, binary.BigEndian, uint32(len(data)))
+		out.Write(data)
+		if len(data)%2 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	resource(0x03ed, []byte{0x02, 0x58, 0, 0, 0, 1, 0, 1, 0x02, 0x58, 0, 0, 0, 1, 0, 1})
+	resource(0x0404, iptc)
+	return out.Bytes()
+}
+
+// iccProfile builds a display profile with just a description tag
+func iccProfile(version uint32, desc []byte) []byte {
+	hdr := make([]byte, 128)
+	binary.BigEndian.PutUint32(hdr[8:12], version)
+	copy(hdr[12:16], "mntr")
+	copy(hdr[16:20], "RGB ")
+	copy(hdr[20:24], "XYZ ")
+	copy(hdr[36:40], "acsp")
+	// D50 illuminant
+	binary.BigEndian.PutUint32(hdr[68:72], 0x0000f6d6)
+	binary.BigEndian.PutUint32(hdr[72:76], 0x00010000)
+	binary.BigEndian.PutUint32(hdr[76:80], 0x0000d32d)
+
+	var out bytes.Buffer
+	out.Write(hdr)
+	binary.Write(&out, binary.BigEndian, uint32(1))
+	out.WriteString("desc")
+	binary.Write(&out, binary.BigEndian, uint32(128+4+12))
+	binary.Write(&out, binary.BigEndian, uint32(len(desc)))
+	out.Write(desc)
+	for out.Len()%4 != 0 {
+		out.WriteByte(0)
+	}
+	profile := out.Bytes()
+	binary.BigEndian.PutUint32(profile[0:4], uint32(len(profile)))
+	return profile
+}
+
+func iccV2(description string) []byte {
+	var desc bytes.Buffer
+	desc.WriteString("desc")
+	desc.Write(make([]byte, 4))
+	binary.Write(&desc, binary.BigEndian, uint32(len(description)+1))
+	desc.WriteString(description)
+	desc.WriteByte(0)
+	desc.Write(make([]byte, 4+4+2+1+67)) // empty unicode and script code descriptions
+	return iccProfile(0x02100000, desc.Bytes())
+}
+
+// iccV4 builds a profile with a multi-localized description. Records are written in sorted language
+// order so en-US comes first
+func iccV4(descriptions map[string]string) []byte {
+	langs := make([]string, 0, len(descriptions))
+	for lang := range descriptions {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	var desc bytes.Buffer
+	desc.WriteString("mluc")
+	desc.Write(make([]byte, 4))
+	binary.Write(&desc, binary.BigEndian, uint32(len(langs)))
+	binary.Write(&desc, binary.BigEndian, uint32(12))
+	strOffset := 16 + 12*len(langs)
+	var strs bytes.Buffer
+	for _, lang := range langs {
+		units := utf16.Encode([]rune(descriptions[lang]))
+		desc.WriteString(lang)
+		binary.Write(&desc, binary.BigEndian, uint32(len(units)*2))
+		binary.Write(&desc, binary.BigEndian, uint32(strOffset+strs.Len()))
+		binary.Write(&strs, binary.BigEndian, units)
+	}
+	desc.Write(strs.Bytes())
+	return iccProfile(0x04300000, desc.Bytes())
+}
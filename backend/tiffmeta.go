@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Native reader for the small set of metadata the unit pages display. It reads the IFD0 tags of a TIFF,
+// the IPTC-IIM record (from the IPTC-NAA tag or the Photoshop image resources) and the ICC profile
+// description and fills in an exifData just like the exiftool JSON would. exiftool is only needed
+// for writes. Files this cannot handle (BigTIFF, non-TIFF) return errNotNativeTIFF so the caller
+// can fall back to exiftool.
+
+var errNotNativeTIFF = errors.New("file is not a supported TIFF")
+
+// TIFF tags of interest
+const (
+	tiffImageWidth      = 256
+	tiffImageHeight     = 257
+	tiffXResolution     = 282
+	tiffIPTC            = 33723
+	tiffPhotoshop       = 34377
+	tiffICCProfile      = 34675
+	tiffMaxTagDataBytes = 16 * 1024 * 1024
+)
+
+// IPTC application record (2) datasets of interest
+const (
+	iptcCodedCharacterSet = 90 // record 1
+	iptcKeywords          = 25
+	iptcContentLocation   = 27
+	iptcSubLocation       = 92
+	iptcHeadline          = 105
+	iptcCaptionAbstract   = 120
+	iptcOwnerID           = 188
+	iptcClassifyState     = 225
+)
+
+// exiftool returns every value of a repeated list dataset and only the last value of any other
+var iptcListDatasets = map[byte]bool{iptcKeywords: true, iptcContentLocation: true}
+
+type tiffReader struct {
+	file  *os.File
+	size  int64
+	order binary.ByteOrder
+}
+
+type tiffEntry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	raw      [4]byte
+}
+
+// TIFF field type sizes, indexed by type
+var tiffTypeSize = []uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8, 4}
+
+func readTIFFMetadata(tgtFile string) (*exifData, error) {
+	f, err := os.Open(tgtFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tiffReader{file: f, size: info.Size()}
+	hdr := make([]byte, 8)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, errNotNativeTIFF
+	}
+	switch string(hdr[0:2]) {
+	case "II":
+		tr.order = binary.LittleEndian
+	case "MM":
+		tr.order = binary.BigEndian
+	default:
+		return nil, errNotNativeTIFF
+	}
+	if tr.order.Uint16(hdr[2:4]) != 42 {
+		// 43 is BigTIFF
+		return nil, errNotNativeTIFF
+	}
+
+	entries, err := tr.readIFD(int64(tr.order.Uint32(hdr[4:8])))
+	if err != nil {
+		return nil, err
+	}
+
+	md := exifData{SourceFile: tgtFile, FileType: "TIFF", FileSize: formatFileSize(info.Size())}
+	var iptcData []byte
+	for _, e := range entries {
+		switch e.tag {
+		case tiffImageWidth:
+			md.Width = int(tr.uintValue(e))
+		case tiffImageHeight:
+			md.Height = int(tr.uintValue(e))
+		case tiffXResolution:
+			data, err := tr.entryData(e)
+			if err == nil && len(data) >= 8 {
+				md.Resolution = rationalValue(tr.order.Uint32(data[0:4]), tr.order.Uint32(data[4:8]))
+			}
+		case tiffIPTC:
+			if data, err := tr.entryData(e); err == nil {
+				iptcData = data
+			}
+		case tiffPhotoshop:
+			if iptcData == nil {
+				if data, err := tr.entryData(e); err == nil {
+					iptcData = photoshopIPTC(data)
+				}
+			}
+		case tiffICCProfile:
+			if data, err := tr.entryData(e); err == nil {
+				md.ColorProfile = iccDescription(data)
+			}
+		}
+	}
+
+	if iptcData != nil {
+		applyIPTC(&md, iptcData)
+	}
+	return &md, nil
+}
+
+// rationalValue converts a rational like exiftool does: rounded to 10 significant digits, or "inf"
+// or "undef" if the denominator is zero
+func rationalValue(num uint32, den uint32) any {
+	if den == 0 {
+		if num == 0 {
+			return "undef"
+		}
+		return "inf"
+	}
+	val, _ := strconv.ParseFloat(strconv.FormatFloat(float64(num)/float64(den), 'g', 10, 64), 64)
+	return val
+}
+
+func (tr *tiffReader) readIFD(offset int64) ([]tiffEntry, error) {
+	buf := make([]byte, 2)
+	if offset <= 0 || offset+2 > tr.size {
+		return nil, fmt.Errorf("invalid IFD offset %d", offset)
+	}
+	if _, err := tr.file.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	cnt := int64(tr.order.Uint16(buf))
+	if offset+2+cnt*12 > tr.size {
+		return nil, fmt.Errorf("IFD at %d is truncated", offset)
+	}
+	buf = make([]byte, cnt*12)
+	if _, err := tr.file.ReadAt(buf, offset+2); err != nil {
+		return nil, err
+	}
+
+	out := make([]tiffEntry, 0, cnt)
+	for i := int64(0); i < cnt; i++ {
+		b := buf[i*12 : i*12+12]
+		e := tiffEntry{tag: tr.order.Uint16(b[0:2]), dataType: tr.order.Uint16(b[2:4]), count: tr.order.Uint32(b[4:8])}
+		copy(e.raw[:], b[8:12])
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// entryData returns the value bytes of an entry, which are inline if they fit in 4 bytes
+func (tr *tiffReader) entryData(e tiffEntry) ([]byte, error) {
+	if int(e.dataType) >= len(tiffTypeSize) || tiffTypeSize[e.dataType] == 0 {
+		return nil, fmt.Errorf("tag %d has unsupported type %d", e.tag, e.dataType)
+	}
+	size := int64(tiffTypeSize[e.dataType]) * int64(e.count)
+	if size <= 4 {
+		return e.raw[:size], nil
+	}
+	offset := int64(tr.order.Uint32(e.raw[:]))
+	if size > tiffMaxTagDataBytes || offset+size > tr.size {
+		return nil, fmt.Errorf("tag %d data is invalid", e.tag)
+	}
+	data := make([]byte, size)
+	if _, err := tr.file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (tr *tiffReader) uintValue(e tiffEntry) uint32 {
+	if e.dataType == 3 {
+		return uint32(tr.order.Uint16(e.raw[0:2]))
+	}
+	return tr.order.Uint32(e.raw[:])
+}
+
+// photoshopIPTC extracts the IPTC-NAA resource (0x0404) from a block of Photoshop image resources
+func photoshopIPTC(data []byte) []byte {
+	pos := 0
+	for pos+12 <= len(data) {
+		if string(data[pos:pos+4]) != "8BIM" {
+			return nil
+		}
+		resID := binary.BigEndian.Uint16(data[pos+4 : pos+6])
+		nameLen := int(data[pos+6])
+		pos += 6 + nameLen + 1
+		if pos%2 != 0 {
+			pos++
+		}
+		if pos+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if pos+size > len(data) {
+			return nil
+		}
+		if resID == 0x0404 {
+			return data[pos : pos+size]
+		}
+		pos += size
+		if pos%2 != 0 {
+			pos++
+		}
+	}
+	return nil
+}
+
+type iptcDataset struct {
+	record  byte
+	dataset byte
+	value   []byte
+}
+
+func parseIPTC(data []byte) []iptcDataset {
+	out := make([]iptcDataset, 0)
+	pos := 0
+	for pos+5 <= len(data) {
+		if data[pos] != 0x1c {
+			// padding at the end of the block
+			break
+		}
+		ds := iptcDataset{record: data[pos+1], dataset: data[pos+2]}
+		size := int(binary.BigEndian.Uint16(data[pos+3 : pos+5]))
+		pos += 5
+		if size&0x8000 != 0 {
+			// extended dataset; the low bits are the number of bytes in the length
+			lenBytes := size & 0x7fff
+			if lenBytes > 4 || pos+lenBytes > len(data) {
+				break
+			}
+			size = 0
+			for _, b := range data[pos : pos+lenBytes] {
+				size = size<<8 | int(b)
+			}
+			pos += lenBytes
+		}
+		if size < 0 || pos+size > len(data) {
+			break
+		}
+		ds.value = data[pos : pos+size]
+		out = append(out, ds)
+		pos += size
+	}
+	return out
+}
+
+// applyIPTC sets the IPTC fields in md. Like exiftool, values are treated as Latin-1 unless the
+// coded character set is UTF-8, and repeated list datasets become a list
+func applyIPTC(md *exifData, data []byte) {
+	datasets := parseIPTC(data)
+	isUTF8 := false
+	for _, ds := range datasets {
+		if ds.record == 1 && ds.dataset == iptcCodedCharacterSet && bytes.Equal(ds.value, []byte("\x1b%G")) {
+			isUTF8 = true
+		}
+	}
+
+	values := make(map[byte][]any)
+	for _, ds := range datasets {
+		if ds.record != 2 {
+			continue
+		}
+		raw := bytes.TrimRight(ds.value, "\x00")
+		val := string(raw)
+		if !isUTF8 {
+			val = latin1ToUTF8(raw)
+		}
+		values[ds.dataset] = append(values[ds.dataset], val)
+	}
+
+	get := func(dataset byte) any {
+		vals := values[dataset]
+		switch {
+		case len(vals) == 0:
+			return nil
+		case len(vals) > 1 && iptcListDatasets[dataset]:
+			return vals
+		default:
+			return vals[len(vals)-1]
+		}
+	}
+	md.Title = get(iptcHeadline)
+	md.Description = get(iptcCaptionAbstract)
+	md.Box = get(iptcKeywords)
+	md.Folder = get(iptcContentLocation)
+	md.Location = get(iptcSubLocation)
+	md.Component = get(iptcOwnerID)
+	if vals := values[iptcClassifyState]; len(vals) > 0 {
+		md.ClassifyState = vals[len(vals)-1].(string)
+	}
+}
+
+func latin1ToUTF8(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// iccDescription returns the profile description from the desc tag of an ICC profile. Version 4
+// profiles store it as a multi-localized unicode tag; the en-US entry is used if present
+func iccDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+	tagCnt := int(binary.BigEndian.Uint32(profile[128:132]))
+	for i := 0; i < tagCnt; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return ""
+		}
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(profile[entry+4 : entry+8]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8 : entry+12]))
+		if offset+size > len(profile) || size < 12 {
+			return ""
+		}
+		tag := profile[offset : offset+size]
+		switch string(tag[0:4]) {
+		case "desc":
+			cnt := int(binary.BigEndian.Uint32(tag[8:12]))
+			if 12+cnt > len(tag) {
+				cnt = len(tag) - 12
+			}
+			text := tag[12 : 12+cnt]
+			if idx := bytes.IndexByte(text, 0); idx > -1 {
+				text = text[:idx]
+			}
+			return string(text)
+		case "mluc":
+			return mlucString(tag)
+		}
+		return ""
+	}
+	return ""
+}
+
+func mlucString(tag []byte) string {
+	if len(tag) < 16 {
+		return ""
+	}
+	recCnt := int(binary.BigEndian.Uint32(tag[8:12]))
+	recSize := int(binary.BigEndian.Uint32(tag[12:16]))
+	if recSize < 12 {
+		return ""
+	}
+	result := ""
+	for i := 0; i < recCnt; i++ {
+		rec := 16 + i*recSize
+		if rec+12 > len(tag) {
+			break
+		}
+		lang := string(tag[rec : rec+4])
+		size := int(binary.BigEndian.Uint32(tag[rec+4 : rec+8]))
+		offset := int(binary.BigEndian.Uint32(tag[rec+8 : rec+12]))
+		if offset+size > len(tag) {
+			continue
+		}
+		units := make([]uint16, size/2)
+		for u := range units {
+			units[u] = binary.BigEndian.Uint16(tag[offset+u*2 : offset+u*2+2])
+		}
+		text := string(utf16.Decode(units))
+		if i == 0 || lang == "enUS" {
+			result = text
+		}
+		if lang == "enUS" {
+			break
+		}
+	}
+	return result
+}
+
+// formatFileSize matches the exiftool print conversion for FileSize
+func formatFileSize(size int64) string {
+	val := float64(size)
+	switch {
+	case size < 2048:
+		return fmt.Sprintf("%d bytes", size)
+	case size < 10240:
+		return fmt.Sprintf("%.1f kB", val/1024)
+	case size < 2097152:
+		return fmt.Sprintf("%.0f kB", val/1024)
+	case size < 10485760:
+		return fmt.Sprintf("%.1f MB", val/1048576)
+	case size < 2147483648:
+		return fmt.Sprintf("%.0f MB", val/1048576)
+	case size < 10737418240:
+		return fmt.Sprintf("%.1f GB", val/1073741824)
+	default:
+		return fmt.Sprintf("%.0f GB", val/1073741824)
+	}
+}
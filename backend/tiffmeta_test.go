@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReadTIFFMetadata compares the native reader with the exiftool output for the fixtures in
+// testdata/tiff. See the README there for how the fixtures are made.
+func TestReadTIFFMetadata(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/tiff/*.json")
+	if err != nil || len(goldens) == 0 {
+		t.Fatalf("no exiftool output found in testdata/tiff")
+	}
+	for _, golden := range goldens {
+		tgtFile := strings.TrimSuffix(golden, ".json") + ".tif"
+		t.Run(filepath.Base(tgtFile), func(t *testing.T) {
+			raw, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read %s: %s", golden, err.Error())
+			}
+			var parsed []exifData
+			if err := json.Unmarshal(raw, &parsed); err != nil || len(parsed) != 1 {
+				t.Fatalf("invalid exiftool output in %s: %v", golden, err)
+			}
+			want := parsed[0]
+
+			got, err := readTIFFMetadata(tgtFile)
+			if err != nil {
+				t.Fatalf("unable to read %s: %s", tgtFile, err.Error())
+			}
+
+			if gotMD, wantMD := parseExifData(got), parseExifData(&want); gotMD != wantMD {
+				t.Errorf("parsed metadata differs\n got: %+v\nwant: %+v", gotMD, wantMD)
+			}
+			if got.SourceFile != want.SourceFile || got.FileType != want.FileType || got.FileSize != want.FileSize ||
+				got.Width != want.Width || got.Height != want.Height || got.ColorProfile != want.ColorProfile ||
+				got.ClassifyState != want.ClassifyState {
+				t.Errorf("file fields differ\n got: %+v\nwant: %+v", got, want)
+			}
+			// the resolution is a number or, when the denominator is zero, a string
+			if fmt.Sprintf("%T %v", got.Resolution, got.Resolution) != fmt.Sprintf("%T %v", want.Resolution, want.Resolution) {
+				t.Errorf("XResolution: got %T %v, want %T %v", got.Resolution, got.Resolution, want.Resolution, want.Resolution)
+			}
+
+			// exiftool writes numeric IPTC values as numbers, so values are compared as text. Only
+			// repeated list datasets are lists
+			iptc := []struct {
+				tag       string
+				got, want any
+			}{
+				{"Headline", got.Title, want.Title},
+				{"Caption-Abstract", got.Description, want.Description},
+				{"Keywords", got.Box, want.Box},
+				{"ContentLocationName", got.Folder, want.Folder},
+				{"Sub-location", got.Location, want.Location},
+				{"OwnerID", got.Component, want.Component},
+			}
+			for _, field := range iptc {
+				_, gotList := field.got.([]any)
+				_, wantList := field.want.([]any)
+				if gotList != wantList || fmt.Sprintf("%v", field.got) != fmt.Sprintf("%v", field.want) {
+					t.Errorf("%s: got %#v, want %#v", field.tag, field.got, field.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatFileSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 bytes"},
+		{2047, "2047 bytes"},
+		{2048, "2.0 kB"},
+		{10239, "10.0 kB"},
+		{10240, "10 kB"},
+		{1536 * 1024, "1536 kB"},
+		{2097151, "2048 kB"},
+		{2097152, "2.0 MB"},
+		{10485759, "10.0 MB"},
+		{10485760, "10 MB"},
+		{146 * 1048576, "146 MB"},
+		{2147483647, "2048 MB"},
+		{2147483648, "2.0 GB"},
+		{10737418239, "10.0 GB"},
+		{10737418240, "10 GB"},
+	}
+	for _, tc := range tests {
+		if got := formatFileSize(tc.size); got != tc.want {
+			t.Errorf("formatFileSize(%d) = %s, want %s", tc.size, got, tc.want)
+		}
+	}
+}
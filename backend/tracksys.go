@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	tracksysMaxAttempts     = 3
+	tracksysRetryBackoff    = 250 * time.Millisecond
+	tracksysBreakerFailures = 5
+	tracksysBreakerCooldown = 30 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// ocrInfo is the OCR reference data returned from the tracksys-api /ocr call
+type ocrInfo struct {
+	Hints     []ocrHint         `json:"hints"`
+	Languages []ocrLanguageHint `json:"languages"`
+}
+
+// tracksysClient is used for all calls to the TrackSys API. Reference data (staff, customers, agencies,
+// container types and OCR settings) is cached for cacheTTL. Calls that time out or are refused are retried
+// with backoff, and a circuit breaker stops calls to TrackSys for a while once it is clearly down so
+// requests fail fast instead of each waiting out the timeout.
+type tracksysClient struct {
+	apiURL     string
+	httpClient *http.Client
+	cacheTTL   time.Duration
+	cacheMutex sync.Mutex
+	cache      map[string]tracksysCacheEntry
+	breaker    circuitBreaker
+}
+
+type tracksysCacheEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// circuitBreaker opens after a run of consecutive failures. Once the cooldown has passed a single
+// trial call is allowed through; if it succeeds the breaker closes, otherwise it opens again.
+type circuitBreaker struct {
+	mutex       sync.Mutex
+	state       breakerState
+	failures    int
+	openedAt    time.Time
+	trialActive bool
+}
+
+type tracksysStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt"`
+}
+
+func newTrackSysClient(apiURL string, httpClient *http.Client, cacheTTL time.Duration) *tracksysClient {
+	slog.Info("create tracksys client", "url", apiURL, "cacheTTL", cacheTTL.String())
+	return &tracksysClient{apiURL: apiURL, httpClient: httpClient, cacheTTL: cacheTTL, cache: make(map[string]tracksysCacheEntry)}
+}
+
+func (ts *tracksysClient) staff(ctx context.Context) ([]staffMember, error) {
+	var out []staffMember
+	err := ts.getJSON(ctx, "/staff", true, &out)
+	return out, err
+}
+
+func (ts *tracksysClient) staffByID(ctx context.Context, staffID uint) (*staffMember, error) {
+	var out staffMember
+	if err := ts.getJSON(ctx, fmt.Sprintf("/staff/lookup?id=%d", staffID), true, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (ts *tracksysClient) staffByComputingID(ctx context.Context, computingID string) (*staffMember, error) {
+	var out staffMember
+	if err := ts.getJSON(ctx, fmt.Sprintf("/staff/lookup?cid=%s", url.QueryEscape(computingID)), true, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (ts *tracksysClient) customers(ctx context.Context) ([]customer, error) {
+	var out []customer
+	err := ts.getJSON(ctx, "/customers", true, &out)
+	return out, err
+}
+
+func (ts *tracksysClient) agencies(ctx context.Context) ([]agency, error) {
+	var out []agency
+	err := ts.getJSON(ctx, "/agencies", true, &out)
+	return out, err
+}
+
+func (ts *tracksysClient) containerTypes(ctx context.Context) ([]containerType, error) {
+	var out []containerType
+	err := ts.getJSON(ctx, "/containertypes", true, &out)
+	return out, err
+}
+
+func (ts *tracksysClient) ocr(ctx context.Context) (*ocrInfo, error) {
+	var out ocrInfo
+	if err := ts.getJSON(ctx, "/ocr", true, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// unit returns the current unit details. These change as the unit is worked on so they are never cached
+func (ts *tracksysClient) unit(ctx context.Context, unitID int64) (*unitInfo, error) {
+	var out unitInfo
+	if err := ts.getJSON(ctx, fmt.Sprintf("/units/%d", unitID), false, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// component returns the raw component JSON; it is passed through to the client as-is
+func (ts *tracksysClient) component(ctx context.Context, componentID string) (json.RawMessage, error) {
+	var out json.RawMessage
+	if err := ts.getJSON(ctx, fmt.Sprintf("/components/%s", componentID), false, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (ts *tracksysClient) status() tracksysStatus {
+	ts.breaker.mutex.Lock()
+	defer ts.breaker.mutex.Unlock()
+	return tracksysStatus{State: ts.breaker.state.String(), Failures: ts.breaker.failures, OpenedAt: ts.breaker.openedAt}
+}
+
+func (ts *tracksysClient) getJSON(ctx context.Context, apiPath string, cached bool, out any) error {
+	var respBytes []byte
+	if cached {
+		respBytes = ts.cached(apiPath)
+	}
+	if respBytes == nil {
+		var err error
+		respBytes, err = ts.get(ctx, apiPath)
+		if err != nil {
+			return err
+		}
+		if cached {
+			ts.cacheMutex.Lock()
+			ts.cache[apiPath] = tracksysCacheEntry{data: respBytes, expiresAt: time.Now().Add(ts.cacheTTL)}
+			ts.cacheMutex.Unlock()
+		}
+	}
+	if err := json.Unmarshal(respBytes, out); err != nil {
+		return fmt.Errorf("unable to parse response from %s: %s", apiPath, err.Error())
+	}
+	return nil
+}
+
+func (ts *tracksysClient) cached(apiPath string) []byte {
+	ts.cacheMutex.Lock()
+	defer ts.cacheMutex.Unlock()
+	entry, found := ts.cache[apiPath]
+	if !found {
+		return nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(ts.cache, apiPath)
+		return nil
+	}
+	return entry.data
+}
+
+// get makes the request, retrying calls that time out or are refused. The returned error is a *RequestError
+func (ts *tracksysClient) get(ctx context.Context, apiPath string) ([]byte, error) {
+	url := ts.apiURL + apiPath
+	logger := getLogger(ctx)
+	if !ts.breaker.allow() {
+		logger.Error("tracksys circuit breaker is open; skipping request", "method", "GET", "url", url)
+		return nil, &RequestError{StatusCode: http.StatusServiceUnavailable, Message: "tracksys is unavailable"}
+	}
+
+	var reqErr *RequestError
+	for attempt := 1; attempt <= tracksysMaxAttempts; attempt++ {
+		if attempt > 1 {
+			backoff := tracksysRetryBackoff * time.Duration(1<<(attempt-2))
+			logger.Info("retry tracksys request", "method", "GET", "url", url, "backoff", backoff.String(),
+				"attempt", attempt, "maxAttempts", tracksysMaxAttempts)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				// the caller gave up while waiting to retry; that says nothing about tracksys
+				timer.Stop()
+				ts.breaker.release()
+				return nil, reqErr
+			case <-timer.C:
+			}
+		}
+
+		logger.Info("tracksys request", "method", "GET", "url", url)
+		startTime := time.Now()
+		req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+		req.Header.Add("Content-type", "application/json")
+		addRequestIDHeader(ctx, req)
+		rawResp, rawErr := ts.httpClient.Do(req)
+		var respBytes []byte
+		respBytes, reqErr = handleAPIResponse(url, rawResp, rawErr)
+		elapsedMS := time.Since(startTime).Milliseconds()
+		if reqErr == nil {
+			observeExternalRequest("tracksys", "GET", apiPath, http.StatusOK, startTime)
+			logger.Info("tracksys request succeeded", "method", "GET", "url", url, "elapsedMS", elapsedMS)
+			ts.breaker.done(true)
+			return respBytes, nil
+		}
+
+		observeExternalRequest("tracksys", "GET", apiPath, reqErr.StatusCode, startTime)
+		logger.Error("tracksys request failed", "method", "GET", "url", url, "status", reqErr.StatusCode,
+			"error", reqErr.Message, "elapsedMS", elapsedMS)
+		if rawErr == nil {
+			// tracksys responded, so it is up. Only server errors count against the breaker
+			ts.breaker.done(reqErr.StatusCode < http.StatusInternalServerError)
+			return nil, reqErr
+		}
+		if ctx.Err() != nil {
+			// the caller gave up; that says nothing about tracksys
+			ts.breaker.release()
+			return nil, reqErr
+		}
+		if !isRetryable(rawErr) {
+			break
+		}
+	}
+	ts.breaker.done(false)
+	return nil, reqErr
+}
+
+// addRequestIDHeader passes the ID of the request or job in the context along to the service being called
+func addRequestIDHeader(ctx context.Context, req *http.Request) {
+	if reqID := getRequestID(ctx); reqID != "" {
+		req.Header.Set(requestIDHeader, reqID)
+	}
+}
+
+func isRetryable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// allow reports if a call can be made
+func (cb *circuitBreaker) allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < tracksysBreakerCooldown {
+			return false
+		}
+		slog.Info("tracksys circuit breaker is half-open; allow a trial call")
+		cb.state = breakerHalfOpen
+		cb.trialActive = true
+		return true
+	case breakerHalfOpen:
+		if cb.trialActive {
+			return false
+		}
+		cb.trialActive = true
+		return true
+	}
+	return true
+}
+
+// release ends a call that was abandoned before tracksys could respond; it does not count either way
+func (cb *circuitBreaker) release() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.trialActive = false
+}
+
+// done records the result of a call that was allowed
+func (cb *circuitBreaker) done(success bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.trialActive = false
+	if success {
+		if cb.state != breakerClosed {
+			slog.Info("tracksys circuit breaker closed")
+		}
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || (cb.state == breakerClosed && cb.failures >= tracksysBreakerFailures) {
+		slog.Error("tracksys circuit breaker opened", "failures", cb.failures)
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// jobsClient sends requests to dpg-jobs. The JWT of the user making the request is passed along
+type jobsClient struct {
+	jobsURL    string
+	httpClient *http.Client
+}
+
+func (jc *jobsClient) finalizeUnit(ctx context.Context, unitID uint, jwt string) error {
+	return jc.post(ctx, fmt.Sprintf("/units/%d/finalize", unitID), nil, jwt)
+}
+
+func (jc *jobsClient) updateOCRSettings(ctx context.Context, unitID uint, settings any, jwt string) error {
+	return jc.post(ctx, fmt.Sprintf("/units/%d/ocr-settings", unitID), settings, jwt)
+}
+
+// post sends the request to dpg-jobs. The returned error is a *RequestError
+func (jc *jobsClient) post(ctx context.Context, jobsPath string, payload any, jwt string) error {
+	url := jc.jobsURL + jobsPath
+	logger := getLogger(ctx)
+	logger.Info("dpg-jobs request", "method", "POST", "url", url)
+	startTime := time.Now()
+	b, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(b))
+	req.Header.Add("Content-type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	addRequestIDHeader(ctx, req)
+	rawResp, rawErr := jc.httpClient.Do(req)
+	_, err := handleAPIResponse(url, rawResp, rawErr)
+	elapsedMS := time.Since(startTime).Milliseconds()
+
+	if err != nil {
+		observeExternalRequest("dpg-jobs", "POST", jobsPath, err.StatusCode, startTime)
+		logger.Error("dpg-jobs request failed", "method", "POST", "url", url, "status", err.StatusCode,
+			"error", err.Message, "elapsedMS", elapsedMS)
+		return err
+	}
+	observeExternalRequest("dpg-jobs", "POST", jobsPath, http.StatusOK, startTime)
+	logger.Info("dpg-jobs request succeeded", "method", "POST", "url", url, "elapsedMS", elapsedMS)
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackSysClient(t *testing.T) {
+	t.Run("computing ID is escaped", func(t *testing.T) {
+		var gotCID string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotCID = r.URL.Query().Get("cid")
+			json.NewEncoder(w).Encode(staffMember{ID: 1, ComputingID: gotCID})
+		}))
+		defer server.Close()
+
+		client := newTrackSysClient(server.URL, server.Client(), 0)
+		if _, err := client.staffByComputingID(context.Background(), "abc&id=1"); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if gotCID != "abc&id=1" {
+			t.Errorf("expected computing ID abc&id=1, got %s", gotCID)
+		}
+	})
+
+	t.Run("retry backoff stops when the request is canceled", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		client := newTrackSysClient(server.URL, &http.Client{}, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		startTime := time.Now()
+		if _, err := client.get(ctx, "/staff"); err == nil {
+			t.Fatalf("expected the request to fail")
+		}
+		if elapsed := time.Since(startTime); elapsed >= tracksysRetryBackoff {
+			t.Errorf("expected the request to stop once canceled, took %s", elapsed)
+		}
+		if !client.breaker.allow() {
+			t.Errorf("expected a canceled request to leave the circuit breaker closed")
+		}
+	})
+}
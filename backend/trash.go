@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// trashedFile is a master file that was deleted from a unit. The file is kept in the trash directory
+// for the unit until it is restored or purged by the trash cleanup
+type trashedFile struct {
+	ID           int64               `json:"id"`
+	UnitID       uint                `json:"unitID"`
+	FileName     string              `json:"fileName"`
+	OriginalPath string              `json:"originalPath"`
+	TrashPath    string              `json:"trashPath"`
+	FileSize     int64               `json:"fileSize"`
+	Metadata     *masterFileMetadata `gorm:"serializer:json" json:"metadata,omitempty"`
+	DeletedBy    string              `json:"deletedBy"`
+	DeletedAt    time.Time           `json:"deletedAt"`
+	RestoredBy   string              `json:"restoredBy,omitempty"`
+	RestoredAt   *time.Time          `json:"restoredAt,omitempty"`
+}
+
+func (svc *serviceContext) trashDir(unitID uint) string {
+	return path.Join(svc.ImagesDir, "trash", padLeft(fmt.Sprintf("%d", unitID), 9))
+}
+
+// trashFile moves a master file into the trash for the unit and returns its path in the trash. The
+// metadata is read before the file is moved so it can be shown in the trash listing
+func (svc *serviceContext) trashFile(ctx context.Context, unitID uint, tgtFile string, computeID string) (string, error) {
+	info, err := os.Stat(tgtFile)
+	if err != nil {
+		return "", err
+	}
+
+	trashDir := svc.trashDir(unitID)
+	if !pathExists(trashDir) {
+		if err := svc.Files.mkdir(trashDir); err != nil {
+			return "", fmt.Errorf("unable to create trash directory %s: %s", trashDir, err.Error())
+		}
+	}
+
+	rec := trashedFile{UnitID: unitID, FileName: path.Base(tgtFile), OriginalPath: tgtFile, FileSize: info.Size(),
+		DeletedBy: computeID, DeletedAt: time.Now()}
+	if md, found := svc.MetadataCache.get(tgtFile); found {
+		rec.Metadata = md
+	} else if exifMD, err := svc.getExifData(ctx, tgtFile); err == nil {
+		md := parseExifData(exifMD)
+		rec.Metadata = &md
+	}
+
+	// the ID is part of the trash file name so a file that is deleted more than once does not collide
+	if err := svc.DB.Create(&rec).Error; err != nil {
+		return "", fmt.Errorf("unable to record trashed file: %s", err.Error())
+	}
+	rec.TrashPath = path.Join(trashDir, fmt.Sprintf("%d_%s", rec.ID, rec.FileName))
+	if err := svc.Files.rename(tgtFile, rec.TrashPath); err != nil {
+		svc.DB.Delete(&rec)
+		return "", err
+	}
+	svc.MetadataCache.invalidate(tgtFile)
+	if err := svc.DB.Model(&rec).Update("trash_path", rec.TrashPath).Error; err != nil {
+		getLogger(ctx).Error("unable to update trash path", "file", tgtFile, "trashPath", rec.TrashPath, "error", err.Error())
+	}
+	return rec.TrashPath, nil
+}
+
+func (svc *serviceContext) getTrashedFiles(c *gin.Context) {
+	unitID, _ := strconv.ParseUint(c.Param("uid"), 10, 64)
+	var out []trashedFile
+	err := svc.DB.Where("unit_id=? and restored_at is null", unitID).Order("deleted_at desc").Find(&out).Error
+	if err != nil {
+		getLogger(c.Request.Context()).Error("unable to get trashed files", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (svc *serviceContext) restoreTrashedFile(c *gin.Context) {
+	rawUnitID := c.Param("uid")
+	unitID, _ := strconv.ParseUint(rawUnitID, 10, 64)
+	trashID := c.Param("id")
+	claims := getJWTClaims(c)
+	ctx := c.Request.Context()
+	logger := getLogger(ctx).With("trashID", trashID)
+
+	var rec trashedFile
+	err := svc.DB.Where("id=? and unit_id=?", trashID, unitID).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.String(http.StatusNotFound, fmt.Sprintf("trashed file %s not found in unit %d", trashID, unitID))
+			return
+		}
+		logger.Error("unable to get trashed file", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rec.RestoredAt != nil {
+		c.String(http.StatusConflict, fmt.Sprintf("%s has already been restored", rec.FileName))
+		return
+	}
+
+	lock, lockErr := svc.acquireUnitLock(ctx, rawUnitID, claims.ComputeID, "restore")
+	if lockErr != nil {
+		logger.Warn("restore rejected; unit is locked", "file", rec.FileName, "error", lockErr.Message)
+		c.String(lockErr.StatusCode, lockErr.Message)
+		return
+	}
+	defer svc.releaseUnitLock(lock)
+
+	if pathExists(rec.OriginalPath) {
+		c.String(http.StatusConflict, fmt.Sprintf("%s already exists; rename or delete it before restoring", rec.OriginalPath))
+		return
+	}
+	origDir := path.Dir(rec.OriginalPath)
+	if !pathExists(origDir) {
+		if err := svc.Files.mkdir(origDir); err != nil {
+			logger.Error("unable to recreate directory to restore file", "dir", origDir, "file", rec.FileName, "error", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	logger.Info("restore trashed file", "path", rec.OriginalPath, "trashPath", rec.TrashPath)
+	if err := svc.Files.rename(rec.TrashPath, rec.OriginalPath); err != nil {
+		logger.Error("unable to restore trashed file", "path", rec.OriginalPath, "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	svc.UnitIndex.invalidate(path.Join(svc.ImagesDir, padLeft(rawUnitID, 9)))
+	svc.recordFileEvents(ctx, fileEvent{UnitID: rec.UnitID, ComputeID: claims.ComputeID, Action: FileEventRestore, File: rec.OriginalPath,
+		OldValue: rec.TrashPath, NewValue: rec.OriginalPath})
+
+	now := time.Now()
+	rec.RestoredAt = &now
+	rec.RestoredBy = claims.ComputeID
+	if err := svc.DB.Model(&rec).Select("restored_at", "restored_by").Updates(rec).Error; err != nil {
+		logger.Error("unable to mark file as restored", "path", rec.OriginalPath, "error", err.Error())
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+// cleanupTrash permanently removes files that have been in the trash longer than the retention period
+func (svc *serviceContext) cleanupTrash(c *gin.Context) {
+	cutoff := time.Now().AddDate(0, 0, -svc.TrashDays)
+	dateStr := cutoff.Format("2006-01-02")
+	logger := getLogger(c.Request.Context()).With("cutoff", dateStr)
+	logger.Info("cleanup old trashed files")
+
+	var trashed []trashedFile
+	if err := svc.DB.Where("restored_at is null and deleted_at < ?", dateStr).Find(&trashed).Error; err != nil {
+		logger.Error("unable to get old trashed files", "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	delCnt := 0
+	failCnt := 0
+	for _, rec := range trashed {
+		if err := svc.Files.remove(rec.TrashPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error("unable to purge trashed file", "trashPath", rec.TrashPath, "error", err.Error())
+			failCnt++
+			continue
+		}
+		if err := svc.DB.Delete(&rec).Error; err != nil {
+			logger.Error("unable to remove trash record", "trashID", rec.ID, "path", rec.OriginalPath, "error", err.Error())
+			failCnt++
+			continue
+		}
+		delCnt++
+	}
+
+	// the restored files are back in their units; only the record of the delete is left
+	if err := svc.DB.Where("restored_at is not null and restored_at < ?", dateStr).Delete(&trashedFile{}).Error; err != nil {
+		logger.Error("unable to remove old restored trash records", "error", err.Error())
+	}
+
+	logger.Info("old trashed files purged", "purged", delCnt, "failed", failCnt)
+	c.String(http.StatusOK, "%d trashed files deleted before %s purged, %d failed", delCnt, dateStr, failCnt)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jobUndoMetadata = "undo-metadata"
+	jobRedoMetadata = "redo-metadata"
+)
+
+type metadataUndoRequest struct {
+	Count int `json:"count"`
+}
+
+// undoMetadata queues a job to revert the last N metadata operations made by the user in the unit.
+// A batch update is a single operation
+func (svc *serviceContext) undoMetadata(c *gin.Context) {
+	svc.queueUndoJob(c, jobUndoMetadata)
+}
+
+// redoMetadata queues a job to re-apply the last N metadata operations undone by the user. Operations
+// can only be redone until the user makes a new metadata change in the unit
+func (svc *serviceContext) redoMetadata(c *gin.Context) {
+	svc.queueUndoJob(c, jobRedoMetadata)
+}
+
+func (svc *serviceContext) queueUndoJob(c *gin.Context, jobType string) {
+	count := 1
+	if countStr := c.Query("count"); countStr != "" {
+		cnt, err := strconv.Atoi(countStr)
+		if err != nil || cnt < 1 {
+			c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid count", countStr))
+			return
+		}
+		count = cnt
+	}
+	svc.queueUnitJob(c, jobType, 0, metadataUndoRequest{Count: count})
+}
+
+// undoableOperations returns the IDs of the operations to undo, most recent first, or to redo, oldest first
+func (svc *serviceContext) undoableOperations(unitID uint, computeID string, count int, redo bool) ([]string, error) {
+	var ops []struct {
+		OperationID string
+		LastID      int64
+	}
+	opQ := svc.DB.Model(&fileEvent{}).Select("operation_id, max(id) as last_id").
+		Where("unit_id=? and compute_id=? and action=? and operation_id<>''", unitID, computeID, FileEventUpdate)
+	if redo {
+		// a new change by the user ends the chance to redo anything that was undone before it
+		var lastActive int64
+		err := svc.DB.Model(&fileEvent{}).Select("coalesce(max(id), 0)").
+			Where("unit_id=? and compute_id=? and action=? and undone=?", unitID, computeID, FileEventUpdate, false).Scan(&lastActive).Error
+		if err != nil {
+			return nil, err
+		}
+		opQ = opQ.Where("undone=?", true).Group("operation_id").Having("min(id) > ?", lastActive).Order("last_id asc")
+	} else {
+		opQ = opQ.Where("undone=?", false).Group("operation_id").Order("last_id desc")
+	}
+	if err := opQ.Limit(count).Scan(&ops).Error; err != nil {
+		return nil, err
+	}
+
+	out := make([]string, 0, len(ops))
+	for _, op := range ops {
+		out = append(out, op.OperationID)
+	}
+	return out, nil
+}
+
+// runUndoJob undoes or redoes metadata operations one at a time. An operation is not applied, and no further
+// operations are processed, if any of its fields have been changed since. This keeps the changes made
+// by other users or by later operations from being overwritten
+func (svc *serviceContext) runUndoJob(tgtJob *job) error {
+	redo := tgtJob.JobType == jobRedoMetadata
+	action := FileEventUndo
+	if redo {
+		action = FileEventRedo
+	}
+	rawUnitID := fmt.Sprintf("%d", tgtJob.UnitID)
+	unitDir := path.Join(svc.ImagesDir, padLeft(rawUnitID, 9))
+	var req metadataUndoRequest
+	if err := json.Unmarshal([]byte(tgtJob.Payload), &req); err != nil {
+		return fmt.Errorf("invalid %s payload: %s", action, err.Error())
+	}
+
+	lock, lockErr := svc.acquireUnitLock(tgtJob.context(), rawUnitID, tgtJob.Owner, action)
+	if lockErr != nil {
+		return errors.New(lockErr.Message)
+	}
+	defer svc.releaseUnitLock(lock)
+
+	opIDs, err := svc.undoableOperations(tgtJob.UnitID, tgtJob.Owner, req.Count, redo)
+	if err != nil {
+		return fmt.Errorf("unable to find metadata changes to %s: %s", action, err.Error())
+	}
+	if len(opIDs) == 0 {
+		return fmt.Errorf("there are no metadata changes to %s", action)
+	}
+	tgtJob.logger().Info("revert metadata operations", "action", action, "operations", len(opIDs))
+	svc.jobPhase(tgtJob, action)
+
+	for _, opID := range opIDs {
+		if err := lock.leaseLost(); err != nil {
+			return err
+		}
+		var events []fileEvent
+		err := svc.DB.Where("unit_id=? and operation_id=? and action=? and undone=?", tgtJob.UnitID, opID, FileEventUpdate, redo).
+			Order("id asc").Find(&events).Error
+		if err != nil {
+			return fmt.Errorf("unable to get changes for operation %s: %s", opID, err.Error())
+		}
+		if err := svc.revertOperation(tgtJob, lock, unitDir, events, redo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertOperation applies the old values of the events when undoing or the new values when redoing
+func (svc *serviceContext) revertOperation(tgtJob *job, lock *unitLock, unitDir string, events []fileEvent, redo bool) error {
+	action := FileEventUndo
+	if redo {
+		action = FileEventRedo
+	} else {
+		// undo the changes to a field in the reverse order they were made
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	// the files may have been renamed since the operation, so the changes are applied to the files
+	// by their current names rather than to whatever file has the recorded name now
+	firstID := events[0].ID
+	names := make([]string, 0, len(events))
+	for _, evt := range events {
+		firstID = min(firstID, evt.ID)
+		names = append(names, evt.File)
+	}
+	currNames, err := svc.renamedSince(tgtJob.UnitID, firstID, names)
+	if err != nil {
+		return fmt.Errorf("unable to check for files renamed since the changes were made: %s", err.Error())
+	}
+
+	files := make([]string, 0, len(events))
+	filePaths := make(map[string]string)
+	for _, evt := range events {
+		if fullPath := svc.UnitIndex.find(unitDir, currNames[evt.File]); fullPath != "" {
+			filePaths[evt.File] = fullPath
+			files = append(files, fullPath)
+		}
+	}
+	currMD := svc.currentMetadata(tgtJob.context(), files)
+
+	// only the last change to a field of a file can be checked against the current value
+	conflicts := 0
+	checked := make(map[string]bool)
+	for _, evt := range events {
+		key := evt.File + "|" + evt.Field
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		expected := evt.NewValue
+		if redo {
+			expected = evt.OldValue
+		}
+		md, found := currMD[filePaths[evt.File]]
+		if !found {
+			svc.jobProblem(tgtJob, updateProblem{File: currNames[evt.File], Problem: "file no longer exists"})
+			conflicts++
+		} else if md.fieldValue(evt.Field) != expected {
+			svc.jobProblem(tgtJob, updateProblem{File: currNames[evt.File], Problem: fmt.Sprintf("%s has been changed to [%s] since", evt.Field, md.fieldValue(evt.Field))})
+			conflicts++
+		}
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("unable to %s the changes made at %s; %d files have changed since. Nothing further was changed",
+			action, events[0].CreatedAt.Format("2006-01-02 15:04:05"), conflicts)
+	}
+
+	rawUnitID := fmt.Sprintf("%d", tgtJob.UnitID)
+	commands := make([]exifFileCommands, 0, len(events))
+	for _, evt := range events {
+		change := metadataChange{File: filePaths[evt.File], Field: evt.Field, Value: evt.OldValue}
+		if redo {
+			change.Value = evt.NewValue
+		}
+		cmd, err := svc.metadataUpdateCommand(tgtJob.context(), rawUnitID, change)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, cmd)
+	}
+	svc.jobAddWork(tgtJob, len(commands))
+	failed := svc.runMetadataCommands(tgtJob, lock, commands)
+
+	// only the changes that were applied are flagged so a failed file can be tried again
+	doneIDs := make([]int64, 0, len(events))
+	history := make([]fileEvent, 0, len(events))
+	for _, evt := range events {
+		if failed[currNames[evt.File]] {
+			continue
+		}
+		doneIDs = append(doneIDs, evt.ID)
+		histEvt := fileEvent{UnitID: tgtJob.UnitID, JobID: tgtJob.ID, OperationID: evt.OperationID, ComputeID: tgtJob.Owner,
+			Action: action, File: currNames[evt.File], Field: evt.Field, OldValue: evt.NewValue, NewValue: evt.OldValue}
+		if redo {
+			histEvt.OldValue, histEvt.NewValue = evt.OldValue, evt.NewValue
+		}
+		history = append(history, histEvt)
+	}
+	if len(doneIDs) > 0 {
+		if err := svc.DB.Model(&fileEvent{}).Where("id in ?", doneIDs).Update("undone", !redo).Error; err != nil {
+			tgtJob.logger().Error("unable to flag metadata changes", "action", action, "count", len(doneIDs), "error", err.Error())
+		}
+	}
+	svc.recordFileEvents(tgtJob.context(), history...)
+	return lock.leaseLost()
+}
+
+// renamedSince maps file names to the names the files have now by following the renames recorded after
+// the event with ID afterID. The renames made by one job happened together, so they are applied together
+// to follow names that were swapped
+func (svc *serviceContext) renamedSince(unitID uint, afterID int64, names []string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, name := range names {
+		out[name] = name
+	}
+	var renames []fileEvent
+	err := svc.DB.Where("unit_id=? and action=? and id > ?", unitID, FileEventRename, afterID).Order("id asc").Find(&renames).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(renames); {
+		jobRenames := make(map[string]string)
+		end := start
+		for ; end < len(renames) && renames[end].JobID == renames[start].JobID; end++ {
+			jobRenames[renames[end].OldValue] = renames[end].NewValue
+		}
+		for name, currName := range out {
+			if newName, found := jobRenames[currName]; found {
+				out[name] = newName
+			}
+		}
+		start = end
+	}
+	return out, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+func TestUndoMetadata(t *testing.T) {
+	t.Run("follow renames made after the change", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+		// the title of 0001 was changed, then 0001 and 0002 swapped names and 0002 was renamed again
+		events := []fileEvent{
+			{UnitID: testUnitID, OperationID: "op1", ComputeID: scanner.ComputeID, Action: FileEventUpdate, File: "000000012_0001.tif",
+				Field: "title", OldValue: "Page 1", NewValue: "Cover"},
+			{UnitID: testUnitID, JobID: 2, ComputeID: scanner.ComputeID, Action: FileEventRename, File: "000000012_0001.tif",
+				OldValue: "000000012_0001.tif", NewValue: "000000012_0002.tif"},
+			{UnitID: testUnitID, JobID: 2, ComputeID: scanner.ComputeID, Action: FileEventRename, File: "000000012_0002.tif",
+				OldValue: "000000012_0002.tif", NewValue: "000000012_0001.tif"},
+			{UnitID: testUnitID, JobID: 3, ComputeID: scanner.ComputeID, Action: FileEventRename, File: "000000012_0002.tif",
+				OldValue: "000000012_0002.tif", NewValue: "000000012_0003.tif"},
+		}
+		if err := ts.DB.Create(&events).Error; err != nil {
+			t.Fatalf("unable to create file events: %s", err.Error())
+		}
+		renamed := path.Join(unitDir, "000000012_0003.tif")
+		ts.exifTool.metadata[renamed] = exifData{Title: "Cover"}
+		ts.exifTool.metadata[path.Join(unitDir, "000000012_0001.tif")] = exifData{Title: "Page 2"}
+		if err := os.Rename(path.Join(unitDir, "000000012_0002.tif"), renamed); err != nil {
+			t.Fatalf("unable to rename test file: %s", err.Error())
+		}
+
+		c, resp := newTestRequest(http.MethodPost, "/api/units/12/undo", nil, scanner, "uid", "12")
+		ts.undoMetadata(c)
+		expectStatus(t, resp, http.StatusAccepted)
+		tgtJob := ts.runQueuedJob(t, resp)
+		if tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished job, got %s: %s %+v", tgtJob.Status, tgtJob.Error, tgtJob.Problems)
+		}
+
+		want := []string{"-iptc:headline=Page 1", renamed}
+		if !slices.ContainsFunc(ts.exifTool.commands, func(cmd []string) bool { return slices.Equal(cmd, want) }) {
+			t.Errorf("expected the title of the renamed file to be reverted, got commands %v", ts.exifTool.commands)
+		}
+		var undone fileEvent
+		if err := ts.DB.Where("action=?", FileEventUndo).First(&undone).Error; err != nil {
+			t.Fatalf("unable to get undo event: %s", err.Error())
+		}
+		if undone.File != "000000012_0003.tif" {
+			t.Errorf("expected the undo to be recorded for 000000012_0003.tif, got %s", undone.File)
+		}
+	})
+
+	t.Run("an update of a file that could not be read is not undone", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+		readable, unreadable := path.Join(unitDir, "000000012_0001.tif"), path.Join(unitDir, "000000012_0002.tif")
+		ts.exifTool.metadata[readable] = exifData{Title: "Page 1"}
+		ts.exifTool.unreadable[unreadable] = true
+
+		c, resp := newTestRequest(http.MethodPost, "/api/units/12/update", []metadataChange{
+			{File: readable, Field: "title", Value: "Cover"},
+			{File: unreadable, Field: "title", Value: "Cover"},
+		}, scanner, "uid", "12")
+		ts.updateMetadataBatch(c)
+		expectStatus(t, resp, http.StatusAccepted)
+		if tgtJob := ts.runQueuedJob(t, resp); tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished update job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		var updates []fileEvent
+		ts.DB.Where("action=?", FileEventUpdate).Order("file asc").Find(&updates)
+		if len(updates) != 2 || updates[0].OperationID == "" || updates[1].OperationID != "" {
+			t.Fatalf("expected only the update of the readable file to have an operation, got %+v", updates)
+		}
+
+		ts.exifTool.metadata[readable] = exifData{Title: "Cover"}
+		ts.exifTool.commands = nil
+		c, resp = newTestRequest(http.MethodPost, "/api/units/12/undo", nil, scanner, "uid", "12")
+		ts.undoMetadata(c)
+		expectStatus(t, resp, http.StatusAccepted)
+		if tgtJob := ts.runQueuedJob(t, resp); tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished undo job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		for _, cmd := range ts.exifTool.commands {
+			if slices.Contains(cmd, unreadable) {
+				t.Errorf("expected the unreadable file to be left alone, got command %v", cmd)
+			}
+		}
+		if !slices.ContainsFunc(ts.exifTool.commands, func(cmd []string) bool {
+			return slices.Equal(cmd, []string{"-iptc:headline=Page 1", readable})
+		}) {
+			t.Errorf("expected the title of the readable file to be reverted, got commands %v", ts.exifTool.commands)
+		}
+	})
+
+	t.Run("a changed field stops the undo", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif")
+		evt := fileEvent{UnitID: testUnitID, OperationID: "op1", ComputeID: scanner.ComputeID, Action: FileEventUpdate,
+			File: "000000012_0001.tif", Field: "title", OldValue: "Page 1", NewValue: "Cover"}
+		if err := ts.DB.Create(&evt).Error; err != nil {
+			t.Fatalf("unable to create file event: %s", err.Error())
+		}
+		ts.exifTool.metadata[path.Join(unitDir, "000000012_0001.tif")] = exifData{Title: "Back cover"}
+
+		c, resp := newTestRequest(http.MethodPost, "/api/units/12/undo", nil, scanner, "uid", "12")
+		ts.undoMetadata(c)
+		expectStatus(t, resp, http.StatusAccepted)
+		tgtJob := ts.runQueuedJob(t, resp)
+		if tgtJob.Status != JobFailed || len(tgtJob.Problems) != 1 {
+			t.Fatalf("expected failed job with 1 problem, got %s with %+v", tgtJob.Status, tgtJob.Problems)
+		}
+	})
+}
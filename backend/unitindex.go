@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const unitIndexMaxUnits = 500
+
+// unitIndex tracks the files in unit directories so lookups do not need to walk the whole unit
+// tree. The contents of each directory are cached along with the directory modification time. When
+// an index is used, each directory is checked with a stat and only directories that have changed are
+// read again. Changes made by this service also invalidate the index for the unit directly.
+type unitIndex struct {
+	mutex sync.Mutex
+	units map[string]*unitDirIndex
+}
+
+type unitDirIndex struct {
+	mutex    sync.Mutex
+	rootDir  string
+	dirs     map[string]*indexedDir
+	lastUsed time.Time
+}
+
+type indexedDir struct {
+	modTime time.Time
+	entries []indexedEntry // sorted by name, the same order used by filepath.Walk
+}
+
+type indexedEntry struct {
+	name  string
+	isDir bool
+}
+
+func newUnitIndex() *unitIndex {
+	return &unitIndex{units: make(map[string]*unitDirIndex)}
+}
+
+// files returns the full path of every file in the unit directory in the same order as filepath.Walk
+func (ui *unitIndex) files(unitDir string) []string {
+	idx := ui.getUnit(unitDir)
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.refresh()
+	out := make([]string, 0)
+	idx.collect(idx.rootDir, &out)
+	return out
+}
+
+// fileMap returns a map of file name to full path for the files in the unit directory. If the same
+// name appears in more than one subdirectory, the first one found by filepath.Walk is used
+func (ui *unitIndex) fileMap(unitDir string) map[string]string {
+	out := make(map[string]string)
+	for _, fullPath := range ui.files(unitDir) {
+		name := path.Base(fullPath)
+		if _, found := out[name]; !found {
+			out[name] = fullPath
+		}
+	}
+	return out
+}
+
+// find returns the full path of the file with the target name in the unit directory, or an
+// empty string if it is not found
+func (ui *unitIndex) find(unitDir string, tgtFileName string) string {
+	return ui.fileMap(unitDir)[tgtFileName]
+}
+
+// invalidate discards the index for a unit so it will be rebuilt the next time it is used
+func (ui *unitIndex) invalidate(unitDir string) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	delete(ui.units, path.Clean(unitDir))
+}
+
+func (ui *unitIndex) getUnit(unitDir string) *unitDirIndex {
+	unitDir = path.Clean(unitDir)
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	idx, found := ui.units[unitDir]
+	if !found {
+		if len(ui.units) >= unitIndexMaxUnits {
+			ui.evictOldest()
+		}
+		idx = &unitDirIndex{rootDir: unitDir, dirs: make(map[string]*indexedDir)}
+		ui.units[unitDir] = idx
+	}
+	idx.lastUsed = time.Now()
+	return idx
+}
+
+func (ui *unitIndex) evictOldest() {
+	oldestDir := ""
+	var oldest time.Time
+	for dir, idx := range ui.units {
+		if oldestDir == "" || idx.lastUsed.Before(oldest) {
+			oldestDir = dir
+			oldest = idx.lastUsed
+		}
+	}
+	delete(ui.units, oldestDir)
+}
+
+// refresh re-reads any directory that has changed since it was indexed and drops directories that are gone
+func (idx *unitDirIndex) refresh() {
+	if len(idx.dirs) == 0 {
+		start := time.Now()
+		idx.scan(idx.rootDir)
+		log.Printf("INFO: indexed %d directories in %s in %dms", len(idx.dirs), idx.rootDir, time.Since(start).Milliseconds())
+		return
+	}
+
+	changed := make([]string, 0)
+	for dir, indexed := range idx.dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			delete(idx.dirs, dir)
+			continue
+		}
+		if !info.ModTime().Equal(indexed.modTime) {
+			changed = append(changed, dir)
+		}
+	}
+	for _, dir := range changed {
+		if _, found := idx.dirs[dir]; found {
+			idx.scan(dir)
+		}
+	}
+}
+
+// scan reads the directory and any subdirectories that are not already indexed
+func (idx *unitDirIndex) scan(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		delete(idx.dirs, dir)
+		return
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("WARNING: unable to read %s: %s", dir, err.Error())
+		delete(idx.dirs, dir)
+		return
+	}
+
+	// ReadDir returns the entries sorted by name
+	indexed := indexedDir{modTime: info.ModTime(), entries: make([]indexedEntry, 0, len(dirEntries))}
+	for _, de := range dirEntries {
+		indexed.entries = append(indexed.entries, indexedEntry{name: de.Name(), isDir: de.IsDir()})
+	}
+	idx.dirs[dir] = &indexed
+
+	for _, e := range indexed.entries {
+		subDir := path.Join(dir, e.name)
+		if e.isDir {
+			if _, found := idx.dirs[subDir]; !found {
+				idx.scan(subDir)
+			}
+		}
+	}
+
+	// drop any subdirectories that were removed
+	for known := range idx.dirs {
+		if path.Dir(known) == dir && known != dir {
+			if !indexed.hasDir(path.Base(known)) {
+				idx.dropDir(known)
+			}
+		}
+	}
+}
+
+func (idx *unitDirIndex) dropDir(dir string) {
+	for known := range idx.dirs {
+		if known == dir || strings.HasPrefix(known, dir+"/") {
+			delete(idx.dirs, known)
+		}
+	}
+}
+
+func (idx *unitDirIndex) collect(dir string, out *[]string) {
+	indexed, found := idx.dirs[dir]
+	if !found {
+		return
+	}
+	for _, e := range indexed.entries {
+		fullPath := path.Join(dir, e.name)
+		if e.isDir {
+			idx.collect(fullPath, out)
+		} else {
+			*out = append(*out, fullPath)
+		}
+	}
+}
+
+func (d *indexedDir) hasDir(name string) bool {
+	for _, e := range d.entries {
+		if e.isDir && e.name == name {
+			return true
+		}
+	}
+	return false
+}
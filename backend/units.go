@@ -4,10 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
-	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -76,13 +74,13 @@ type unitMasterfiles struct {
 func (svc *serviceContext) validateComponentSettings(c *gin.Context) {
 	uidStr := padLeft(c.Param("uid"), 9)
 	unitDir := path.Join(svc.ImagesDir, uidStr)
-	log.Printf("INFO: validate component settings for master fliles in %s", unitDir)
+	logger := getLogger(c.Request.Context())
+	logger.Info("validate component settings for master files", "dir", unitDir)
 	cmdArray := []string{"-json", "-iptc:OwnerID", unitDir}
-	cmd := exec.Command("exiftool", cmdArray...)
-	out, err := cmd.Output()
+	out, err := svc.ExifTool.run(cmdArray...)
 	if err != nil {
-		log.Printf("ERROR: unable to get conmponent data using %v: %s", cmd, out)
-		c.String(http.StatusInternalServerError, fmt.Sprintf("%s", out))
+		logger.Error("unable to get component data", "command", cmdArray, "error", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -92,7 +90,7 @@ func (svc *serviceContext) validateComponentSettings(c *gin.Context) {
 	}
 	parseErr := json.Unmarshal(out, &resp)
 	if parseErr != nil {
-		log.Printf("ERROR: unable to parse exif response: %s", parseErr.Error())
+		logger.Error("unable to parse exif response", "error", parseErr.Error())
 		c.String(http.StatusInternalServerError, parseErr.Error())
 		return
 	}
@@ -105,7 +103,7 @@ func (svc *serviceContext) validateComponentSettings(c *gin.Context) {
 			continue
 		}
 		if inf.Component == nil {
-			log.Printf("INFO: %s is missing component info", fn)
+			logger.Info("file is missing component info", "file", fn)
 			missing = append(missing, fn)
 		}
 	}
@@ -125,70 +123,55 @@ func (svc *serviceContext) getUnitMasterFiles(c *gin.Context) {
 	unitDir := path.Join(svc.ImagesDir, uidStr)
 	out := unitMasterfiles{MasterFiles: make([]*masterFileInfo, 0), Problems: make([]string, 0)}
 	start := time.Now()
-
-	log.Printf("INFO: get all master files from %s", unitDir)
+	logger := getLogger(c.Request.Context())
+	logger.Info("get all master files", "dir", unitDir)
 
 	// walk the unit directory and generate masterFile info for each .tif
 	mfRegex := regexp.MustCompile(`^\d{9}_\w{4,}\.tif$`)
 	tifRegex := regexp.MustCompile(`^.*\.tif$`)
 	hiddenRegex := regexp.MustCompile(`^\..*`)
-	err := filepath.Walk(unitDir, func(path string, f os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("WARNING: directory traverse failed: %s", err.Error())
-			return nil
+	for _, path := range svc.UnitIndex.files(unitDir) {
+		fName := filepath.Base(path)
+		if hiddenRegex.Match([]byte(fName)) {
+			logger.Info("skip hidden file", "file", fName)
+			continue
+		}
+		if isManifestFile(fName) {
+			continue
 		}
 
-		if !f.IsDir() {
-			fName := f.Name()
-			if hiddenRegex.Match([]byte(fName)) {
-				log.Printf("INFO: skipping hidden file %s", fName)
-				return nil
-			}
-
-			if !tifRegex.Match([]byte(fName)) {
-				log.Printf("INFO: %s is not a tif; skipping", fName)
-				out.Problems = append(out.Problems, fmt.Sprintf("%s is not an image file", path))
-				return nil
-			}
-
-			// NOTE: path param is the full path to the tif - including the filename
-			// Strip the base image dir and file name to get relative path
-			relPath := strings.Replace(path, fmt.Sprintf("%s/", svc.ImagesDir), "", 1)
-			relPath = strings.Replace(relPath, fmt.Sprintf("/%s", fName), "", 1)
-
-			if !mfRegex.Match([]byte(fName)) {
-				log.Printf("INFO: %s is named incorrectly", fName)
-				out.Problems = append(out.Problems, fmt.Sprintf("%s is named incorrectly", path))
-			}
-
-			mf := masterFileInfo{FileName: fName, Path: path}
-			fName = strings.ReplaceAll(fName, ".tif", "")
+		if !tifRegex.Match([]byte(fName)) {
+			logger.Info("file is not a tif; skip it", "file", fName)
+			out.Problems = append(out.Problems, fmt.Sprintf("%s is not an image file", path))
+			continue
+		}
 
-			if strings.Split(fName, "_")[0] != uidStr {
-				out.Problems = append(out.Problems, fmt.Sprintf("%s doesn't match unit number", path))
-			}
+		// NOTE: path is the full path to the tif - including the filename
+		// Strip the base image dir and file name to get relative path
+		relPath := strings.Replace(path, fmt.Sprintf("%s/", svc.ImagesDir), "", 1)
+		relPath = strings.Replace(relPath, fmt.Sprintf("/%s", fName), "", 1)
 
-			pathID := strings.Replace(relPath, "/", "%2F", -1)
-			mf.ThumbURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/full/!30,45/0/default.jpg", svc.IIIFURL, pathID, fName)
-			mf.MediumURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/full/!250,375/0/default.jpg", svc.IIIFURL, pathID, fName)
-			mf.LargeURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/full/!400,600/0/default.jpg", svc.IIIFURL, pathID, fName)
-			mf.InfoURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/info.json", svc.IIIFURL, pathID, fName)
+		if !mfRegex.Match([]byte(fName)) {
+			logger.Info("file is named incorrectly", "file", fName)
+			out.Problems = append(out.Problems, fmt.Sprintf("%s is named incorrectly", path))
+		}
 
-			out.MasterFiles = append(out.MasterFiles, &mf)
-			return nil
+		mf := masterFileInfo{FileName: fName, Path: path}
+		fName = strings.ReplaceAll(fName, ".tif", "")
 
+		if strings.Split(fName, "_")[0] != uidStr {
+			out.Problems = append(out.Problems, fmt.Sprintf("%s doesn't match unit number", path))
 		}
 
-		return nil
-	})
+		pathID := strings.Replace(relPath, "/", "%2F", -1)
+		mf.ThumbURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/full/!30,45/0/default.jpg", svc.IIIFURL, pathID, fName)
+		mf.MediumURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/full/!250,375/0/default.jpg", svc.IIIFURL, pathID, fName)
+		mf.LargeURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/full/!400,600/0/default.jpg", svc.IIIFURL, pathID, fName)
+		mf.InfoURL = fmt.Sprintf("%s/iiif/2/%s%%2F%s/info.json", svc.IIIFURL, pathID, fName)
 
-	if err != nil {
-		log.Printf("ERROR: unable to walk contents of %s: %s", unitDir, err.Error())
-		c.String(http.StatusInternalServerError, "unable to find units")
-		return
+		out.MasterFiles = append(out.MasterFiles, &mf)
 	}
 
-	log.Printf("INFO: found %d files in %s", len(out.MasterFiles), unitDir)
 	if len(out.MasterFiles) > 0 {
 		lastMF := out.MasterFiles[len(out.MasterFiles)-1]
 		fileBits := strings.Split(lastMF.FileName, "_")
@@ -204,9 +187,7 @@ func (svc *serviceContext) getUnitMasterFiles(c *gin.Context) {
 		out.Problems = append(out.Problems, "No images found")
 	}
 
-	elapsed := time.Since(start)
-	elapsedMS := int64(elapsed / time.Millisecond)
-	log.Printf("INFO: got %d masterfiles for unit %s in %dms", len(out.MasterFiles), uidStr, elapsedMS)
+	logger.Info("got master files", "files", len(out.MasterFiles), "elapsedMS", time.Since(start).Milliseconds())
 
 	c.JSON(http.StatusOK, out)
 }
@@ -215,14 +196,22 @@ func (svc *serviceContext) getUnitMasterFiles(c *gin.Context) {
 func (svc *serviceContext) getMasterFilesMetadata(c *gin.Context) {
 	uidStr := padLeft(c.Param("uid"), 9)
 	tgtFile := c.Query("file")
+	ctx := c.Request.Context()
+	logger := getLogger(ctx)
 	if tgtFile != "" {
-		log.Printf("INFO: get metadata for masterfile %s", tgtFile)
-		exifMD, err := getExifData(tgtFile)
+		logger.Info("get master file metadata", "file", tgtFile)
+		if cached, found := svc.MetadataCache.get(tgtFile); found {
+			c.JSON(http.StatusOK, cached)
+			return
+		}
+		readInfo, _ := os.Stat(tgtFile)
+		exifMD, err := svc.getExifData(ctx, tgtFile)
 		if err != nil {
-			log.Printf("ERROR: unable to get %s metadata: %s", tgtFile, err.Error())
+			logger.Error("unable to get master file metadata", "file", tgtFile, "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 		} else {
 			mfMD := parseExifData(exifMD)
+			svc.MetadataCache.put(tgtFile, readInfo, mfMD)
 			c.JSON(http.StatusOK, mfMD)
 		}
 		return
@@ -238,19 +227,26 @@ func (svc *serviceContext) getMasterFilesMetadata(c *gin.Context) {
 	}
 	unitDir := path.Join(svc.ImagesDir, uidStr)
 	startSeqNum := (currPage-1)*pageSize + 1
-	log.Printf("INFO: get %d metadata records from %s starting from masterfile index %d", pageSize, unitDir, startSeqNum)
+	logger.Info("get page of master file metadata", "dir", unitDir, "pageSize", pageSize, "startSeqNum", startSeqNum)
 
-	// use exiftool to get metadata for master files on the current page only
+	// use cached metadata where possible, otherwise get metadata for master files on the current page only
 	startTime := time.Now()
 	var mdWG sync.WaitGroup
 	tgtFiles := make([]string, 0)
 	mdChannel := make(chan masterFileMetadata)
+	out := make([]masterFileMetadata, 0)
+	unitFiles := svc.UnitIndex.fileMap(unitDir)
 
 	for i := 0; i < pageSize; i++ {
 		mfSeqNum := startSeqNum + i
 		filename := fmt.Sprintf("%s_%04d.tif", uidStr, mfSeqNum)
-		fullPath := findFile(unitDir, filename)
-		if fullPath != "" {
+		fullPath := unitFiles[filename]
+		if fullPath == "" {
+			continue
+		}
+		if cached, found := svc.MetadataCache.get(fullPath); found {
+			out = append(out, *cached)
+		} else {
 			tgtFiles = append(tgtFiles, fullPath)
 			if len(tgtFiles) == svc.BatchSize {
 				mdWG.Add(1)
@@ -258,7 +254,7 @@ func (svc *serviceContext) getMasterFilesMetadata(c *gin.Context) {
 				copy(filesCopy, tgtFiles)
 				go func() {
 					defer mdWG.Done()
-					getExifMetadataBatch(filesCopy, mdChannel)
+					svc.getExifMetadataBatch(ctx, filesCopy, mdChannel)
 				}()
 				tgtFiles = make([]string, 0)
 			}
@@ -269,19 +265,17 @@ func (svc *serviceContext) getMasterFilesMetadata(c *gin.Context) {
 		mdWG.Add(1)
 		go func() {
 			defer mdWG.Done()
-			getExifMetadataBatch(tgtFiles, mdChannel)
+			svc.getExifMetadataBatch(ctx, tgtFiles, mdChannel)
 		}()
 	}
 
 	go func() {
-		log.Printf("INFO: await all exif metadata responses")
+		logger.Info("await all exif metadata responses")
 		mdWG.Wait()
 		close(mdChannel)
-		elapsed := time.Since(startTime)
-		log.Printf("INFO: all hexif metadata requests have completed in %.3f sec", elapsed.Seconds())
+		logger.Info("all exif metadata requests have completed", "elapsedMS", time.Since(startTime).Milliseconds())
 	}()
 
-	out := make([]masterFileMetadata, 0)
 	for mdResp := range mdChannel {
 		out = append(out, mdResp)
 	}
@@ -293,67 +287,71 @@ func (svc *serviceContext) deleteFiles(c *gin.Context) {
 	rawUnitID := c.Param("uid")
 	uidStr := padLeft(rawUnitID, 9)
 	unitDir := path.Join(svc.ImagesDir, uidStr)
-	if svc.isBatchInProgress(rawUnitID) {
-		log.Printf("WARNING: request to delete files from unit %s rejected because it is already being processed", rawUnitID)
-		c.String(http.StatusConflict, "this unit is currently being processed by another user")
-		return
-	}
-
+	claims := getJWTClaims(c)
+	ctx := c.Request.Context()
+	logger := getLogger(ctx)
 	var delReq struct {
 		Filenames []string `json:"filenames"`
 	}
 	qpErr := c.ShouldBindJSON(&delReq)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid delete images payload for unit %s: %s", uidStr, qpErr)
+		logger.Error("invalid delete images payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
 
-	svc.addBatchProcess(rawUnitID)
-	defer svc.removeBatchProcess(rawUnitID)
+	lock, lockErr := svc.acquireUnitLock(ctx, rawUnitID, claims.ComputeID, "delete")
+	if lockErr != nil {
+		logger.Warn("delete rejected; unit is locked", "error", lockErr.Message)
+		c.String(lockErr.StatusCode, lockErr.Message)
+		return
+	}
+	defer svc.releaseUnitLock(lock)
 
+	unitID, _ := strconv.ParseUint(rawUnitID, 10, 64)
+	defer svc.UnitIndex.invalidate(unitDir)
 	for _, fn := range delReq.Filenames {
 		delPath := path.Join(unitDir, fn)
-		log.Printf("INFO: delete %s", delPath)
-		err := os.Remove(delPath)
+		logger.Info("move file to trash", "path", delPath)
+		trashPath, err := svc.trashFile(ctx, uint(unitID), delPath, claims.ComputeID)
 		if err != nil {
-			log.Printf("ERROR: unable to delete %s: %s", delPath, err.Error())
+			logger.Error("unable to delete file", "path", delPath, "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		svc.recordFileEvents(ctx, fileEvent{UnitID: uint(unitID), ComputeID: claims.ComputeID, Action: FileEventDelete, File: delPath,
+			OldValue: delPath, NewValue: trashPath})
 	}
 
 	c.String(http.StatusOK, "deleted")
 }
 
-func (svc *serviceContext) finalizeUnitData(proj *project) (*finalizeResponse, error) {
-	isManuscript := proj.Workflow.Name == "Manuscript"
+func (svc *serviceContext) finalizeUnitData(proj *project, rules *stepValidation, tgtJob *job) (*finalizeResponse, error) {
 	uid := padLeft(fmt.Sprintf("%d", proj.UnitID), 9)
 	unitDir := fmt.Sprintf("%s/%s", svc.ImagesDir, uid)
-	log.Printf("INFO: finalize unit %s", unitDir)
+	logger := tgtJob.logger()
+	logger.Info("finalize unit data", "dir", unitDir)
 
-	log.Printf("INFO: get info for unit %d", proj.UnitID)
-	mdBytes, reqErr := svc.getRequest(fmt.Sprintf("%s/units/%d", svc.TrackSys.API, proj.UnitID))
-	if reqErr != nil {
-		return nil, fmt.Errorf("unable to get unit info: %s", reqErr.Message)
-	}
-	var unitInfo struct {
-		MetadataPID string `json:"metadataPID"`
-		CallNumber  string `json:"callNumber"`
-	}
-	if err := json.Unmarshal(mdBytes, &unitInfo); err != nil {
-		return nil, err
+	logger.Info("get unit info")
+	unitInfo, err := svc.TrackSysAPI.unit(tgtJob.context(), int64(proj.UnitID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get unit info: %s", err.Error())
 	}
 
 	commandsBatch := make([]exifFileCommands, 0)
 	errChannel := make(chan updateProblem)
 	var batchWG sync.WaitGroup
+	fileDone := func(file string) {
+		svc.jobFileProcessed(tgtJob, filepath.Base(file))
+	}
+	svc.jobPhase(tgtJob, "finalize")
 
 	// walk the unit directory and generate masterFile info for each .tif
 	mfRegex := regexp.MustCompile(`^\d{9}_\w{4,}\.tif$`)
 	tifRegex := regexp.MustCompile(`^.*\.tif$`)
 	hiddenRegex := regexp.MustCompile(`^\..*`)
-	err := filepath.WalkDir(unitDir, func(path string, entry fs.DirEntry, err error) error {
+	walkStart := time.Now()
+	err = filepath.WalkDir(unitDir, func(path string, entry fs.DirEntry, err error) error {
 		if err != nil || entry.IsDir() {
 			return nil
 		}
@@ -371,7 +369,7 @@ func (svc *serviceContext) finalizeUnitData(proj *project) (*finalizeResponse, e
 		cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:MasterDocumentID=%s", fmt.Sprintf("UVA Library: %s", id)))
 		cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:ObjectName=%s", fName))
 		cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:ClassifyState=%s", "")) // clear tag info
-		if isManuscript {
+		if rules.ClearLocation {
 			cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:Keywords=%s", ""))            // clear temp box info
 			cmd.Commands = append(cmd.Commands, fmt.Sprintf("-iptc:ContentLocationName=%s", "")) // clear temp folder info
 		}
@@ -381,17 +379,19 @@ func (svc *serviceContext) finalizeUnitData(proj *project) (*finalizeResponse, e
 
 		if len(commandsBatch) == svc.BatchSize {
 			batchWG.Add(1)
+			svc.jobAddWork(tgtJob, len(commandsBatch))
 			cmdCopy := make([]exifFileCommands, len(commandsBatch))
 			copy(cmdCopy, commandsBatch)
 			go func() {
 				defer batchWG.Done()
-				batchUpdateExifData(cmdCopy, errChannel)
+				svc.batchUpdateExifData(tgtJob.context(), cmdCopy, nil, errChannel, fileDone)
 			}()
 			commandsBatch = make([]exifFileCommands, 0)
 		}
 
 		return nil
 	})
+	observeDirWalk("finalize", walkStart)
 
 	if err != nil {
 		return nil, err
@@ -399,9 +399,10 @@ func (svc *serviceContext) finalizeUnitData(proj *project) (*finalizeResponse, e
 
 	if len(commandsBatch) > 0 {
 		batchWG.Add(1)
+		svc.jobAddWork(tgtJob, len(commandsBatch))
 		go func() {
 			defer batchWG.Done()
-			batchUpdateExifData(commandsBatch, errChannel)
+			svc.batchUpdateExifData(tgtJob.context(), commandsBatch, nil, errChannel, fileDone)
 		}()
 	}
 
@@ -410,7 +411,7 @@ func (svc *serviceContext) finalizeUnitData(proj *project) (*finalizeResponse, e
 	resp.Problems = make([]updateProblem, 0)
 
 	go func() {
-		log.Printf("INFO: await all finalization updates for %s", uid)
+		logger.Info("await all finalization updates")
 		batchWG.Wait()
 		close(errChannel)
 	}()
@@ -418,9 +419,10 @@ func (svc *serviceContext) finalizeUnitData(proj *project) (*finalizeResponse, e
 	for problem := range errChannel {
 		resp.Success = false
 		resp.Problems = append(resp.Problems, problem)
+		svc.jobProblem(tgtJob, problem)
 	}
 
-	log.Printf("INFO: all finalization updates for %s are done", uid)
+	logger.Info("all finalization updates are done", "problems", len(resp.Problems))
 
 	return &resp, nil
 }
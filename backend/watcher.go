@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+)
+
+// Changes in a unit directory usually come in bursts (a scan session, a copy or a batch rename) so the
+// unit is only checked once the directory has been quiet for this long
+const watchSettleDelay = 5 * time.Second
+
+var unitDirRegex = regexp.MustCompile(`^\d{9}$`)
+var masterFileRegex = regexp.MustCompile(`^\d{9}_\w{4,}\.tif$`)
+
+// unexpectedFile is a file in a unit directory that will cause problems when the step is finished
+type unexpectedFile struct {
+	ID         int64      `json:"id"`
+	ProjectID  uint       `json:"projectID"`
+	UnitID     uint       `json:"unitID"`
+	Path       string     `json:"path"`
+	Reason     string     `json:"reason"`
+	DetectedAt time.Time  `json:"detectedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// dirWatcher watches the unit directories in the scan and images directories. Activity in a unit
+// directory updates the project image count and last file activity time and checks for unexpected files.
+type dirWatcher struct {
+	svc     *serviceContext
+	watcher *fsnotify.Watcher
+	roots   []string
+	mutex   sync.Mutex
+	pending map[string]*time.Timer
+}
+
+func (svc *serviceContext) startDirWatcher(roots ...string) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("unable to create directory watcher", "error", err.Error())
+		return
+	}
+	dw := dirWatcher{svc: svc, watcher: fsw, roots: roots, pending: make(map[string]*time.Timer)}
+	for _, root := range roots {
+		slog.Info("watch unit directories", "dir", root)
+		if err := fsw.Add(root); err != nil {
+			slog.Error("unable to watch directory", "dir", root, "error", err.Error())
+			continue
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			slog.Error("unable to read directory", "dir", root, "error", err.Error())
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() && unitDirRegex.MatchString(e.Name()) {
+				dw.addTree(path.Join(root, e.Name()))
+			}
+		}
+	}
+	slog.Info("directory watcher started", "dirCount", len(fsw.WatchList()))
+	go dw.run()
+}
+
+// addTree adds a watch to a directory and all of its subdirectories. fsnotify does not watch recursively
+func (dw *dirWatcher) addTree(dir string) {
+	if err := dw.watcher.Add(dir); err != nil {
+		slog.Error("unable to watch directory", "dir", dir, "error", err.Error())
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			dw.addTree(path.Join(dir, e.Name()))
+		}
+	}
+}
+
+func (dw *dirWatcher) run() {
+	for {
+		select {
+		case evt, ok := <-dw.watcher.Events:
+			if !ok {
+				return
+			}
+			dw.handleEvent(evt)
+		case err, ok := <-dw.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("directory watcher failed", "error", err.Error())
+		}
+	}
+}
+
+func (dw *dirWatcher) handleEvent(evt fsnotify.Event) {
+	unitDir := dw.unitDirectory(evt.Name)
+	if unitDir == "" {
+		return
+	}
+
+	if evt.Has(fsnotify.Create) {
+		if info, err := os.Stat(evt.Name); err == nil && info.IsDir() {
+			dw.addTree(evt.Name)
+		} else if reason := unexpectedFileReason(unitDir, path.Base(evt.Name), true); reason != "" {
+			slog.Warn("unexpected file", "file", evt.Name, "reason", reason)
+		}
+	}
+
+	// restart the settle timer for the unit
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	if timer, found := dw.pending[unitDir]; found {
+		timer.Reset(watchSettleDelay)
+		return
+	}
+	dw.pending[unitDir] = time.AfterFunc(watchSettleDelay, func() {
+		dw.mutex.Lock()
+		delete(dw.pending, unitDir)
+		dw.mutex.Unlock()
+		dw.svc.unitFilesChanged(unitDir)
+	})
+}
+
+// unitDirectory returns the unit directory that contains the target path, or an empty string if
+// the path is not in a unit directory
+func (dw *dirWatcher) unitDirectory(tgtPath string) string {
+	for _, root := range dw.roots {
+		rel := strings.TrimPrefix(tgtPath, root+"/")
+		if rel == tgtPath {
+			continue
+		}
+		unitDir := strings.Split(rel, "/")[0]
+		if unitDirRegex.MatchString(unitDir) {
+			return path.Join(root, unitDir)
+		}
+	}
+	return ""
+}
+
+// unexpectedFileReason returns the reason a file does not belong in the unit directory, or an empty string
+// if the file is fine. Notes are only allowed by steps that allow them.
+func unexpectedFileReason(unitDir string, fileName string, notesAllowed bool) string {
+	switch {
+	case strings.HasPrefix(fileName, "."):
+		return "hidden file"
+	case isManifestFile(fileName):
+		return ""
+	case strings.ToLower(fileName) == "notes.txt":
+		if !notesAllowed {
+			return "notes are not allowed for this step"
+		}
+		return ""
+	case !strings.HasSuffix(strings.ToLower(fileName), ".tif"):
+		return "not a tif image"
+	case !masterFileRegex.MatchString(fileName):
+		return "incorrectly named"
+	case strings.Split(fileName, "_")[0] != path.Base(unitDir):
+		return "does not match unit number"
+	}
+	return ""
+}
+
+// unitFilesChanged updates the project for a unit after activity in its directory settles
+func (svc *serviceContext) unitFilesChanged(unitDir string) {
+	unitID, _ := strconv.ParseUint(path.Base(unitDir), 10, 64)
+	logger := slog.With("unitID", unitID)
+	var proj project
+	if err := svc.DB.Preload("CurrentStep").Where("unit_id=? and finished_at is null", unitID).Limit(1).Find(&proj).Error; err != nil {
+		logger.Error("unable to get project", "error", err.Error())
+		return
+	}
+	if proj.ID == 0 {
+		return
+	}
+	logger = logger.With("projectID", proj.ID)
+
+	// the directory may be gone if files were moved to another directory for the next step
+	svc.UnitIndex.invalidate(unitDir)
+	files := make([]string, 0)
+	if exists(unitDir) {
+		files = svc.UnitIndex.files(unitDir)
+	}
+
+	now := time.Now()
+	updates := map[string]any{"last_file_activity": now}
+	unexpected := make(map[string]string)
+	notesAllowed := proj.CurrentStep != nil && svc.getStepValidation(proj.CurrentStep).AllowNotes
+	mfCnt := 0
+	for _, fullPath := range files {
+		fileName := path.Base(fullPath)
+		if reason := unexpectedFileReason(unitDir, fileName, notesAllowed); reason != "" {
+			unexpected[fullPath] = reason
+		}
+		if masterFileRegex.MatchString(fileName) {
+			mfCnt++
+		}
+	}
+
+	// only the directory the unit is currently in sets the count; not one that it is being moved out of.
+	// Steps that do not update the image count when finished leave it alone here too
+	updateCount := proj.CurrentStep != nil && svc.getStepValidation(proj.CurrentStep).UpdateImageCount
+	if updateCount && len(files) > 0 && mfCnt != proj.ImageCount {
+		logger.Info("image count changed", "from", proj.ImageCount, "to", mfCnt)
+		updates["image_count"] = mfCnt
+	}
+	if err := svc.DB.Table("projects").Where("id=?", proj.ID).Updates(updates).Error; err != nil {
+		logger.Error("unable to update file activity", "error", err.Error())
+	}
+
+	svc.syncUnexpectedFiles(logger, &proj, unitDir, unexpected)
+}
+
+// syncUnexpectedFiles records newly found unexpected files and resolves any that are no longer present
+func (svc *serviceContext) syncUnexpectedFiles(logger *slog.Logger, proj *project, unitDir string, unexpected map[string]string) {
+	var existing []unexpectedFile
+	if err := svc.DB.Where("project_id=? and resolved_at is null and path like ?", proj.ID, unitDir+"/%").Find(&existing).Error; err != nil {
+		logger.Error("unable to get unexpected files", "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, uf := range existing {
+		if _, found := unexpected[uf.Path]; found {
+			delete(unexpected, uf.Path)
+			continue
+		}
+		logger.Info("unexpected file is no longer present", "file", uf.Path)
+		svc.DB.Model(&uf).Update("resolved_at", now)
+	}
+
+	for filePath, reason := range unexpected {
+		logger.Warn("unexpected file", "file", filePath, "reason", reason)
+		uf := unexpectedFile{ProjectID: proj.ID, UnitID: proj.UnitID, Path: filePath, Reason: reason, DetectedAt: now}
+		if err := svc.DB.Create(&uf).Error; err != nil {
+			logger.Error("unable to record unexpected file", "file", filePath, "error", err.Error())
+		}
+	}
+}
+
+func (svc *serviceContext) getUnexpectedFiles(c *gin.Context) {
+	projID := c.Param("id")
+	logger := getLogger(c.Request.Context())
+	out := make([]unexpectedFile, 0)
+	if err := svc.DB.Where("project_id=? and resolved_at is null", projID).Order("detected_at asc").Find(&out).Error; err != nil {
+		logger.Error("unable to get unexpected files", "projectID", projID, "error", err.Error())
+		c.String(http.StatusInternalServerError, fmt.Sprintf("unable to get unexpected files: %s", err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path"
+	"testing"
+)
+
+func TestUnitFilesChangedNotes(t *testing.T) {
+	unexpectedNotes := func(t *testing.T, allowNotes bool) []unexpectedFile {
+		ts := newWorkflowTestService(t)
+		if err := ts.DB.Model(&stepValidation{}).Where("step_id=?", testStepQA).Update("allow_notes", allowNotes).Error; err != nil {
+			t.Fatalf("unable to update step validation: %s", err.Error())
+		}
+		proj := ts.createProject(t, testUnitID, testStepQA, StepWorking, 2)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "notes.txt")
+
+		ts.unitFilesChanged(unitDir)
+		var found []unexpectedFile
+		if err := ts.DB.Where("project_id=? and resolved_at is null", proj.ID).Find(&found).Error; err != nil {
+			t.Fatalf("unable to get unexpected files: %s", err.Error())
+		}
+		return found
+	}
+
+	t.Run("step allows notes", func(t *testing.T) {
+		if found := unexpectedNotes(t, true); len(found) > 0 {
+			t.Errorf("expected no unexpected files, got %+v", found)
+		}
+	})
+
+	t.Run("step does not allow notes", func(t *testing.T) {
+		found := unexpectedNotes(t, false)
+		if len(found) != 1 || path.Base(found[0].Path) != "notes.txt" {
+			t.Errorf("expected notes.txt to be unexpected, got %+v", found)
+		}
+	})
+}
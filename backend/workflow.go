@@ -1,19 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/fs"
-	"log"
 	"net/http"
-	"os"
-	"os/exec"
 	"path"
-	"path/filepath"
-	"slices"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -31,25 +23,26 @@ const (
 	StepWorking    = 7 // finish has been clicked, step validations in-progress
 )
 
-func (svc *serviceContext) getProjectInfo(projID string) (*project, error) {
-	log.Printf("INFO: look up basic info for project %s", projID)
+func (svc *serviceContext) getProjectInfo(ctx context.Context, projID string) (*project, error) {
+	logger := getLogger(ctx)
+	logger.Info("look up basic project info")
 	var tgtProject *project
 	if err := svc.DB.Preload("CurrentStep").Preload("Workflow").First(&tgtProject, projID).Error; err != nil {
 		return nil, err
 	}
 
-	log.Printf("INFO: get project %d external unit data", tgtProject.ID)
-	if err := svc.getProjectUnitDetails(tgtProject); err != nil {
+	logger.Info("get project external unit data")
+	if err := svc.getProjectUnitDetails(ctx, tgtProject); err != nil {
 		return nil, err
 	}
 
-	log.Printf("INFO: get project %d assignments", tgtProject.ID)
+	logger.Info("get project assignments")
 	if err := svc.DB.Where("project_id=?", tgtProject.ID).Joins("Step").
 		Order("assigned_at DESC").Find(&tgtProject.Assignments).Error; err != nil {
 		return nil, err
 	}
 
-	log.Printf("INFO: get project %d notes", tgtProject.ID)
+	logger.Info("get project notes")
 	if err := svc.DB.Where("project_id=?", tgtProject.ID).Joins("Step").Preload("Problems").
 		Order("notes.created_at DESC").Find(&tgtProject.Notes).Error; err != nil {
 		return nil, err
@@ -60,35 +53,36 @@ func (svc *serviceContext) getProjectInfo(projID string) (*project, error) {
 
 func (svc *serviceContext) startProjectStep(c *gin.Context) {
 	projID := c.Param("id")
-	claims := getJWTClaims(c)
-	log.Printf("INFO: user %s is looking for project %s to start active step", claims.ComputeID, projID)
-	proj, err := svc.getProjectInfo(projID)
+	logger := getLogger(c.Request.Context())
+	logger.Info("look up project to start active step")
+	proj, err := svc.getProjectInfo(c.Request.Context(), projID)
 	if err != nil {
-		log.Printf("ERROR: unable to getp project %s: %s", projID, err.Error())
+		logger.Error("unable to get project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	log.Printf("INFO: user %s is starting [%s] for project %s", claims.ComputeID, proj.CurrentStep.Name, projID)
+	logger = logger.With("step", proj.CurrentStep.Name)
+	logger.Info("start step")
 
 	startTime := time.Now()
 	if proj.StartedAt == nil {
-		log.Printf("INFO: setting project %s step %s start time to %v", projID, proj.CurrentStep.Name, startTime)
+		logger.Info("set project start time", "startedAt", startTime)
 		proj.StartedAt = &startTime
 		err := svc.DB.Model(&proj).Select("started_at").Updates(proj).Error
 		if err != nil {
-			log.Printf("ERROR: unable to update project %d start time: %s", proj.ID, err.Error())
+			logger.Error("unable to update project start time", "error", err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 	}
 
 	currA := proj.Assignments[0]
-	log.Printf("INFO: start project %d assignment %d", proj.ID, currA.ID)
+	logger.Info("start assignment", "assignmentID", currA.ID)
 	currA.StartedAt = &startTime
 	currA.Status = StepStarted
 	err = svc.DB.Model(&currA).Select("StartedAt", "Status").Updates(currA).Error
 	if err != nil {
-		log.Printf("ERROR: unable to update project %d step %d start time: %s", proj.ID, currA.StepID, err.Error())
+		logger.Error("unable to update assignment start time", "assignmentID", currA.ID, "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -97,21 +91,21 @@ func (svc *serviceContext) startProjectStep(c *gin.Context) {
 
 func (svc *serviceContext) rejectProjectStep(c *gin.Context) {
 	projID := c.Param("id")
-	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	var doneReq struct {
 		DurationMins uint `json:"durationMins"`
 	}
 
 	qpErr := c.ShouldBindJSON(&doneReq)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid reject step payload: %v", qpErr)
+		logger.Error("invalid reject step payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	log.Printf("INFO: user %s is rejecting active step in project %s with duration %d", claims.ComputeID, projID, doneReq.DurationMins)
-	proj, err := svc.getProjectInfo(projID)
+	logger.Info("reject active step", "durationMins", doneReq.DurationMins)
+	proj, err := svc.getProjectInfo(c.Request.Context(), projID)
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s: %s", projID, err.Error())
+		logger.Error("unable to get project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -123,7 +117,7 @@ func (svc *serviceContext) rejectProjectStep(c *gin.Context) {
 	currA.Status = StepRejected
 	err = svc.DB.Model(&currA).Select("DurationMinutes", "FinishedAt", "Status").Updates(currA).Error
 	if err != nil {
-		log.Printf("ERROR: unable to reject assignment: %s", err.Error())
+		logger.Error("unable to reject assignment", "assignmentID", currA.ID, "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -131,162 +125,205 @@ func (svc *serviceContext) rejectProjectStep(c *gin.Context) {
 	// all errors go back to the scanner... the owner of the first step
 	failStepID := proj.CurrentStep.FailStepID
 	firstA := proj.Assignments[len(proj.Assignments)-1]
+	logger.Info("return project to the original owner", "step", proj.CurrentStep.Name, "failStepID", failStepID, "ownerID", firstA.StaffMemberID)
 	proj.OwnerID = &firstA.StaffMemberID
 	proj.CurrentStepID = &failStepID
 	svc.DB.Model(proj).Select("CurrentStepID", "OwnerID").Updates(proj)
 	newAssign := assignment{ProjectID: proj.ID, StepID: failStepID, StaffMemberID: firstA.StaffMemberID, AssignedAt: &now}
 	svc.DB.Create(&newAssign)
 
-	proj, _ = svc.getProjectInfo(projID)
+	proj, _ = svc.getProjectInfo(c.Request.Context(), projID)
 	c.JSON(http.StatusOK, proj)
 }
 
 func (svc *serviceContext) finishProjectStep(c *gin.Context) {
 	projID := c.Param("id")
 	claims := getJWTClaims(c)
+	logger := getLogger(c.Request.Context())
 	var doneReq struct {
 		DurationMins uint `json:"durationMins"`
 	}
 
 	qpErr := c.ShouldBindJSON(&doneReq)
 	if qpErr != nil {
-		log.Printf("ERROR: invalid finish step payload: %v", qpErr)
+		logger.Error("invalid finish step payload", "error", qpErr.Error())
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
 
-	proj, err := svc.getProjectInfo(projID)
+	proj, err := svc.getProjectInfo(c.Request.Context(), projID)
 	if err != nil {
-		log.Printf("ERROR: unable to get project %s: %s", projID, err.Error())
+		logger.Error("unable to get project", "error", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	log.Printf("INFO: user %s is finishing [%s] step in project [%s] with duration %d", claims.ComputeID, proj.CurrentStep.Name, projID, doneReq.DurationMins)
-	noCountSteps := []string{"Scan", "Process", "Create Metadata"}
-	updateCount := !slices.Contains(noCountSteps, proj.CurrentStep.Name)
+	logger = logger.With("step", proj.CurrentStep.Name, "unitID", proj.UnitID)
+	logger.Info("finish step requested", "durationMins", doneReq.DurationMins)
 
 	// First finish attempt includes a non-zero duration. Record it.
 	// If a step fails and is corrected, 0 duration will be passed. Just
 	// preserve the original duration. Requested by Sam P.
 	currA := proj.Assignments[0]
 	if currA.Status == StepWorking {
-		log.Printf("ERROR: user %s attempt to finish [%s] step in project [%s] that is already in process", claims.ComputeID, proj.CurrentStep.Name, projID)
+		logger.Error("finish rejected; step is already in process")
 		c.String(http.StatusBadRequest, "step finish is already in-process")
 		return
 	}
 	if currA.Status != StepStarted && currA.Status != StepError {
-		log.Printf("ERROR: user %s attempt to finish [%s] step in project [%s] that is not started", claims.ComputeID, proj.CurrentStep.Name, projID)
+		logger.Error("finish rejected; step has not been started", "assignmentStatus", currA.Status)
 		c.String(http.StatusBadRequest, "step has not been started")
 		return
 	}
 	if doneReq.DurationMins > 0 {
-		log.Printf("INFO: set duration %d for assignment %d", doneReq.DurationMins, currA.ID)
+		logger.Info("set assignment duration", "assignmentID", currA.ID, "durationMins", doneReq.DurationMins)
 		currA.DurationMinutes = doneReq.DurationMins
 		svc.DB.Model(&currA).Select("DurationMinutes").Updates(currA)
 	}
 
-	log.Printf("INFO: mark step [%s] in project [%s] as working", proj.CurrentStep.Name, projID)
+	logger.Info("mark step as working", "assignmentID", currA.ID)
 	currA.Status = StepWorking
 	svc.DB.Model(&currA).Select("Status").Updates(currA)
 
+	// validations, file moves and finalization can take a long time for large units. They are
+	// done in a background job; the client polls the job to see when the step is done.
+	finishJob := job{JobType: jobFinishStep, UnitID: proj.UnitID, ProjectID: proj.ID, UserID: claims.UserID, Owner: claims.ComputeID}
+	if err := svc.enqueueJob(c.Request.Context(), &finishJob, nil); err != nil {
+		logger.Error("unable to queue finish step job", "error", err.Error())
+		currA.Status = StepError
+		svc.DB.Model(&currA).Select("Status").Updates(currA)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusAccepted, finishJob)
+}
+
+func (svc *serviceContext) runFinishStepJob(tgtJob *job) error {
+	projID := fmt.Sprintf("%d", tgtJob.ProjectID)
+	proj, err := svc.getProjectInfo(tgtJob.context(), projID)
+	if err != nil {
+		return fmt.Errorf("unable to get project %s: %s", projID, err.Error())
+	}
+	logger := tgtJob.logger().With("step", proj.CurrentStep.Name)
+	updateCount := svc.getStepValidation(proj.CurrentStep).UpdateImageCount
+
+	// the project may have been reassigned or its step changed while the job was queued. The step is
+	// only finished if the assignment is still marked working by the user that requested the finish
+	if len(proj.Assignments) == 0 {
+		logger.Error("project has no assignments to finish")
+		return fmt.Errorf("project %s has no assignments", projID)
+	}
+	currA := proj.Assignments[0]
+	if currA.Status != StepWorking || currA.StaffMemberID != tgtJob.UserID || currA.StepID != proj.CurrentStep.ID {
+		logger.Error("assignment is no longer being finished by the job owner", "assignmentID", currA.ID,
+			"assignmentStatus", currA.Status, "staffMemberID", currA.StaffMemberID)
+		return fmt.Errorf("the %s step of project %s is no longer being finished by %s", proj.CurrentStep.Name, projID, tgtJob.Owner)
+	}
+
+	// the unit files are validated, prepped and moved by the finish, so no other change to them can run
+	lock, lockErr := svc.acquireUnitLock(tgtJob.context(), fmt.Sprintf("%d", proj.UnitID), tgtJob.Owner, "finish")
+	if lockErr != nil {
+		logger.Error("unable to lock unit for finish", "error", lockErr.Message)
+		svc.failStep(tgtJob.context(), proj, "Other", fmt.Sprintf("<p>%s. Please finish the step again once it is done.</p>", lockErr.Message))
+		return errors.New(lockErr.Message)
+	}
+	defer svc.releaseUnitLock(lock)
+
 	// validate the directory, images names and metadata (if applicable)
-	validateErr := svc.validateFinishStep(proj)
+	validateErr := svc.validateFinishStep(proj, tgtJob)
 	if validateErr != nil {
-		log.Printf("ERROR: unable to finish project %s step %s: %s", projID, proj.CurrentStep.Name, validateErr.Error())
-		proj, _ = svc.getProjectInfo(projID)
-		c.JSON(http.StatusOK, proj)
-		return
+		return fmt.Errorf("unable to finish project %s step %s: %s", projID, proj.CurrentStep.Name, validateErr.Error())
 	}
+	svc.resolveValidationIssues(proj.ID)
 
 	// is this the last step of a workflow?
 	if proj.CurrentStep.StepType == 1 {
 		currA.Status = StepFinalizing
 		svc.DB.Model(&currA).Select("Status").Updates(currA)
 
-		log.Printf("INFO: sending request to dpg-jobs to begin or restart finalization of unit %d", proj.UnitID)
-		_, httpErr := svc.postJobsRequest(fmt.Sprintf("%s/units/%d/finalize", svc.TrackSys.Jobs, proj.UnitID), nil, getJWT(c))
-		if httpErr != nil {
-			log.Printf("ERROR: finalize request failed: %s", httpErr.Message)
-			msg := fmt.Sprintf("<p>Request to start finalization failed: %s</p>", httpErr.Message)
-			svc.failStep(proj, "Other", msg)
-			return
+		logger.Info("send request to dpg-jobs to begin or restart finalization")
+		jwt, jwtErr := svc.getJobJWT(tgtJob)
+		if jwtErr != nil {
+			msg := fmt.Sprintf("<p>Request to start finalization failed: %s</p>", jwtErr.Error())
+			svc.failStep(tgtJob.context(), proj, "Other", msg)
+			return jwtErr
 		}
-
-		proj, _ = svc.getProjectInfo(projID)
-		c.JSON(http.StatusOK, proj)
-		return
+		if err := svc.Jobs.finalizeUnit(tgtJob.context(), proj.UnitID, jwt); err != nil {
+			logger.Error("finalize request failed", "error", err.Error())
+			msg := fmt.Sprintf("<p>Request to start finalization failed: %s</p>", err.Error())
+			svc.failStep(tgtJob.context(), proj, "Other", msg)
+			return fmt.Errorf("finalize request failed: %s", err.Error())
+		}
+		return nil
 	}
 
-	log.Printf("INFO: mark assignment %d finished", currA.ID)
+	logger.Info("mark assignment finished", "assignmentID", currA.ID)
 	nowTimeStamp := time.Now()
 	currA.FinishedAt = &nowTimeStamp
 	currA.Status = StepFinished
 	err = svc.DB.Model(&currA).Select("FinishedAt", "Status").Updates(currA).Error
 	if err != nil {
-		log.Printf("ERROR: unable to update project %d step %d finish time: %s", proj.ID, currA.StepID, err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return fmt.Errorf("unable to update project %d step %d finish time: %s", proj.ID, currA.StepID, err.Error())
 	}
 
 	var nextStep step
 	nextStepID := proj.CurrentStep.NextStepID
-	log.Printf("INFO: advance to next step: %d", nextStepID)
+	logger.Info("advance to next step", "nextStepID", nextStepID)
 	err = svc.DB.First(&nextStep, nextStepID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to get project %d next step %d: %s", proj.ID, nextStepID, err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return fmt.Errorf("unable to get project %d next step %d: %s", proj.ID, nextStepID, err.Error())
 	}
 
-	log.Printf("INFO: enforce next step %s owner type %d", nextStep.Name, nextStep.OwnerType)
+	logger = logger.With("nextStep", nextStep.Name)
+	logger.Info("enforce next step owner type", "ownerType", nextStep.OwnerType)
 	switch nextStep.OwnerType {
 	case 1:
 		// prior owner
-		log.Printf("INFO: project %s workflow %s advancing to new step %s with current owner %s",
-			projID, proj.Workflow.Name, nextStep.Name, svc.getComputeID(*proj.OwnerID))
-		err = svc.nextStep(proj, nextStepID, proj.OwnerID)
+		logger.Info("advance to next step with current owner", "owner", svc.getComputeID(*proj.OwnerID))
+		err = svc.nextStep(tgtJob.context(), proj, nextStepID, proj.OwnerID)
 	case 3:
 		// original owner
 		firstA := proj.Assignments[len(proj.Assignments)-1]
-		log.Printf("INFO: project %s workflow %s advancing to new step %s with originial owner %s",
-			projID, proj.Workflow.Name, nextStep.Name, svc.getComputeID(firstA.StaffMemberID))
-		err = svc.nextStep(proj, nextStepID, &firstA.StaffMemberID)
+		logger.Info("advance to next step with original owner", "owner", svc.getComputeID(firstA.StaffMemberID))
+		err = svc.nextStep(tgtJob.context(), proj, nextStepID, &firstA.StaffMemberID)
 	default:
 		// any, unique or supervisor for this step. Someone must claim it, so set owner nil.
-		log.Printf("INFO: project %s workflow %s advancing to new step %s with no owner set", projID, proj.Workflow.Name, nextStep.Name)
-		err = svc.nextStep(proj, nextStepID, nil)
+		logger.Info("advance to next step with no owner set")
+		err = svc.nextStep(tgtJob.context(), proj, nextStepID, nil)
 	}
 
 	if err != nil {
-		log.Printf("ERROR: unable to advance step: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return fmt.Errorf("unable to advance step: %s", err.Error())
 	}
 
 	if updateCount {
-		log.Printf("INFO: finishing this step triggers an image count update")
+		logger.Info("finishing this step triggers an image count update")
 		mfCnt := svc.getImageCount(proj.UnitID)
 		if mfCnt != proj.ImageCount {
-			log.Printf("INFO: update project %s image count to %d", projID, mfCnt)
+			logger.Info("update project image count", "imageCount", mfCnt)
 			proj.ImageCount = mfCnt
 			err = svc.DB.Table("projects").Where("id = ?", proj.ID).Update("image_count", mfCnt).Error
 			if err != nil {
-				log.Printf("ERROR: unable to update project %s image count to %d: %s", projID, mfCnt, err.Error())
-				c.String(http.StatusInternalServerError, err.Error())
-				return
+				return fmt.Errorf("unable to update project %s image count to %d: %s", projID, mfCnt, err.Error())
 			}
 		} else {
-			log.Printf("INFO: project %d already has image count %d; no update needed", proj.ID, mfCnt)
+			logger.Info("project image count is up to date", "imageCount", mfCnt)
 		}
 	}
+	return nil
+}
 
-	// reload project to reflect changes and send result to client
-	proj, _ = svc.getProjectInfo(projID)
-	c.JSON(http.StatusOK, proj)
+// getJobJWT generates a token for the user that started a job so the job can make authorized calls to dpg-jobs
+func (svc *serviceContext) getJobJWT(tgtJob *job) (string, error) {
+	sm, err := svc.getStaff(tgtJob.UserID)
+	if err != nil {
+		return "", fmt.Errorf("unable to get job owner %s: %s", tgtJob.Owner, err.Error())
+	}
+	return svc.generateJWT(tgtJob.Owner, sm)
 }
 
-func (svc *serviceContext) nextStep(proj *project, nextStepID uint, ownerID *uint) error {
-	log.Printf("INFO: advance project %d to step %d", proj.ID, nextStepID)
+func (svc *serviceContext) nextStep(ctx context.Context, proj *project, nextStepID uint, ownerID *uint) error {
+	logger := getLogger(ctx)
+	logger.Info("advance project to step", "nextStepID", nextStepID)
 	proj.CurrentStepID = &nextStepID
 	proj.OwnerID = ownerID
 	resp := svc.DB.Model(proj).Select("CurrentStepID", "OwnerID").Updates(proj)
@@ -295,7 +332,7 @@ func (svc *serviceContext) nextStep(proj *project, nextStepID uint, ownerID *uin
 	}
 
 	if ownerID != nil {
-		log.Printf("INFO: assign step %d to staff %d", nextStepID, *ownerID)
+		logger.Info("assign step to staff", "nextStepID", nextStepID, "ownerID", *ownerID)
 		now := time.Now()
 		newAssign := assignment{ProjectID: proj.ID, StepID: nextStepID, StaffMemberID: *ownerID, AssignedAt: &now}
 		resp := svc.DB.Create(&newAssign)
@@ -306,320 +343,256 @@ func (svc *serviceContext) nextStep(proj *project, nextStepID uint, ownerID *uin
 	return nil
 }
 
-func (svc *serviceContext) validateFinishStep(proj *project) error {
-	log.Printf("INFO: validate project [%d] step [%s] finish", proj.ID, proj.CurrentStep.Name)
+// validateFinishStep checks that the project step can be finished and prepares the files for the next step.
+// Per-file progress and problems are reported to tgtJob, which may be nil.
+func (svc *serviceContext) validateFinishStep(proj *project, tgtJob *job) error {
+	logger := tgtJob.logger().With("step", proj.CurrentStep.Name)
+	logger.Info("validate step finish")
+	rules := svc.getStepValidation(proj.CurrentStep)
 
-	isManuscript := proj.Workflow.Name == "Manuscript"
-	if isManuscript && proj.ContainerTypeID == nil {
-		// container type is required for manuscript workflows
-		svc.failStep(proj, "Other", "<p>This project is missing the required Container Type setting.</p>")
-		return errors.New("manuscript is missing container type")
+	// container type is required by the steps of manuscript workflows
+	if rules.ContainerTypeRequired && proj.ContainerTypeID == nil {
+		svc.failStep(tgtJob.context(), proj, "Other", "<p>This project is missing the required Container Type setting.</p>",
+			newValidationIssue(RuleContainerType, "", "This project is missing the required Container Type setting"))
+		return errors.New("project is missing container type")
 	}
 
 	//  When finishing the final QA step, call finalize on the viewer to cleanup up and apply final metadata to each image
-	if proj.CurrentStep.NextStepID > 0 && svc.nextStepName(proj) == "Finalize" {
-		log.Printf("INFO: finishing final qa step; prep images for finalization in unit %d", proj.UnitID)
-		resp, err := svc.finalizeUnitData(proj)
+	if rules.FinalizePrep {
+		logger.Info("finishing final qa step; prep images for finalization")
+		resp, err := svc.finalizeUnitData(proj, rules, tgtJob)
 		if err != nil {
-			log.Printf("ERROR: unable to prep unit [%d}] for finalization: %s", proj.UnitID, err.Error())
+			logger.Error("unable to prep unit for finalization", "error", err.Error())
 			msg := "<p>Prep for finalization failed</p>"
 			msg += fmt.Sprintf("<p>DPG Imaging was unable to prep the unit for finalization: %s</p>", err.Error())
-			svc.failStep(proj, "Other", msg)
+			svc.failStep(tgtJob.context(), proj, "Other", msg, newValidationIssue(RuleFinalizePrep, "", err.Error()))
 			return fmt.Errorf("unable to prep unit for finalization: %s", err.Error())
 		}
 
 		if !resp.Success {
-			log.Printf("INFO: unit %d has finalize errors: %v", proj.UnitID, resp.Problems)
+			logger.Info("unit has finalize errors", "problems", resp.Problems)
 			msg := "<p>Prep for finalization failed</p>"
+			issues := make([]validationIssue, 0, len(resp.Problems))
 			for _, p := range resp.Problems {
 				msg += fmt.Sprintf("<p>%s: %s</p>", p.File, p.Problem)
+				issues = append(issues, newValidationIssue(RuleFinalizePrep, p.File, p.Problem))
 			}
-			svc.failStep(proj, "Other", msg)
+			svc.failStep(tgtJob.context(), proj, "Other", msg, issues...)
 			return fmt.Errorf("unit %d has finalization problems", proj.UnitID)
 		}
-		log.Printf("INFO: unit %d data has been finalized", proj.UnitID)
+		logger.Info("unit data has been finalized")
 	}
 
 	// Make sure  directory is clean and in proper structure
-	imagesMovedToFinalize := false
-	unitDir := padLeft(fmt.Sprintf("%d", proj.UnitID), 9)
-	tgtDir := path.Join(svc.ImagesDir, unitDir)
-	if proj.CurrentStep.Name == "Scan" || proj.CurrentStep.Name == "Process" {
-		tgtDir = path.Join(svc.ScanDir, unitDir)
-	}
-
-	// finalize is a special case. In it, files are moved to the finalization directory. Since this step can be retried,
-	// the files may be in finalization instead of the standard imaging directory. Handle that here:
-	if proj.CurrentStep.Name == "Finalize" {
-		// see if the starting directory is present. If not switch to finalization.
-		// At this point, no validation is done. Just checking where files reside
-		if !exists(tgtDir) {
-			log.Printf("INFO: finalization files do not exist at %s, checking alternate location", tgtDir)
-			tgtDir = path.Join(svc.FinalizeDir, unitDir)
-			imagesMovedToFinalize = true
-		}
+	tgtDir, filesMoved, err := svc.stepDirectory(proj, rules)
+	if err != nil {
+		svc.failStep(tgtJob.context(), proj, "Other", fmt.Sprintf("<p>Invalid step configuration: %s</p>", err.Error()))
+		return err
+	}
+	if filesMoved {
+		logger.Info("step files do not exist; use alternate location", "dir", tgtDir)
 	}
 
-	err := svc.validateDirectory(proj, tgtDir)
+	err = svc.validateDirectory(proj, rules, tgtDir, tgtJob)
 	if err != nil {
 		return err
 	}
 
-	// Files get moved in two places; after Process and Finalization. Handle the case of a retried finalize when files have already been moved
-	switch proj.CurrentStep.Name {
-	case "Process":
-		srcDir := path.Join(svc.ScanDir, unitDir)
-		tgtDir := path.Join(svc.ImagesDir, unitDir)
-		moveErr := svc.moveFiles(proj, srcDir, tgtDir)
-		if moveErr != nil {
-			return moveErr
-		}
-	case "Finalize":
-		if !imagesMovedToFinalize {
-			srcDir := path.Join(svc.ImagesDir, unitDir)
-			tgtDir := path.Join(svc.FinalizeDir, unitDir)
-			moveErr := svc.moveFiles(proj, srcDir, tgtDir)
-			if moveErr != nil {
-				log.Printf("ERROR: move files from %s to %s failed: %s", srcDir, tgtDir, moveErr.Error())
+	// Files get moved by steps with a move target, such as Process and Finalize. Handle the case of a retried step when files have already been moved
+	if rules.MoveTo != "" {
+		if filesMoved {
+			logger.Info("files have already been moved", "dir", tgtDir)
+			// the source may have been removed by an earlier attempt that stopped before the move was marked complete
+			if move, err := svc.activeMove(proj.ID, tgtDir); err != nil {
+				logger.Error("unable to check for an unfinished move", "dest", tgtDir, "error", err.Error())
+			} else if move != nil {
+				logger.Info("mark unfinished move complete", "moveID", move.ID, "dest", tgtDir)
+				svc.finishMove(move, tgtJob)
 			}
 		} else {
-			log.Printf("INFO: files for project %d step %s have already been moved to the finalization directory", proj.ID, proj.CurrentStep.Name)
+			destDir, err := svc.stepUnitDir(rules.MoveTo, proj.UnitID)
+			if err != nil {
+				svc.failStep(tgtJob.context(), proj, "Other", fmt.Sprintf("<p>Invalid step configuration: %s</p>", err.Error()))
+				return err
+			}
+			moveErr := svc.moveFiles(proj, tgtDir, destDir, tgtJob)
+			if moveErr != nil {
+				// a failed move has been flagged on the step. It does not stop the request to finalize the unit
+				if rules.MoveTo != StepDirFinalize {
+					return moveErr
+				}
+				logger.Error("move files failed", "src", tgtDir, "dest", destDir, "error", moveErr.Error())
+			}
 		}
 	}
 
-	log.Printf("INFO: project %d step %s successfully finished", proj.ID, proj.CurrentStep.Name)
+	logger.Info("step successfully validated")
 	return nil
 }
 
-func (svc *serviceContext) validateDirectory(proj *project, tgtDir string) error {
-	log.Printf("INFO: validate project %d directory %s", proj.ID, tgtDir)
+// stepDirectory returns the directory holding the unit files for the current step of the project. Steps that
+// move files, like Finalize, can be retried after the move, so the files may be in the move target instead of
+// the step directory; if so, true is also returned
+func (svc *serviceContext) stepDirectory(proj *project, rules *stepValidation) (string, bool, error) {
+	tgtDir, err := svc.stepUnitDir(rules.Directory, proj.UnitID)
+	if err != nil {
+		return "", false, err
+	}
+
+	// see if the starting directory is present. If not switch to the move target.
+	// At this point, no validation is done. Just checking where files reside
+	if rules.MoveTo != "" && !exists(tgtDir) {
+		movedDir, err := svc.stepUnitDir(rules.MoveTo, proj.UnitID)
+		if err != nil {
+			return "", false, err
+		}
+		return movedDir, true, nil
+	}
+	return tgtDir, false, nil
+}
+
+func (svc *serviceContext) validateDirectory(proj *project, rules *stepValidation, tgtDir string, tgtJob *job) error {
+	logger := tgtJob.logger()
+	logger.Info("validate directory", "dir", tgtDir)
 
 	if !exists(tgtDir) {
-		svc.failStep(proj, "Filesystem", fmt.Sprintf("<p>Directory %s does not exist</p>", tgtDir))
+		if !rules.DirectoryExists {
+			logger.Info("directory does not exist and is not required", "dir", tgtDir)
+			return nil
+		}
+		svc.failStep(tgtJob.context(), proj, "Filesystem", fmt.Sprintf("<p>Directory %s does not exist</p>", tgtDir),
+			newValidationIssue(RuleMissingDirectory, "", fmt.Sprintf("Directory %s does not exist", tgtDir)))
 		return fmt.Errorf("%s does not exist", tgtDir)
 	}
 
-	// Scan and Process and Error steps have no checks other than directory existance
-	if proj.CurrentStep.Name == "Scan" || proj.CurrentStep.Name == "Process" || proj.CurrentStep.StepType == 2 {
-		log.Printf("INFO: scan, process and error steps have no validations")
+	// Scan, Process and error steps have no checks other than directory existance
+	if !rules.checksImages() {
+		logger.Info("step has no validations other than directory existence")
 		return nil
 	}
 
-	err := svc.validateDirectoryContent(proj, tgtDir)
-	if err != nil {
-		return err
-	}
-	err = svc.validateImages(proj, tgtDir)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return svc.validateImages(proj, rules, tgtDir, tgtJob)
 }
 
-func (svc *serviceContext) validateDirectoryContent(proj *project, tgtDir string) error {
-	log.Printf("INFO: validate contents of %s", tgtDir)
-	err := filepath.WalkDir(tgtDir, func(fullPath string, entry fs.DirEntry, err error) error {
-		if err != nil || entry.IsDir() {
-			return nil
-		}
+// validateImages checks the unit files with checkStepImages and fails the step with all of the issues found.
+// System files are removed from the directory
+func (svc *serviceContext) validateImages(proj *project, rules *stepValidation, tgtDir string, tgtJob *job) error {
+	logger := tgtJob.logger()
+	logger.Info("validate images", "dir", tgtDir)
+	svc.jobPhase(tgtJob, "validate")
+	_, found := svc.checkStepImages(tgtJob.context(), proj, rules, tgtDir, false, tgtJob)
+	if len(found) == 0 {
+		logger.Info("images and metadata are valid", "dir", tgtDir)
+		return nil
+	}
 
-		lcFN := strings.ToLower(entry.Name())
-		if proj.Workflow.Name == "Manuscript" {
-			if lcFN == "notes.txt" {
-				log.Printf("INFO: found location notes file for manifest project %d", proj.ID)
-				return nil
-			}
+	// the note is flagged with the problem category shared by all of the issues
+	category := found[0].Category
+	errorMsg := ""
+	issues := make([]validationIssue, 0, len(found))
+	for _, issue := range found {
+		if issue.Category != category {
+			category = "Other"
 		}
-
-		if entry.Name() == ".DS_Store" || strings.Index(entry.Name(), ".smbdelete") == 0 {
-			log.Printf("INFO: remove  %s", fullPath)
-			os.Remove(fullPath)
+		if issue.File != "" {
+			errorMsg += fmt.Sprintf("<li>%s - %s</li>", issue.File, issue.Problem)
+			svc.jobProblem(tgtJob, updateProblem{File: path.Base(issue.File), Problem: issue.Problem, Rule: issue.Rule})
 		} else {
-			if filepath.Ext(lcFN) != ".tif" {
-				svc.failStep(proj, "Filesystem", fmt.Sprintf("<p>Unexpected file %s found</p>", fullPath))
-				return fmt.Errorf("found unexpected file %s", fullPath)
-			}
+			errorMsg += fmt.Sprintf("<li>%s</li>", issue.Problem)
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return err
+		issues = append(issues, newValidationIssue(issue.Rule, issue.File, issue.Problem))
 	}
-
-	log.Printf("INFO: %s content is valid", tgtDir)
-	return nil
+	logger.Error("images are not valid", "dir", tgtDir, "issues", len(found))
+	svc.failStep(tgtJob.context(), proj, category, fmt.Sprintf("The following errors were found: <ul>%s</ul>", errorMsg), issues...)
+	return fmt.Errorf("%d problems found in %s", len(found), tgtDir)
 }
 
-func (svc *serviceContext) validateImages(proj *project, tgtDir string) error {
-	log.Printf("INFO: validate images info in %s", tgtDir)
-	highest := -1
-	cnt := 0
-	isManuscript := proj.Workflow.Name == "Manuscript"
-
-	qaFiles := make([]string, 0)
-	errChannel := make(chan updateProblem)
-	var checkWG sync.WaitGroup
-	startTime := time.Now()
+func (svc *serviceContext) moveFiles(proj *project, srcDir string, destDir string, tgtJob *job) error {
+	logger := tgtJob.logger()
+	logger.Info("move files", "src", srcDir, "dest", destDir)
+	move, err := svc.activeMove(proj.ID, destDir)
+	if err != nil {
+		return fmt.Errorf("unable to check for an earlier move to %s: %s", destDir, err.Error())
+	}
 
-	err := filepath.WalkDir(tgtDir, func(fullPath string, entry fs.DirEntry, err error) error {
-		if err != nil || entry.IsDir() {
+	if move != nil {
+		// an earlier attempt was interrupted or failed; finish it rather than starting over
+		logger.Info("resume move", "src", move.SrcDir, "dest", destDir, "copiedFiles", move.CopiedFiles, "totalFiles", move.TotalFiles)
+		if !exists(move.SrcDir) {
+			// the source was removed after the copy was verified but the move was not marked complete
+			svc.finishMove(move, tgtJob)
 			return nil
 		}
-
-		lcFN := strings.ToLower(entry.Name())
-		if lcFN == "notes.txt" {
-			if !isManuscript {
-				log.Printf("ERROR: found notes.text for non-manuscript workflow")
-				svc.failStep(proj, "Filesystem", fmt.Sprintf("<p>Found unexpected notes: %s</p>", fullPath))
-				return fmt.Errorf("unexpected %s", fullPath)
-			}
-			log.Printf("INFO: found notes.txt for Manuscript workflow")
-			return nil
+	} else {
+		srcExist := exists(srcDir)
+		destExist := exists(destDir)
+		if !srcExist && !destExist {
+			svc.failStep(tgtJob.context(), proj, "Filesystem", "<p>Neither start nor finsh directory exists</p>",
+				newValidationIssue(RuleMoveFailed, "", fmt.Sprintf("Neither source %s or destination %s exists", srcDir, destDir)))
+			return fmt.Errorf("neither source %s or destination %s exists", srcDir, destDir)
 		}
 
-		ext := filepath.Ext(entry.Name())
-		noExtFN := strings.TrimSuffix(entry.Name(), ext)
-		seqStr := strings.Split(noExtFN, "_")[1]
-		seq, _ := strconv.Atoi(seqStr)
-		cnt++
-		if seq > highest {
-			highest = seq
+		// Both exist something is wrong. Fail
+		if srcExist && destExist {
+			svc.failStep(tgtJob.context(), proj, "Filesystem", fmt.Sprintf("<p>Both source %s and destination %s exist</p>", srcDir, destDir),
+				newValidationIssue(RuleMoveFailed, "", fmt.Sprintf("Both source %s and destination %s exist", srcDir, destDir)))
+			return fmt.Errorf("both source %s and destination %s exist", srcDir, destDir)
 		}
 
-		// make sure filename is well formed
-		unitDir := padLeft(fmt.Sprintf("%d", proj.UnitID), 9)
-		if unitDir != strings.Split(noExtFN, "_")[0] {
-			log.Printf("ERROR: invalid name %s", fullPath)
-			svc.failStep(proj, "Filename", fmt.Sprintf("<p>Found incorrectly named image file %s.</p>", fullPath))
-			return fmt.Errorf("invalid filename %s", fullPath)
+		// Source is gone but dest exists. No move needed
+		if !srcExist && destExist {
+			logger.Info("source is missing and destination exists; no move needed", "src", srcDir, "dest", destDir)
+			return nil
 		}
 
-		if proj.CurrentStep.Name == "Create Metadata" || proj.CurrentStep.Name == "Finalize" {
-			qaFiles = append(qaFiles, fullPath)
-			if len(qaFiles) == svc.BatchSize {
-				log.Printf("INFO: check headers on batch of %d files", len(qaFiles))
-				filesCopy := make([]string, len(qaFiles))
-				copy(filesCopy, qaFiles)
-				checkWG.Add(1)
-				go func() {
-					defer checkWG.Done()
-					checkExifHeaders(filesCopy, isManuscript, errChannel)
-				}()
-				qaFiles = make([]string, 0)
-			}
+		// See if there is an 'Output' directory for special handling. This is the directory where CaptureOne
+		// places the generated .tif files. Treat it as the source location if it is present
+		outputDir := path.Join(srcDir, "Output")
+		if exists(outputDir) {
+			logger.Info("output directory found; move it instead", "src", outputDir, "dest", destDir)
+			srcDir = outputDir
 		}
 
-		return nil
-	})
-
-	if len(qaFiles) > 0 {
-		log.Printf("INFO: check headers on final batch of %d files", len(qaFiles))
-		checkWG.Add(1)
-		go func() {
-			defer checkWG.Done()
-			checkExifHeaders(qaFiles, isManuscript, errChannel)
-		}()
-	}
-
-	if err != nil {
-		return err
-	}
-	if cnt == 0 {
-		svc.failStep(proj, "Filesystem", fmt.Sprintf("<p>No image files found in %s.</p>", tgtDir))
-		return fmt.Errorf("no files found in %s", tgtDir)
-	}
-	if highest != cnt {
-		svc.failStep(proj, "Filename", fmt.Sprintf("<p>Number of image files does not match highest image sequence number %d.</p>", highest))
-		return fmt.Errorf("count/sequence mismatch in %s", tgtDir)
-	}
-
-	go func() {
-		log.Printf("INFO: await all metadata checks and collect any problems in the response")
-		checkWG.Wait()
-		close(errChannel)
-		elapsed := time.Since(startTime)
-		log.Printf("INFO: all header check requests have completed for %s in %.3f sec", tgtDir, elapsed.Seconds())
-	}()
-
-	errorMsg := ""
-	for problem := range errChannel {
-		if problem.File == "all" {
-			svc.failStep(proj, "Metadata", "<p>Unable to extract metadata from images.</p>")
-			return fmt.Errorf("unable to extract metadata from images")
+		move, err = svc.startMove(proj, srcDir, destDir)
+		if err != nil {
+			svc.failStep(tgtJob.context(), proj, "Filesystem", fmt.Sprintf("<p>Move %s to %s failed: %s</p>", srcDir, destDir, err.Error()),
+				newValidationIssue(RuleMoveFailed, "", err.Error()))
+			return err
+		}
+		if err := svc.Files.mkdir(destDir); err != nil {
+			svc.saveMoveProgress(move, tgtJob, err)
+			svc.failStep(tgtJob.context(), proj, "Filesystem", fmt.Sprintf("<p>Unable to create %s: %s</p>", destDir, err.Error()),
+				newValidationIssue(RuleMoveFailed, "", fmt.Sprintf("Unable to create %s: %s", destDir, err.Error())))
+			return fmt.Errorf("unable to create destination %s: %s", destDir, err.Error())
 		}
-		errorMsg += fmt.Sprintf("<li>%s - %s</li>", path.Base(problem.File), problem.Problem)
-	}
-
-	if errorMsg != "" {
-		svc.failStep(proj, "Metadata", fmt.Sprintf("The following errors were found: <ul>%s<ul>", errorMsg))
-		return fmt.Errorf("one or mor images has metadata errors")
-	}
-
-	log.Printf("INFO: %s images and metadata are valid", tgtDir)
-	return nil
-}
-
-func (svc *serviceContext) moveFiles(proj *project, srcDir string, destDir string) error {
-	log.Printf("INFO: move project %d files from %s to %s", proj.ID, srcDir, destDir)
-	srcExist := exists(srcDir)
-	destExist := exists(destDir)
-	if !srcExist && !destExist {
-		svc.failStep(proj, "Filesystem", "<p>Neither start nor finsh directory exists</p>")
-		return fmt.Errorf("neither source %s or destination %s exists", srcDir, destDir)
-	}
-
-	// Both exist something is wrong. Fail
-	if srcExist && destExist {
-		svc.failStep(proj, "Filesystem", fmt.Sprintf("<p>Both source %s and destination %s exist</p>", srcDir, destDir))
-		return fmt.Errorf("both source %s and destination %s exist", srcDir, destDir)
-	}
-
-	// Source is gone but dest exists. No move needed
-	if !srcExist && destExist {
-		log.Printf("source %s is missing and destination %s exists; no move needed", srcDir, destDir)
-		return nil
-	}
-
-	// See if there is an 'Output' directory for special handling. This is the directory where CaptureOne
-	// places the generated .tif files. Treat it as the source location if it is present
-	outputDir := path.Join(srcDir, "Output")
-	if exists(outputDir) {
-		log.Printf("INFO: output directory %s found; moving it to %s", outputDir, destDir)
-		srcDir = outputDir
 	}
 
-	log.Printf("INFO: recursively copy %s to %s", srcDir, destDir)
+	logger.Info("copy files", "src", move.SrcDir, "dest", destDir, "files", move.TotalFiles, "bytes", move.TotalBytes, "checksum", move.Checksum)
 	startTime := time.Now()
-	cmdArray := []string{"-R", srcDir, destDir}
-	_, err := exec.Command("cp", cmdArray...).Output()
+	manifest, err := svc.copyMoveFiles(move, tgtJob)
 	if err != nil {
-		svc.failStep(proj, "Filesystem", fmt.Sprintf("<p>Move %s to %s failed: %s</p>", srcDir, destDir, err.Error()))
-		return fmt.Errorf("unable to copy source %s to destination %s: %s", srcDir, destDir, err.Error())
+		// the copied files are kept so the move can resume when the step is finished again
+		svc.failStep(tgtJob.context(), proj, "Filesystem", fmt.Sprintf("<p>Move %s to %s failed: %s</p><p>Finish the step again to resume the move.</p>",
+			move.SrcDir, destDir, err.Error()), newValidationIssue(RuleMoveFailed, "", err.Error()))
+		return fmt.Errorf("unable to copy source %s to destination %s: %s", move.SrcDir, destDir, err.Error())
 	}
-	elapsed := time.Since(startTime)
-	elapsedMS := int64(elapsed / time.Millisecond)
-	log.Printf("INFO: copy from %s to %s completed in %d ms", srcDir, destDir, elapsedMS)
+	logger.Info("copy completed and verified", "src", move.SrcDir, "dest", destDir, "files", len(manifest.Entries), "bytes", move.CopiedBytes,
+		"elapsedMS", time.Since(startTime).Milliseconds())
 
-	log.Printf("INFO: cleanup original %s", srcDir)
-	cmdArray = []string{"-r", srcDir}
-	_, err = exec.Command("rm", cmdArray...).Output()
+	// the source is only removed once there is a manifest for the verified copy
+	if err := writeManifest(destDir, manifest); err != nil {
+		svc.failStep(tgtJob.context(), proj, "Filesystem", fmt.Sprintf("<p>Unable to write the manifest for %s: %s</p>", destDir, err.Error()),
+			newValidationIssue(RuleMoveFailed, "", fmt.Sprintf("Unable to write the manifest for %s: %s", destDir, err.Error())))
+		return fmt.Errorf("unable to write manifest for %s: %s", destDir, err.Error())
+	}
+
+	logger.Info("cleanup original files", "src", move.SrcDir)
+	err = svc.Files.removeAll(move.SrcDir)
 	if err != nil {
-		log.Printf("WARN: unable to remove %s after copy to %s", srcDir, destDir)
+		logger.Warn("unable to remove source after copy", "src", move.SrcDir, "dest", destDir, "error", err.Error())
 	}
+	svc.finishMove(move, tgtJob)
 
-	log.Printf("INFO: files successfully moved to %s", destDir)
+	logger.Info("files successfully moved", "dest", destDir)
 	return nil
 }
-
-func (svc *serviceContext) nextStepName(proj *project) string {
-	nextStepID := proj.CurrentStep.NextStepID
-	var nextStep step
-	resp := svc.DB.First(&nextStep, nextStepID)
-	if resp.Error != nil {
-		return "Unknown"
-	}
-	return nextStep.Name
-}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testUnitID uint = 12
+
+var (
+	scanner  = jwtClaims{UserID: 1, ComputeID: "scan1", Role: "student"}
+	reviewer = jwtClaims{UserID: 2, ComputeID: "qa2", Role: "student"}
+)
+
+func newWorkflowTestService(t *testing.T) *testService {
+	ts := newTestService(t)
+	ts.createWorkflow(t)
+	ts.trackSys.addStaff(1, "scan1", 2)
+	ts.trackSys.addStaff(2, "qa2", 2)
+	ts.trackSys.addStaff(3, "qa3", 2)
+	ts.trackSys.addStaff(4, "super4", 1)
+	return ts
+}
+
+// finishStep posts a finish for the current step of the project and runs the queued job
+func (ts *testService) finishStep(t *testing.T, proj *project, claims jwtClaims) *job {
+	t.Helper()
+	projID := fmt.Sprintf("%d", proj.ID)
+	c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/finish", map[string]uint{"durationMins": 10}, claims, "id", projID)
+	ts.finishProjectStep(c)
+	expectStatus(t, resp, http.StatusAccepted)
+	return ts.runQueuedJob(t, resp)
+}
+
+// runQueuedJob runs the job in the response from a request that queued one and returns the job once it is done
+func (ts *testService) runQueuedJob(t *testing.T, resp *httptest.ResponseRecorder) *job {
+	t.Helper()
+	var queued job
+	if err := json.Unmarshal(resp.Body.Bytes(), &queued); err != nil {
+		t.Fatalf("invalid job response %s: %s", resp.Body.String(), err.Error())
+	}
+	var tgtJob job
+	if err := ts.DB.First(&tgtJob, queued.ID).Error; err != nil {
+		t.Fatalf("unable to get job %d: %s", queued.ID, err.Error())
+	}
+	if tgtJob.Status != JobPending {
+		t.Fatalf("expected job %d to be pending, got %s", tgtJob.ID, tgtJob.Status)
+	}
+	ts.runJob(&tgtJob)
+	return &tgtJob
+}
+
+func (ts *testService) loadProject(t *testing.T, projID uint) *project {
+	t.Helper()
+	var proj project
+	if err := ts.DB.Preload("CurrentStep").First(&proj, projID).Error; err != nil {
+		t.Fatalf("unable to get project %d: %s", projID, err.Error())
+	}
+	return &proj
+}
+
+// loadAssignments returns the project assignments in the order they were made
+func (ts *testService) loadAssignments(t *testing.T, projID uint) []assignment {
+	t.Helper()
+	var out []assignment
+	if err := ts.DB.Where("project_id=?", projID).Order("id asc").Find(&out).Error; err != nil {
+		t.Fatalf("unable to get project %d assignments: %s", projID, err.Error())
+	}
+	return out
+}
+
+func (ts *testService) openIssues(t *testing.T, projID uint) []validationIssue {
+	t.Helper()
+	var out []validationIssue
+	if err := ts.DB.Where("project_id=? and resolved_at is null", projID).Order("id asc").Find(&out).Error; err != nil {
+		t.Fatalf("unable to get project %d issues: %s", projID, err.Error())
+	}
+	return out
+}
+
+func (ts *testService) problemNotes(t *testing.T, projID uint) []note {
+	t.Helper()
+	var out []note
+	if err := ts.DB.Where("project_id=? and note_type=?", projID, 2).Order("id asc").Find(&out).Error; err != nil {
+		t.Fatalf("unable to get project %d notes: %s", projID, err.Error())
+	}
+	return out
+}
+
+func expectIssueRules(t *testing.T, issues []validationIssue, rules ...string) {
+	t.Helper()
+	got := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		got = append(got, issue.Rule)
+	}
+	if strings.Join(got, ",") != strings.Join(rules, ",") {
+		t.Fatalf("expected issues %v, got %v", rules, got)
+	}
+}
+
+func TestFinishProjectStep(t *testing.T) {
+	t.Run("step not started", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepPending, 2)
+		projID := fmt.Sprintf("%d", proj.ID)
+		c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/finish", map[string]uint{"durationMins": 10}, reviewer, "id", projID)
+		ts.finishProjectStep(c)
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("finish already in process", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepWorking, 2)
+		projID := fmt.Sprintf("%d", proj.ID)
+		c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/finish", map[string]uint{"durationMins": 10}, reviewer, "id", projID)
+		ts.finishProjectStep(c)
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("valid images advance to the next step", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepStarted, 1, 2)
+		writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif", "000000012_0003.tif")
+
+		projID := fmt.Sprintf("%d", proj.ID)
+		c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/finish", map[string]uint{"durationMins": 10}, reviewer, "id", projID)
+		ts.finishProjectStep(c)
+		expectStatus(t, resp, http.StatusAccepted)
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[1].Status != StepWorking || assigns[1].DurationMinutes != 10 {
+			t.Fatalf("expected working assignment with 10 minutes, got status %d with %d minutes", assigns[1].Status, assigns[1].DurationMinutes)
+		}
+
+		tgtJob := ts.runQueuedJob(t, resp)
+		if tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		updated := ts.loadProject(t, proj.ID)
+		if *updated.CurrentStepID != testStepFinalize || updated.OwnerID != nil {
+			t.Errorf("expected unclaimed Finalize step, got step %d owner %v", *updated.CurrentStepID, updated.OwnerID)
+		}
+		if updated.ImageCount != 3 {
+			t.Errorf("expected image count 3, got %d", updated.ImageCount)
+		}
+		assigns := ts.loadAssignments(t, proj.ID)
+		if len(assigns) != 2 || assigns[1].Status != StepFinished || assigns[1].FinishedAt == nil {
+			t.Errorf("expected finished QA assignment and no new assignment, got %+v", assigns)
+		}
+	})
+
+	t.Run("project reassigned before the job runs", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepStarted, 1, 2)
+		writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+
+		projID := fmt.Sprintf("%d", proj.ID)
+		c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/finish", map[string]uint{"durationMins": 10}, reviewer, "id", projID)
+		ts.finishProjectStep(c)
+		expectStatus(t, resp, http.StatusAccepted)
+		assigns := ts.loadAssignments(t, proj.ID)
+		if err := ts.DB.Model(&assigns[1]).Update("status", StepReassigned).Error; err != nil {
+			t.Fatalf("unable to reassign project: %s", err.Error())
+		}
+
+		tgtJob := ts.runQueuedJob(t, resp)
+		if tgtJob.Status != JobFailed || !strings.Contains(tgtJob.Error, "no longer being finished") {
+			t.Fatalf("expected job to fail on the changed assignment, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		if updated := ts.loadProject(t, proj.ID); *updated.CurrentStepID != testStepQA {
+			t.Errorf("expected project to stay on the QA step, got step %d", *updated.CurrentStepID)
+		}
+		if notes := ts.problemNotes(t, proj.ID); len(notes) > 0 {
+			t.Errorf("expected no problem notes, got %+v", notes)
+		}
+	})
+
+	t.Run("files are moved to the next step directory", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepProcess, StepStarted, 1)
+		writeUnitFiles(t, ts.ScanDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif", "Output/000000012_0003.tif")
+
+		tgtJob := ts.finishStep(t, proj, scanner)
+		if tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		// CaptureOne output is moved in place of the directory that holds it
+		unitDir := path.Join(ts.ImagesDir, "000000012")
+		if !exists(path.Join(unitDir, "000000012_0003.tif")) || exists(path.Join(unitDir, "000000012_0001.tif")) {
+			t.Errorf("expected only the Output directory files in %s", unitDir)
+		}
+		if exists(path.Join(ts.ScanDir, "000000012", "Output")) {
+			t.Errorf("expected the moved files to be removed from the scan directory")
+		}
+		var move directoryMove
+		ts.DB.Where("project_id=?", proj.ID).First(&move)
+		if move.Status != MoveComplete || move.CopiedFiles != 1 {
+			t.Errorf("expected completed move of 1 file, got %s with %d files", move.Status, move.CopiedFiles)
+		}
+		updated := ts.loadProject(t, proj.ID)
+		if *updated.CurrentStepID != testStepQA || updated.OwnerID != nil {
+			t.Errorf("expected unclaimed QA step, got step %d owner %v", *updated.CurrentStepID, updated.OwnerID)
+		}
+		if updated.ImageCount != 0 {
+			t.Errorf("expected Process to leave the image count alone, got %d", updated.ImageCount)
+		}
+	})
+
+	t.Run("invalid images fail the step", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepStarted, 1, 2)
+		writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0003.tif")
+
+		tgtJob := ts.finishStep(t, proj, reviewer)
+		if tgtJob.Status != JobFailed {
+			t.Fatalf("expected failed job, got %s", tgtJob.Status)
+		}
+		updated := ts.loadProject(t, proj.ID)
+		if *updated.CurrentStepID != testStepQA {
+			t.Errorf("expected project to stay on QA, got step %d", *updated.CurrentStepID)
+		}
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[1].Status != StepError {
+			t.Errorf("expected assignment error status, got %d", assigns[1].Status)
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleSequenceMismatch)
+		if notes := ts.problemNotes(t, proj.ID); len(notes) != 1 {
+			t.Errorf("expected 1 problem note, got %d", len(notes))
+		}
+
+		// fixing the problem and finishing again resolves the issue
+		writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0002.tif")
+		tgtJob = ts.finishStep(t, proj, reviewer)
+		if tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID))
+	})
+
+	t.Run("final step requests finalization", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepFinalize, StepStarted, 4)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif", "000000012_0002.tif")
+		for _, fn := range []string{"000000012_0001.tif", "000000012_0002.tif"} {
+			ts.exifTool.metadata[path.Join(unitDir, fn)] = exifData{Title: "Page " + fn}
+		}
+
+		tgtJob := ts.finishStep(t, proj, jwtClaims{UserID: 4, ComputeID: "super4", Role: "supervisor"})
+		if tgtJob.Status != JobFinished {
+			t.Fatalf("expected finished job, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		if len(ts.jobs.finalized) != 1 || ts.jobs.finalized[0] != testUnitID {
+			t.Errorf("expected finalize request for unit %d, got %v", testUnitID, ts.jobs.finalized)
+		}
+		if !exists(path.Join(ts.FinalizeDir, "000000012", "000000012_0002.tif")) {
+			t.Errorf("expected files to be moved to the finalize directory")
+		}
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[0].Status != StepFinalizing {
+			t.Errorf("expected finalizing assignment, got status %d", assigns[0].Status)
+		}
+	})
+
+	t.Run("unit is locked by another change", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepQA, StepStarted, 1, 2)
+		writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif")
+		lock, lockErr := ts.acquireUnitLock(context.Background(), "12", "qa3", "rotate")
+		if lockErr != nil {
+			t.Fatalf("unable to lock unit: %s", lockErr.Message)
+		}
+		defer ts.releaseUnitLock(lock)
+
+		tgtJob := ts.finishStep(t, proj, reviewer)
+		if tgtJob.Status != JobFailed || !strings.Contains(tgtJob.Error, "qa3 is running rotate") {
+			t.Fatalf("expected job to fail on the lock, got %s: %s", tgtJob.Status, tgtJob.Error)
+		}
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[1].Status != StepError {
+			t.Errorf("expected assignment error status so the step can be finished again, got %d", assigns[1].Status)
+		}
+		if updated := ts.loadProject(t, proj.ID); *updated.CurrentStepID != testStepQA {
+			t.Errorf("expected project to stay on QA, got step %d", *updated.CurrentStepID)
+		}
+	})
+
+	t.Run("failed finalization request", func(t *testing.T) {
+		ts := newWorkflowTestService(t)
+		proj := ts.createProject(t, testUnitID, testStepFinalize, StepStarted, 4)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, "000000012_0001.tif")
+		ts.exifTool.metadata[path.Join(unitDir, "000000012_0001.tif")] = exifData{Title: "Cover"}
+		ts.jobs.finalizeErr = errors.New("dpg-jobs is unavailable")
+
+		// an issue left by an earlier attempt no longer applies
+		ts.DB.Create(&validationIssue{ProjectID: proj.ID, StepID: testStepFinalize, Rule: RuleMissingTitle, Severity: IssueError})
+
+		tgtJob := ts.finishStep(t, proj, jwtClaims{UserID: 4, ComputeID: "super4", Role: "supervisor"})
+		if tgtJob.Status != JobFailed {
+			t.Fatalf("expected failed job, got %s", tgtJob.Status)
+		}
+		if assigns := ts.loadAssignments(t, proj.ID); assigns[0].Status != StepError {
+			t.Errorf("expected assignment error status, got %d", assigns[0].Status)
+		}
+		notes := ts.problemNotes(t, proj.ID)
+		if len(notes) != 1 || !strings.Contains(notes[0].Note, "dpg-jobs is unavailable") {
+			t.Errorf("expected a note with the finalize failure, got %+v", notes)
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID))
+	})
+}
+
+func TestRejectProjectStep(t *testing.T) {
+	ts := newWorkflowTestService(t)
+	proj := ts.createProject(t, testUnitID, testStepQA, StepStarted, 1, 2)
+	projID := fmt.Sprintf("%d", proj.ID)
+
+	c, resp := newTestRequest(http.MethodPost, "/api/projects/"+projID+"/reject", map[string]uint{"durationMins": 15}, reviewer, "id", projID)
+	ts.rejectProjectStep(c)
+	expectStatus(t, resp, http.StatusOK)
+
+	var out project
+	if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid project response: %s", err.Error())
+	}
+	if out.CurrentStep == nil || out.CurrentStep.ID != testStepError {
+		t.Errorf("expected response with the Error step, got %+v", out.CurrentStep)
+	}
+
+	updated := ts.loadProject(t, proj.ID)
+	if *updated.CurrentStepID != testStepError || updated.OwnerID == nil || *updated.OwnerID != 1 {
+		t.Errorf("expected Error step owned by the scanner, got step %d owner %v", *updated.CurrentStepID, updated.OwnerID)
+	}
+	assigns := ts.loadAssignments(t, proj.ID)
+	if len(assigns) != 3 {
+		t.Fatalf("expected a new assignment, got %d assignments", len(assigns))
+	}
+	if assigns[1].Status != StepRejected || assigns[1].DurationMinutes != 15 || assigns[1].FinishedAt == nil {
+		t.Errorf("expected rejected assignment with 15 minutes, got %+v", assigns[1])
+	}
+	if assigns[2].StepID != testStepError || assigns[2].StaffMemberID != 1 || assigns[2].Status != StepPending {
+		t.Errorf("expected pending Error assignment for the scanner, got %+v", assigns[2])
+	}
+
+	c, resp = newTestRequest(http.MethodPost, "/api/projects/"+projID+"/reject", "bad", reviewer, "id", projID)
+	ts.rejectProjectStep(c)
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestValidateImages(t *testing.T) {
+	headerRules := &stepValidation{Directory: StepDirImages, DirectoryExists: true, OnlyTif: true, SequenceContiguous: true,
+		TitleRequired: true, LocationRequired: true}
+
+	setup := func(t *testing.T, files ...string) (*testService, *project, string) {
+		ts := newWorkflowTestService(t)
+		created := ts.createProject(t, testUnitID, testStepFinalize, StepWorking, 4)
+		unitDir := writeUnitFiles(t, ts.ImagesDir, testUnitID, files...)
+		for _, fn := range files {
+			ts.exifTool.metadata[path.Join(unitDir, fn)] = exifData{Title: "Title " + fn, Location: "Box 1/Folder 2"}
+		}
+		return ts, ts.loadProject(t, created.ID), unitDir
+	}
+
+	t.Run("valid images", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "000000012_0002.tif", "000000012_0003.tif", "notes.txt")
+		headerRules := *headerRules
+		headerRules.AllowNotes = true
+		if err := ts.validateImages(proj, &headerRules, unitDir, nil); err != nil {
+			t.Fatalf("expected valid images, got %s", err.Error())
+		}
+		if len(ts.exifTool.commands) != 2 {
+			t.Errorf("expected headers to be checked in 2 batches, got %d", len(ts.exifTool.commands))
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID))
+	})
+
+	t.Run("unexpected notes", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "notes.txt")
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected notes to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleUnexpectedNotes)
+	})
+
+	t.Run("incorrectly named image", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "000000013_0002.tif")
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected bad file name to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleInvalidFilename)
+	})
+
+	t.Run("all issues are found", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "000000012_000x.tif", "notes.txt")
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected a non-numeric sequence and notes to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleInvalidFilename, RuleUnexpectedNotes)
+	})
+
+	t.Run("duplicate sequence", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "000000012_0002.tif", "000000012_02.tif")
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected a duplicate sequence number to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleSequenceMismatch, RuleSequenceMismatch)
+	})
+
+	t.Run("system files", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", ".DS_Store", ".smbdelete0001")
+		seqRules := &stepValidation{Directory: StepDirImages, DirectoryExists: true, SequenceContiguous: true}
+		if _, issues := ts.checkStepImages(context.Background(), proj, seqRules, unitDir, true, nil); len(issues) > 0 {
+			t.Fatalf("expected system files to be skipped, got %+v", issues)
+		}
+		expectFileContent(t, path.Join(unitDir, ".DS_Store"), ".DS_Store")
+
+		if err := ts.validateImages(proj, seqRules, unitDir, nil); err != nil {
+			t.Fatalf("expected valid images, got %s", err.Error())
+		}
+		for _, fn := range []string{".DS_Store", ".smbdelete0001"} {
+			if exists(path.Join(unitDir, fn)) {
+				t.Errorf("expected %s to be removed", fn)
+			}
+		}
+	})
+
+	t.Run("sequence gap", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "000000012_0002.tif", "000000012_0004.tif")
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected sequence gap to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleSequenceMismatch)
+	})
+
+	t.Run("no images", func(t *testing.T) {
+		ts, proj, unitDir := setup(t)
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected empty directory to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleNoImages)
+	})
+
+	t.Run("missing metadata", func(t *testing.T) {
+		ts, proj, unitDir := setup(t, "000000012_0001.tif", "000000012_0002.tif", "000000012_0003.tif")
+		ts.exifTool.metadata[path.Join(unitDir, "000000012_0002.tif")] = exifData{Location: "UNK"}
+		tgtJob := &job{ID: 1, JobType: jobFinishStep, ProjectID: proj.ID, UnitID: testUnitID}
+		if err := ts.validateImages(proj, headerRules, unitDir, tgtJob); err == nil {
+			t.Fatalf("expected missing metadata to fail validation")
+		}
+		issues := ts.openIssues(t, proj.ID)
+		expectIssueRules(t, issues, RuleMissingTitle, RuleIncompleteLocation)
+		for _, issue := range issues {
+			if issue.File != "000000012_0002.tif" {
+				t.Errorf("expected issue for 000000012_0002.tif, got %s", issue.File)
+			}
+		}
+		if len(tgtJob.Problems) != 2 || tgtJob.Processed != 3 {
+			t.Errorf("expected 2 job problems and 3 processed files, got %d and %d", len(tgtJob.Problems), tgtJob.Processed)
+		}
+		notes := ts.problemNotes(t, proj.ID)
+		if len(notes) != 1 || !strings.HasSuffix(notes[0].Note, "</ul>") {
+			t.Errorf("expected a note with a list of the problems, got %+v", notes)
+		}
+	})
+
+	t.Run("unreadable metadata", func(t *testing.T) {
+		files := make([]string, 0)
+		for seq := 1; seq <= 8; seq++ {
+			files = append(files, fmt.Sprintf("000000012_%04d.tif", seq))
+		}
+		ts, proj, unitDir := setup(t, files...)
+		ts.BatchSize = 1
+		ts.exifTool.err = errors.New("exiftool is not running")
+		startGoroutines := runtime.NumGoroutine()
+
+		if err := ts.validateImages(proj, headerRules, unitDir, nil); err == nil {
+			t.Fatalf("expected unreadable metadata to fail validation")
+		}
+		expectIssueRules(t, ts.openIssues(t, proj.ID), RuleMetadataUnreadable)
+
+		// the header checks still running when validation stopped must all finish
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > startGoroutines && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if cnt := runtime.NumGoroutine(); cnt > startGoroutines {
+			t.Errorf("expected header checks to finish; %d goroutines are left running", cnt-startGoroutines)
+		}
+	})
+}
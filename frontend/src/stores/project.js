@@ -8,7 +8,8 @@ export const useProjectStore = defineStore('project', {
       detail: null,
       statusCheckIntervalID: -1,
       working: true,
-      missingComponents: []
+      missingComponents: [],
+      unexpectedFiles: []
    }),
    getters: {
       hasMissingComponents: state => {
@@ -102,6 +103,7 @@ export const useProjectStore = defineStore('project', {
             this.working = false
             if (!this.detail.finishedAt ) {
                axios.put(`/api/projects/${projectID}/images/count`)
+               this.getUnexpectedFiles()
             }
          }).catch( e => {
 
@@ -109,6 +111,14 @@ export const useProjectStore = defineStore('project', {
             this.working = false
          })
       },
+      async getUnexpectedFiles() {
+         this.unexpectedFiles = []
+         return axios.get(`/api/projects/${this.detail.id}/files/unexpected`).then(response => {
+            this.unexpectedFiles = response.data
+         }).catch( e => {
+            console.error("unable to get unexpected files: "+e)
+         })
+      },
       cancelStatusPolling() {
          if (this.statusCheckIntervalID > -1) {
             clearInterval( this.statusCheckIntervalID )
@@ -180,12 +190,13 @@ export const useProjectStore = defineStore('project', {
       },
       finishStep(durationMins) {
          this.working = true
+         const system = useSystemStore()
          let isFinalize = (this.detail.assignments[0].step.name == "Finalize")
          axios.post(`/api/projects/${this.detail.id}/finish`, {durationMins: durationMins} ).then(response => {
-            this.detail.owner = response.data.owner
-            this.detail.currentStep = response.data.currentStep
-            this.detail.assignments = response.data.assignments
-            this.detail.notes = response.data.notes
+            return system.waitForJob( response.data.id )
+         }).then( () => {
+            return this.getProject( this.detail.id )
+         }).then( () => {
             this.working = false
             if ( isFinalize ) {
                this.pollProjectStatus()
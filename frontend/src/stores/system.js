@@ -1,5 +1,6 @@
 import { defineStore } from 'pinia'
 import axios from 'axios'
+import { useUserStore } from './user'
 
 export const useSystemStore = defineStore('system', {
 	state: () => ({
@@ -129,6 +130,71 @@ export const useSystemStore = defineStore('system', {
       clearError() {
          this.error = ""
          this.showError = false
+      },
+      async waitForJob( jobID ) {
+         // poll a background job until it has finished or failed, then resolve with the final job
+         return new Promise( (resolve, reject) => {
+            let intervalID = setInterval( () => {
+               axios.get(`/api/jobs/${jobID}`).then(response => {
+                  if (response.data.status == "finished" || response.data.status == "failed") {
+                     clearInterval( intervalID )
+                     resolve( response.data )
+                  }
+               }).catch( e => {
+                  clearInterval( intervalID )
+                  reject( e )
+               })
+            }, 2000)
+         })
+      },
+      async streamJob( jobID, onEvent ) {
+         // stream server-sent events for a background job. Each event is passed to onEvent as it arrives
+         // and the promise resolves with the final job. EventSource cannot send the auth header, so read the
+         // stream with fetch. If the stream is unavailable, fall back to polling for the result.
+         const user = useUserStore()
+         let resp = null
+         try {
+            resp = await fetch(`/api/jobs/${jobID}/events`, {headers: {Authorization: 'Bearer ' + user.jwt}})
+         } catch (e) {
+            return this.waitForJob( jobID )
+         }
+         if ( !resp.ok || !resp.body ) {
+            return this.waitForJob( jobID )
+         }
+
+         const reader = resp.body.pipeThrough( new TextDecoderStream() ).getReader()
+         let buffer = ""
+         while ( true ) {
+            const { value, done } = await reader.read()
+            if ( done ) break
+            buffer += value
+            let msgEnd = buffer.indexOf("\n\n")
+            while ( msgEnd > -1 ) {
+               let eventType = "message"
+               let data = ""
+               buffer.substring(0, msgEnd).split("\n").forEach( line => {
+                  if ( line.startsWith("event:") ) {
+                     eventType = line.substring(6).trim()
+                  } else if ( line.startsWith("data:") ) {
+                     data += line.substring(5).trim()
+                  }
+               })
+               buffer = buffer.substring(msgEnd+2)
+               msgEnd = buffer.indexOf("\n\n")
+
+               let evt = JSON.parse(data)
+               if ( eventType == "done" ) {
+                  reader.cancel()
+                  return evt
+               }
+               if ( onEvent ) {
+                  onEvent(eventType, evt)
+               }
+            }
+         }
+
+         // stream closed before the job was done
+         return this.waitForJob( jobID )
       },
 		getVersion() {
          axios.get("/version").then(response => {
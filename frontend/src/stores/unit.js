@@ -27,7 +27,8 @@ export const useUnitStore = defineStore('unit', {
       lastURL: "",
       currPage: 0,
       pageSize: 20,
-      containerType: null
+      containerType: null,
+      jobProgress: null,
    }),
    getters: {
       pageInfoURLs: state => {
@@ -311,15 +312,15 @@ export const useUnitStore = defineStore('unit', {
             system.setError("Sequece can only by added to images that have already been loaded.<br/>Either go to each page and try again, or limit the sequence range to the currently visible page.")
             this.working = false
          } else {
-            axios.post(`/api/units/${this.unitID}/update`, data).then( resp => {
+            this.submitMetadataUpdate(data).then( resp => {
                for (let i=this.rangeStartIdx; i<=this.rangeEndIdx; i++) {
                   let update = data.shift()
                   this.masterFiles[i].title = update.value
                }
                this.working = false
-               if (resp.data.success == false) {
+               if (resp.success == false) {
                   system.setError("Some images were not updated")
-                  this.setMasterFileProblems(resp.data.problems)
+                  this.setMasterFileProblems(resp.problems)
                }
             }).catch( e => {
                system.setError(e)
@@ -351,15 +352,15 @@ export const useUnitStore = defineStore('unit', {
             pageCnt+=1
          }
 
-         axios.post(`/api/units/${this.unitID}/update`, data).then( resp => {
+         this.submitMetadataUpdate(data).then( resp => {
             for (let i=this.rangeStartIdx; i<=this.rangeEndIdx; i++) {
                let update = data.shift()
                this.masterFiles[i].title = update.value
             }
             this.working = false
-            if (resp.data.success == false) {
+            if (resp.success == false) {
                system.setError("Some images were not renumbered")
-               this.setMasterFileProblems(resp.data.problems)
+               this.setMasterFileProblems(resp.problems)
             }
          }).catch( e => {
             system.setError(e)
@@ -374,15 +375,15 @@ export const useUnitStore = defineStore('unit', {
             let mf = this.masterFiles[i]
             data.push( {file: mf.path, field: "component", value: componentID})
          }
-         return axios.post(`/api/units/${this.unitID}/update`, data).then( resp => {
+         return this.submitMetadataUpdate(data).then( resp => {
             for (let i=this.rangeStartIdx; i<=this.rangeEndIdx; i++) {
                let update = data.shift()
                this.masterFiles[i].componentID = update.value
             }
             this.working = false
-            if (resp.data.success == false) {
+            if (resp.success == false) {
                system.setError("Some images could not be linked with component "+componentID)
-               this.setMasterFileProblems(resp.data.problems)
+               this.setMasterFileProblems(resp.problems)
             }
          }).catch( e => {
             system.setError(e)
@@ -397,7 +398,7 @@ export const useUnitStore = defineStore('unit', {
             let mf = this.masterFiles[i]
             data.push( {file: mf.path, field: field, value: value})
          }
-         return axios.post(`/api/units/${this.unitID}/update`, data).then( resp => {
+         return this.submitMetadataUpdate(data).then( resp => {
             for (let i=this.rangeStartIdx; i<=this.rangeEndIdx; i++) {
                let update = data.shift()
                if (field == "folder") {
@@ -417,9 +418,9 @@ export const useUnitStore = defineStore('unit', {
                }
             }
             this.working = false
-            if (resp.data.success == false) {
+            if (resp.success == false) {
                system.setError("Folder assignment failed for some images")
-               this.setMasterFileProblems(resp.data.problems)
+               this.setMasterFileProblems(resp.problems)
             }
          }).catch( e => {
             system.setError(e)
@@ -427,6 +428,53 @@ export const useUnitStore = defineStore('unit', {
       },
 
 
+      async submitMetadataUpdate( data ) {
+         // batch updates run as a background job. Follow its progress and report success and any problems
+         return axios.post(`/api/units/${this.unitID}/update`, data).then( resp => {
+            return this.followJob( resp.data.id )
+         }).then( job => {
+            if (job.status == "failed") {
+               throw job.error
+            }
+            return {success: job.problems.length == 0, problems: job.problems}
+         })
+      },
+      undoMetadataChanges( redo ) {
+         // undo or redo the last metadata change made by the current user. A batch update is undone as a whole
+         const system = useSystemStore()
+         this.working = true
+         let action = redo ? "redo" : "undo"
+         axios.post(`/api/units/${this.unitID}/${action}`).then( resp => {
+            return this.followJob( resp.data.id )
+         }).then( job => {
+            if (job.status == "failed") {
+               throw job.error
+            }
+            window.location.reload()
+         }).catch( e => {
+            this.working = false
+            system.setError(e)
+         })
+      },
+      async followJob( jobID ) {
+         // stream progress events for a unit job. Problems are flagged on the master files as they are found
+         const system = useSystemStore()
+         this.jobProgress = {phase: "", processed: 0, total: 0, problems: 0}
+         return system.streamJob( jobID, (eventType, evt) => {
+            if ( eventType == "problem" ) {
+               this.setMasterFileProblems( [evt.problem] )
+               this.jobProgress.problems++
+            }
+            if ( evt.phase ) {
+               this.jobProgress.phase = evt.phase
+            }
+            this.jobProgress.processed = evt.processed
+            this.jobProgress.total = evt.total
+         }).finally( () => {
+            this.jobProgress = null
+         })
+      },
+
       async updateMasterFileMetadata(file, field, value) {
          const system = useSystemStore()
          this.working = true
@@ -482,7 +530,12 @@ export const useUnitStore = defineStore('unit', {
             }
 
          })
-         axios.post(`/api/units/${this.unitID}/rename`, data).then(() => {
+         axios.post(`/api/units/${this.unitID}/rename`, data).then( resp => {
+            return this.followJob( resp.data.id )
+         }).then( job => {
+            if (job.status == "failed") {
+               throw job.error
+            }
             window.location.reload()
          }).catch( e => {
             system.setError(e)
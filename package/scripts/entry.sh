@@ -3,9 +3,13 @@
 # run application
 #
 
-# run the server
+# secrets are passed in the environment so they do not show up in process listings
+export DPG_JWTKEY=$DPG_JWT_KEY
+export DPG_DBPASS=$DBPASS
+
+# run the server. exec so it gets the stop signal from docker and can drain in-flight work
 umask 0002
-cd bin; ./imagingsvc               \
+cd bin; exec ./imagingsvc          \
    -url $DPG_SERVICE_URL           \
    -images   $DPG_IMAGE_PATH       \
    -scan     $DPG_SCAN_PATH        \
@@ -14,15 +18,10 @@ cd bin; ./imagingsvc               \
    -jobsurl  $DPG_JOBS_URL         \
    -tsurl    $DPG_TRACKSYS_URL     \
    -tsapiurl $DPG_TRACKSYS_API_URL \
-   -jwtkey   $DPG_JWT_KEY          \
    -dbhost   $DBHOST               \
    -dbport   $DBPORT               \
    -dbname   $DBNAME               \
-   -dbuser   $DBUSER               \
-   -dbpass   $DBPASS
-
-# return the status
-exit $?
+   -dbuser   $DBUSER
 
 #
 # end of file